@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+const (
+	capNetAdmin = 12
+	capNetRaw   = 13
+)
+
+// effectiveCapabilities returns the CapEff mask of the running process.
+func effectiveCapabilities() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		line := scn.Text()
+		if strings.HasPrefix(line, "CapEff:") {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		}
+	}
+	if err := scn.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in /proc/self/status")
+}
+
+// checkCapabilities exits right away when we lack the privileges to manage
+// the address, instead of failing once the address has to be configured.
+func checkCapabilities() {
+	if *dryRun {
+		return
+	}
+
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		slog.Warn("Cannot determine process capabilities, skipping check", "error", err)
+		return
+	}
+
+	var hint string
+	if os.Getenv("INVOCATION_ID") != "" {
+		hint = " When running under systemd, add AmbientCapabilities=CAP_NET_ADMIN CAP_NET_RAW to the service."
+	}
+
+	// Address changes and the gratuitous ARP may be delegated to a
+	// privileged helper
+	if caps&(1<<capNetAdmin) == 0 && len(ipmanager.CommandPrefix) == 0 {
+		fatalWithCode(exitPrivileges, "Configuring the virtual IP requires CAP_NET_ADMIN. Run vip-manager as root, grant it CAP_NET_ADMIN or use -command-prefix to run ip through e.g. sudo."+hint)
+	}
+	if caps&(1<<capNetRaw) == 0 && len(ipmanager.CommandPrefix) == 0 {
+		fatalWithCode(exitPrivileges, "Sending gratuitous ARP requires CAP_NET_RAW. Run vip-manager as root, grant it CAP_NET_RAW or use -command-prefix to run arping through e.g. sudo."+hint)
+	}
+	if caps&(1<<capNetRaw) == 0 {
+		slog.Warn("Without CAP_NET_RAW, gratuitous ARP is sent with arping through -command-prefix. IPv6 neighbor advertisements, -arp-probe and the duplicate scan do not work."+hint,
+			"prefix", strings.Join(ipmanager.CommandPrefix, " "))
+	}
+}
+
+// commandErrorExitCode picks the exit code for a command that could not be
+// started at all. A missing binary or missing permissions will not go away
+// by restarting.
+func commandErrorExitCode(err error) int {
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return exitConfigError
+	case errors.Is(err, os.ErrPermission):
+		return exitPrivileges
+	default:
+		return exitFailure
+	}
+}
+
+// checkCommand makes sure that the addresses can be listed, so a missing
+// command or a misconfigured prefix is noticed at startup and not only when
+// the address has to be configured.
+func checkCommand(iface string) {
+	err := ipmanager.CheckCommand(iface)
+	var exitErr *exec.ExitError
+	var cmdErr *ipmanager.CommandError
+	errors.As(err, &cmdErr)
+	switch {
+	case err == nil:
+	case len(ipmanager.CommandPrefix) > 0:
+		fatalWithCode(exitPrivileges, "Running commands with the prefix failed", "prefix", strings.Join(ipmanager.CommandPrefix, " "), "error", err,
+			"failure_class", cmdErr.Class)
+	case errors.As(err, &exitErr):
+		// The command runs, its errors are handled later
+	default:
+		fatalWithCode(commandErrorExitCode(err), "Cannot list the addresses", "iface", iface, "error", err, "failure_class", cmdErr.Class)
+	}
+}
@@ -0,0 +1,71 @@
+package checker
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// How often a skew beyond the threshold is repeated in the log
+const clockSkewWarnInterval = 10 * time.Minute
+
+var clockSkewGauge = metrics.NewGauge("vip_manager_clock_skew_seconds",
+	"Difference between the clock of the DCS and the local clock, from the Date header of the last response, with a resolution of one second.")
+
+// In nanoseconds, see SetClockSkewThreshold
+var clockSkewThreshold int64
+
+// SetClockSkewThreshold sets from which difference between the clocks of
+// this node and the DCS a warning is logged. 0 disables the warning.
+func SetClockSkewThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&clockSkewThreshold, int64(threshold))
+}
+
+// The state of the warning, shared by all checkers
+var clockSkew struct {
+	lock     sync.Mutex
+	warnedAt time.Time
+}
+
+// skewTransport compares the Date header of every response with the local
+// clock. It only reports, nothing else depends on the clocks.
+type skewTransport struct {
+	*http.Transport
+	endpoint string
+}
+
+func (t *skewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil {
+		if date, dateErr := http.ParseTime(resp.Header.Get("Date")); dateErr == nil {
+			// The header is truncated to the second
+			observeClockSkew(date.Add(500*time.Millisecond).Sub(time.Now()), t.endpoint)
+		}
+	}
+	return resp, err
+}
+
+func observeClockSkew(skew time.Duration, endpoint string) {
+	clockSkewGauge.Set(skew.Seconds())
+	threshold := time.Duration(atomic.LoadInt64(&clockSkewThreshold))
+	if threshold <= 0 {
+		return
+	}
+
+	clockSkew.lock.Lock()
+	defer clockSkew.lock.Unlock()
+	if skew > threshold || skew < -threshold {
+		if time.Since(clockSkew.warnedAt) >= clockSkewWarnInterval {
+			clockSkew.warnedAt = time.Now()
+			slog.Warn("The clock of this node differs from the clock of the DCS, check NTP on both", "skew", skew.Round(time.Second),
+				"threshold", threshold, "endpoint", endpoint)
+		}
+	} else if !clockSkew.warnedAt.IsZero() {
+		clockSkew.warnedAt = time.Time{}
+		slog.Info("The clocks of this node and the DCS agree again", "skew", skew.Round(time.Second), "endpoint", endpoint)
+	}
+}
@@ -0,0 +1,22 @@
+package checker
+
+import "context"
+
+// Coalesce passes the states received on in to out until ctx is done. It is
+// always ready to receive, so a leader checker never waits for a busy
+// consumer. Only the latest state is kept: states that arrive while the
+// consumer is busy replace the one not yet delivered.
+func Coalesce(ctx context.Context, in <-chan bool, out chan<- bool) {
+	var latest bool
+	var pending chan<- bool
+	for {
+		select {
+		case latest = <-in:
+			pending = out
+		case pending <- latest:
+			pending = nil
+		case <-ctx.Done():
+			return
+		}
+	}
+}
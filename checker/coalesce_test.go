@@ -0,0 +1,97 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalesceConverges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan bool)
+	out := make(chan bool)
+	go Coalesce(ctx, in, out)
+
+	// Rapid alternating states, the last one is true
+	const states = 10000
+	produced := make(chan struct{})
+	go func() {
+		for i := 1; i <= states; i++ {
+			in <- i%2 == 0
+		}
+		close(produced)
+	}()
+
+	// A consumer that is busy now and then
+	var last bool
+	received := 0
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case last = <-out:
+			received++
+			if received%100 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			continue
+		case <-produced:
+		case <-deadline:
+			t.Fatalf("deadlock: the producer did not finish, %d states received", received)
+		}
+		break
+	}
+	// The latest state is still delivered after the producer is done
+	select {
+	case last = <-out:
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !last {
+		t.Errorf("the consumer ended with %t, want the final state true", last)
+	}
+	select {
+	case state := <-out:
+		t.Errorf("received %t after the final state", state)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCoalesceNeverBlocksProducer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan bool)
+	out := make(chan bool)
+	go Coalesce(ctx, in, out)
+
+	// Nobody reads out while the states are sent
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			in <- i%2 == 1
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the producer blocked on a consumer that does not read")
+	}
+	if state := <-out; !state {
+		t.Errorf("delivered %t, want the latest state true", state)
+	}
+}
+
+func TestCoalesceStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Coalesce(ctx, make(chan bool), make(chan bool))
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Coalesce did not return after the context was cancelled")
+	}
+}
@@ -2,45 +2,76 @@ package checker
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"time"
 
+	"github.com/cybertec-postgresql/vip-manager/steadylog"
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
 	"github.com/hashicorp/consul/api"
 )
 
+// A blocking query returns after this long without a change, so the state
+// is renewed at least this often
+const consulWaitTime = time.Second
+
 type ConsulLeaderChecker struct {
+	endpoint  string
 	key       string
 	nodename  string
 	apiClient *api.Client
+	progress  *progress
+	log       observations
+	// Warns about a missing leader key
+	readLog steadylog.Logger
+	// Warns about a leader key that is almost this node
+	matchLog steadylog.Logger
 }
 
-func NewConsulLeaderChecker(endpoint, key, nodename string) (*ConsulLeaderChecker, error) {
+func NewConsulLeaderChecker(endpoint, key, nodename string, transport *http.Transport) (*ConsulLeaderChecker, error) {
 	lc := &ConsulLeaderChecker{
+		endpoint: endpoint,
 		key:      key,
 		nodename: nodename,
+		progress: current,
 	}
 
-	url, err := url.Parse(endpoint)
+	apiClient, err := newConsulClient(endpoint, transport)
 	if err != nil {
 		return nil, err
 	}
-	address := url.Hostname() + ":" + url.Port()
 
-	config := &api.Config{
-		Address:  address,
-		Scheme:   url.Scheme,
-		WaitTime: time.Second,
+	lc.apiClient = apiClient
+
+	return lc, nil
+}
+
+func newConsulClient(endpoint string, transport *http.Transport) (*api.Client, error) {
+	url, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
 	}
+	address := url.Hostname() + ":" + url.Port()
 
-	apiClient, err := api.NewClient(config)
+	httpClient, err := api.NewHttpClient(transport, api.TLSConfig{})
 	if err != nil {
 		return nil, err
 	}
+	httpClient.Transport = &skewTransport{Transport: transport, endpoint: endpoint}
+	config := &api.Config{
+		Address:    address,
+		Scheme:     url.Scheme,
+		WaitTime:   consulWaitTime,
+		Transport:  transport,
+		HttpClient: httpClient,
+	}
 
-	lc.apiClient = apiClient
+	return api.NewClient(config)
+}
 
-	return lc, nil
+func (c *ConsulLeaderChecker) setProgress(p *progress) {
+	c.progress = p
 }
 
 func (c *ConsulLeaderChecker) GetChangeNotificationStream(ctx context.Context, out chan<- bool) error {
@@ -53,21 +84,39 @@ func (c *ConsulLeaderChecker) GetChangeNotificationStream(ctx context.Context, o
 checkLoop:
 	for {
 		resp, _, err := kv.Get(c.key, queryOptions)
+		c.progress.recordAttempt()
+		if tracelog.Enabled() {
+			switch {
+			case err != nil:
+				tracelog.Log("Consul request failed", "endpoint", c.endpoint, "key", c.key, "wait_index", queryOptions.WaitIndex, "error", err)
+			case resp == nil:
+				tracelog.Log("Consul response without key", "endpoint", c.endpoint, "key", c.key, "wait_index", queryOptions.WaitIndex)
+			default:
+				tracelog.Log("Consul response", "endpoint", c.endpoint, "key", c.key, "wait_index", queryOptions.WaitIndex,
+					"value", string(resp.Value), "modify_index", resp.ModifyIndex)
+			}
+		}
 		if err != nil {
 			if ctx.Err() != nil {
 				break checkLoop
 			}
-			log.Printf("consul error: %s", err)
+			slog.Error(describeError("consul", err), "endpoint", c.endpoint)
+			c.log.failed(c.key, c.endpoint)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 		if resp == nil {
-			log.Printf("Cannot get variable for key %s. Will try again in a second.", c.key)
+			c.progress.recordValue(c.key, "", 0, 0, false)
+			c.log.observe(c.progress, c.key, "", 0, false, c.endpoint)
+			c.readLog.Log(slog.LevelWarn, "Cannot get variable for key, will try again in a second", "key", c.key, "endpoint", c.endpoint)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
 		state := string(resp.Value) == c.nodename
+		c.progress.recordValue(c.key, string(resp.Value), resp.ModifyIndex, resp.ModifyIndex, state)
+		c.log.observe(c.progress, c.key, string(resp.Value), resp.ModifyIndex, state, c.endpoint)
+		warnNearMatch(&c.matchLog, c.key, string(resp.Value), c.nodename)
 		queryOptions.WaitIndex = resp.ModifyIndex
 
 		select {
@@ -2,24 +2,43 @@ package checker
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/coreos/etcd/client"
+	"github.com/cybertec-postgresql/vip-manager/steadylog"
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
 )
 
 type EtcdLeaderChecker struct {
+	endpoint string
 	key      string
 	nodename string
 	kapi     client.KeysAPI
+	progress *progress
+	log      observations
+	// Warns about a leader key that is almost this node
+	matchLog steadylog.Logger
 }
 
-func NewEtcdLeaderChecker(endpoint, key, nodename string) (*EtcdLeaderChecker, error) {
-	e := &EtcdLeaderChecker{key: key, nodename: nodename}
+func NewEtcdLeaderChecker(endpoint, key, nodename string, transport *http.Transport) (*EtcdLeaderChecker, error) {
+	e := &EtcdLeaderChecker{endpoint: endpoint, key: key, nodename: nodename, progress: current}
 
+	kapi, err := newEtcdKeysAPI(endpoint, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	e.kapi = kapi
+
+	return e, nil
+}
+
+func newEtcdKeysAPI(endpoint string, transport *http.Transport) (client.KeysAPI, error) {
 	cfg := client.Config{
 		Endpoints:               []string{endpoint},
-		Transport:               client.DefaultTransport,
+		Transport:               &skewTransport{Transport: transport, endpoint: endpoint},
 		HeaderTimeoutPerRequest: time.Second,
 	}
 
@@ -29,9 +48,11 @@ func NewEtcdLeaderChecker(endpoint, key, nodename string) (*EtcdLeaderChecker, e
 		return nil, err
 	}
 
-	e.kapi = client.NewKeysAPI(c)
+	return client.NewKeysAPI(c), nil
+}
 
-	return e, nil
+func (e *EtcdLeaderChecker) setProgress(p *progress) {
+	e.progress = p
 }
 
 func (e *EtcdLeaderChecker) GetChangeNotificationStream(ctx context.Context, out chan<- bool) error {
@@ -43,17 +64,30 @@ func (e *EtcdLeaderChecker) GetChangeNotificationStream(ctx context.Context, out
 checkLoop:
 	for {
 		resp, err := e.kapi.Get(ctx, e.key, clientOptions)
+		e.progress.recordAttempt()
+		if tracelog.Enabled() {
+			if err != nil {
+				tracelog.Log("etcd request failed", "endpoint", e.endpoint, "key", e.key, "error", err)
+			} else {
+				tracelog.Log("etcd response", "endpoint", e.endpoint, "key", e.key, "value", resp.Node.Value,
+					"modified_index", resp.Node.ModifiedIndex, "etcd_index", resp.Index)
+			}
+		}
 
 		if err != nil {
 			if ctx.Err() != nil {
 				break checkLoop
 			}
-			log.Printf("etcd error: %s", err)
+			slog.Error(describeError("etcd", err), "endpoint", e.endpoint)
+			e.log.failed(e.key, e.endpoint)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
 		state := resp.Node.Value == e.nodename
+		e.progress.recordValue(e.key, resp.Node.Value, resp.Index, resp.Node.ModifiedIndex, state)
+		e.log.observe(e.progress, e.key, resp.Node.Value, resp.Node.ModifiedIndex, state, e.endpoint)
+		warnNearMatch(&e.matchLog, e.key, resp.Node.Value, e.nodename)
 
 		select {
 		case <-ctx.Done():
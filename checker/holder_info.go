@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/hashicorp/consul/api"
+)
+
+// HolderWriter keeps a value under a key of the DCS that expires unless it
+// is written again within the TTL, so a dead node does not leave it behind.
+type HolderWriter interface {
+	// Write sets the value and restarts the TTL
+	Write(ctx context.Context, value []byte) error
+	// Delete removes the key
+	Delete(ctx context.Context) error
+}
+
+func NewHolderWriter(endpointType, endpoint, key string, ttl time.Duration, transport *http.Transport) (HolderWriter, error) {
+	switch endpointType {
+	case "consul":
+		c, err := newConsulClient(endpoint, transport)
+		if err != nil {
+			return nil, err
+		}
+		return &consulHolderWriter{client: c, key: key, ttl: ttl}, nil
+	case "etcd":
+		kapi, err := newEtcdKeysAPI(endpoint, transport)
+		if err != nil {
+			return nil, err
+		}
+		return &etcdHolderWriter{kapi: kapi, key: key, ttl: ttl}, nil
+	}
+	return nil, ErrUnsupportedEndpointType
+}
+
+// etcdHolderWriter sets the key with a TTL, the v2 API has no leases.
+type etcdHolderWriter struct {
+	kapi client.KeysAPI
+	key  string
+	ttl  time.Duration
+}
+
+func (w *etcdHolderWriter) Write(ctx context.Context, value []byte) error {
+	_, err := w.kapi.Set(ctx, w.key, string(value), &client.SetOptions{TTL: w.ttl})
+	return err
+}
+
+func (w *etcdHolderWriter) Delete(ctx context.Context) error {
+	_, err := w.kapi.Delete(ctx, w.key, nil)
+	return err
+}
+
+// consulHolderWriter holds the key with a session that deletes it when the
+// session expires.
+type consulHolderWriter struct {
+	client  *api.Client
+	key     string
+	ttl     time.Duration
+	session string
+}
+
+func (w *consulHolderWriter) Write(ctx context.Context, value []byte) error {
+	opts := (&api.WriteOptions{}).WithContext(ctx)
+	if w.session != "" {
+		entry, _, err := w.client.Session().Renew(w.session, opts)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			// Expired, e.g. while the DCS could not be reached
+			w.session = ""
+		}
+	}
+	if w.session == "" {
+		id, _, err := w.client.Session().Create(&api.SessionEntry{
+			Name:     "vip-manager " + w.key,
+			TTL:      w.ttl.String(),
+			Behavior: api.SessionBehaviorDelete,
+		}, opts)
+		if err != nil {
+			return err
+		}
+		w.session = id
+	}
+	acquired, _, err := w.client.KV().Acquire(&api.KVPair{Key: w.key, Value: value, Session: w.session}, opts)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("%s is held by another session", w.key)
+	}
+	return nil
+}
+
+func (w *consulHolderWriter) Delete(ctx context.Context) error {
+	if w.session == "" {
+		return nil
+	}
+	// Deletes the key as well
+	_, err := w.client.Session().Destroy(w.session, (&api.WriteOptions{}).WithContext(ctx))
+	w.session = ""
+	return err
+}
@@ -3,26 +3,49 @@ package checker
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/steadylog"
 )
 
 var ErrUnsupportedEndpointType = errors.New("given endpoint type not supported")
 
+// Types lists the supported endpoint types
+var Types = []string{"consul", "etcd"}
+
 type LeaderChecker interface {
 	GetChangeNotificationStream(ctx context.Context, out chan<- bool) error
 }
 
-func NewLeaderChecker(endpointType, endpoint, key, nodename string) (LeaderChecker, error) {
+func NewLeaderChecker(endpointType, endpoint, key, nodename string, transport *http.Transport) (LeaderChecker, error) {
 	var lc LeaderChecker
 	var err error
 
 	switch endpointType {
 	case "consul":
-		lc, err = NewConsulLeaderChecker(endpoint, key, nodename)
+		lc, err = NewConsulLeaderChecker(endpoint, key, nodename, transport)
 	case "etcd":
-		lc, err = NewEtcdLeaderChecker(endpoint, key, nodename)
+		lc, err = NewEtcdLeaderChecker(endpoint, key, nodename, transport)
 	default:
 		err = ErrUnsupportedEndpointType
 	}
 
 	return lc, err
 }
+
+// warnNearMatch warns when the leader key holds nodename in another case or
+// with or without the domain. Most likely -host does not match the name
+// Patroni uses for this node, and the virtual IP would never be configured.
+func warnNearMatch(log *steadylog.Logger, key, value, nodename string) {
+	short := func(name string) string {
+		name, _, _ = strings.Cut(name, ".")
+		return name
+	}
+	if value == "" || value == nodename || !strings.EqualFold(short(value), short(nodename)) {
+		return
+	}
+	log.Log(slog.LevelWarn, "Leader key looks like this node but does not match -host exactly, set -host to "+value+" if it names this node",
+		"key", key, "value", value, "host", nodename)
+}
@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+var leaderInfo = metrics.NewGaugeVec("vip_manager_leader_info",
+	"The value of the leader key last read, i.e. the node considered leader. 1 while the DCS was read successfully within the staleness threshold, else 0. Only the current value is exported.",
+	"leader")
+
+// In nanoseconds, see SetStaleThreshold
+var staleThreshold = int64(30 * time.Second)
+
+// SetStaleThreshold sets after how long without a successful read of the
+// DCS the value of the leader key is stale.
+func SetStaleThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&staleThreshold, int64(threshold))
+}
+
+// StaleThreshold returns the threshold set with SetStaleThreshold.
+func StaleThreshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&staleThreshold))
+}
+
+// LeaderStale reports whether the value of the leader key is stale, or was
+// not read yet.
+func LeaderStale() bool {
+	_, _, at := LastValue()
+	return time.Since(at) > StaleThreshold()
+}
+
+// recordLeader exports a new value of the leader key.
+func recordLeader(value string) {
+	leaderInfo.SetOnly(func() float64 {
+		if LeaderStale() {
+			return 0
+		}
+		return 1
+	}, value)
+}
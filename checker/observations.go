@@ -0,0 +1,75 @@
+package checker
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+)
+
+// In nanoseconds, see SetSummaryInterval
+var summaryInterval int64
+
+// SetSummaryInterval sets how often a checker logs a summary line while the
+// leader key does not change. 0 disables the summary.
+func SetSummaryInterval(interval time.Duration) {
+	atomic.StoreInt64(&summaryInterval, int64(interval))
+}
+
+// observations logs what a checker reads from the DCS. Every checker
+// follows the same contract: the value and the resulting state are logged
+// when either changes, everything in between only shows up in the summary.
+type observations struct {
+	lock     sync.Mutex
+	seen     bool
+	value    string
+	state    bool
+	since    time.Time
+	polls    int
+	errors   int
+	loggedAt time.Time
+}
+
+// observe records a successful read of key that returned value, last
+// modified at revision, which means state for this node. A change of the
+// value is recorded in p.
+func (o *observations) observe(p *progress, key, value string, revision uint64, state bool, endpoint string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	now := time.Now()
+	if !o.seen || value != o.value || state != o.state {
+		msg, args := "Read leader key", []any{"key", key, "value", value, "revision", revision, "leader", state, "endpoint", endpoint}
+		if o.seen {
+			msg = "Leader key changed"
+			args = append(args, "previous", o.value, "unchanged_for", now.Sub(o.since).Round(time.Second))
+		}
+		slog.Info(msg, args...)
+		// The root of the trace of the transition it causes
+		span := tracing.Start("leader key observed", tracing.SpanContext{}, args...)
+		span.End(nil)
+		p.recordObservation(span.Context(), value)
+		*o = observations{seen: true, value: value, state: state, since: now, loggedAt: now}
+	}
+	o.polls++
+	o.summarize(key, endpoint, now)
+}
+
+// failed records a read of key that failed.
+func (o *observations) failed(key, endpoint string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.errors++
+	o.summarize(key, endpoint, time.Now())
+}
+
+func (o *observations) summarize(key, endpoint string, now time.Time) {
+	interval := time.Duration(atomic.LoadInt64(&summaryInterval))
+	if interval <= 0 || !o.seen || now.Sub(o.loggedAt) < interval {
+		return
+	}
+	o.loggedAt = now
+	slog.Info("Leader key unchanged", "key", key, "value", o.value, "leader", o.state, "endpoint", endpoint,
+		"unchanged_for", now.Sub(o.since).Round(time.Second), "polls", o.polls, "errors", o.errors)
+}
@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+)
+
+func init() {
+	metrics.NewGaugeFunc("vip_manager_dcs_last_read_age_seconds",
+		"Seconds since the DCS was last read successfully, -1 if it never was.",
+		func() float64 {
+			_, _, at := LastValue()
+			if at.IsZero() {
+				return -1
+			}
+			return time.Since(at).Seconds()
+		})
+}
+
+// progress is what a leader checker last read from the DCS.
+type progress struct {
+	// Unix time in nanoseconds of the last finished request to the DCS
+	attempt int64
+
+	lock  sync.Mutex
+	key   string
+	value string
+	at    time.Time
+	// Modify index of the key in Consul, the etcd index in etcd
+	index uint64
+	// Modify index of the key in both
+	revision uint64
+	// Of the last read that named this node as leader
+	confirmedAt time.Time
+	// The span of the last change of the value
+	observation tracing.SpanContext
+	// Whether the value is exported in vip_manager_leader_info
+	leaderInfo bool
+}
+
+// The progress of the checker whose state is acted on, see LastValue. The
+// members of a QuorumChecker record theirs apart, only the decision of the
+// quorum ends up here.
+var current = &progress{leaderInfo: true}
+
+func (p *progress) recordAttempt() {
+	p.recordAttemptAt(time.Now())
+}
+
+// recordAttemptAt records an attempt at t, unless a later one was recorded.
+func (p *progress) recordAttemptAt(t time.Time) {
+	for {
+		last := atomic.LoadInt64(&p.attempt)
+		if t.UnixNano() <= last || atomic.CompareAndSwapInt64(&p.attempt, last, t.UnixNano()) {
+			return
+		}
+	}
+}
+
+func (p *progress) lastAttempt() time.Time {
+	nanos := atomic.LoadInt64(&p.attempt)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (p *progress) recordValue(key, value string, index, revision uint64, leader bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.key = key
+	p.value = value
+	p.index = index
+	p.revision = revision
+	p.at = time.Now()
+	if leader {
+		p.confirmedAt = p.at
+	}
+}
+
+// recordObservation records the span of a read that changed the value.
+func (p *progress) recordObservation(c tracing.SpanContext, value string) {
+	p.lock.Lock()
+	p.observation = c
+	p.lock.Unlock()
+	if p.leaderInfo {
+		recordLeader(value)
+	}
+}
+
+// read returns the last value read, at is zero if there was none yet.
+func (p *progress) read() (key, value string, index, revision uint64, at time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.key, p.value, p.index, p.revision, p.at
+}
+
+// LastAttempt returns when the leader checker last finished a request to
+// the DCS, successful or not. It tells a hung checker apart from one that
+// cannot reach the DCS. Zero if there was none yet.
+func LastAttempt() time.Time {
+	return current.lastAttempt()
+}
+
+// LastValue returns the key and value last read from the DCS and when that
+// was. The time is zero if the DCS was not read successfully yet.
+func LastValue() (key, value string, at time.Time) {
+	key, value, _, _, at = current.read()
+	return key, value, at
+}
+
+// LastConfirmed returns when the DCS was last read successfully with this
+// node as leader. Zero if it never was.
+func LastConfirmed() time.Time {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	return current.confirmedAt
+}
+
+// LastObservation returns the span of the last read that changed the value
+// of the leader key, the zero SpanContext without tracing.
+func LastObservation() tracing.SpanContext {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	return current.observation
+}
+
+// LastIndex returns the index of the last successful read: the modify index
+// of the key in Consul, which the next blocking query waits on, or the etcd
+// index in etcd.
+func LastIndex() uint64 {
+	_, _, index, _, _ := current.read()
+	return index
+}
+
+// LastRevision returns the modify index of the leader key as of the last
+// successful read, 0 if the key did not exist.
+func LastRevision() uint64 {
+	_, _, _, revision, _ := current.read()
+	return revision
+}
@@ -0,0 +1,306 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+var quorumVotes = metrics.NewGaugeVec("vip_manager_quorum_vote",
+	"The vote of each member of the DCS quorum: 1 if it names this node as leader, 0 if not, -1 if it is stale.",
+	"member")
+
+// How often the votes are checked for staleness
+const quorumCheckInterval = time.Second
+
+// QuorumMember is a leader checker that votes in a QuorumChecker, with the
+// name its vote is reported under.
+type QuorumMember struct {
+	Name    string
+	Checker LeaderChecker
+	// A vote not renewed for this long no longer counts. The members
+	// report every read, a blocking query of Consul returns after
+	// consulWaitTime without a change.
+	StaleAfter time.Duration
+}
+
+// QuorumVote is the latest vote of a member, as shown in the status.
+type QuorumVote struct {
+	Member string `json:"member"`
+	Leader bool   `json:"leader"`
+	// The value of the leader key the member read last
+	Value string `json:"value"`
+	// Whether the vote counts, i.e. was renewed within the staleness window
+	Fresh bool `json:"fresh"`
+	// Null until the member reported a state
+	LastReport *time.Time `json:"last_report"`
+	// Why the member stopped reporting, empty while it runs
+	Error string `json:"error,omitempty"`
+}
+
+type memberState struct {
+	leader   bool
+	reported time.Time
+	err      error
+	// What the member read, from its progress
+	key, value      string
+	index, revision uint64
+}
+
+// fresh reports whether the vote counts.
+func (s memberState) fresh(m QuorumMember, now time.Time) bool {
+	return !s.reported.IsZero() && now.Sub(s.reported) <= m.StaleAfter && s.err == nil
+}
+
+// QuorumChecker runs several leader checkers side by side, e.g. on
+// independent DCS endpoints, and only reports this node as leader while a
+// quorum of them agrees. A member whose vote is stale does not count, so
+// losing too many endpoints means not being leader.
+//
+// The members record their reads apart, LastValue and LastConfirmed only
+// show what the quorum decided on.
+type QuorumChecker struct {
+	members []QuorumMember
+	quorum  int
+	// Of every member
+	progress []*progress
+	log      observations
+
+	lock   sync.Mutex
+	states []memberState
+}
+
+// memberChecker is a leader checker that can record its reads apart from
+// the progress of the checker whose state is acted on.
+type memberChecker interface {
+	setProgress(p *progress)
+}
+
+// The quorum checker running, for QuorumVotes
+var activeQuorum struct {
+	lock    sync.Mutex
+	checker *QuorumChecker
+}
+
+// NewQuorumChecker requires quorum of members to agree that this node is
+// leader.
+func NewQuorumChecker(members []QuorumMember, quorum int) (*QuorumChecker, error) {
+	if quorum < 1 || quorum > len(members) {
+		return nil, fmt.Errorf("quorum of %d is impossible with %d members", quorum, len(members))
+	}
+	q := &QuorumChecker{members: members, quorum: quorum, progress: make([]*progress, len(members)),
+		states: make([]memberState, len(members))}
+	for i, m := range members {
+		q.progress[i] = &progress{}
+		if c, ok := m.Checker.(memberChecker); ok {
+			c.setProgress(q.progress[i])
+		}
+	}
+	return q, nil
+}
+
+func (q *QuorumChecker) GetChangeNotificationStream(ctx context.Context, out chan<- bool) error {
+	// Not waiting for the members on return, a Consul query only returns
+	// once it is answered
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	activeQuorum.lock.Lock()
+	activeQuorum.checker = q
+	activeQuorum.lock.Unlock()
+
+	type report struct {
+		member int
+		leader bool
+	}
+	reports := make(chan report)
+	stopped := make(chan int)
+	for i, m := range q.members {
+		states := make(chan bool)
+		go func(i int, m QuorumMember) {
+			err := m.Checker.GetChangeNotificationStream(ctx, states)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("stopped")
+			}
+			q.lock.Lock()
+			q.states[i].err = err
+			q.lock.Unlock()
+			slog.Error("Quorum member stopped, its vote no longer counts", "member", m.Name, "error", err)
+			select {
+			case stopped <- i:
+			case <-ctx.Done():
+			}
+		}(i, m)
+		go func(i int) {
+			for {
+				select {
+				case state := <-states:
+					select {
+					case reports <- report{i, state}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i)
+	}
+	started := time.Now()
+	ticker := time.NewTicker(quorumCheckInterval)
+	defer ticker.Stop()
+	decided := false
+	var result bool
+	running := len(q.members)
+	for {
+		reported := false
+		select {
+		case r := <-reports:
+			key, value, index, revision, _ := q.progress[r.member].read()
+			q.lock.Lock()
+			q.states[r.member] = memberState{leader: r.leader, reported: time.Now(),
+				key: key, value: value, index: index, revision: revision}
+			q.lock.Unlock()
+			reported = true
+		case <-stopped:
+			running--
+			if running == 0 {
+				return fmt.Errorf("all quorum members stopped")
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		current.recordAttemptAt(q.lastAttempt())
+		leader, certain := q.evaluate(started)
+		if !decided && !certain {
+			// Wait for the others before the first result
+			continue
+		}
+		changed := !decided || leader != result
+		if !changed && !reported {
+			continue
+		}
+		if decided && changed {
+			slog.Warn("Quorum result changed", "leader", leader, "quorum", q.quorum, "members", len(q.members))
+		}
+		decided, result = true, leader
+		if key, value, index, revision, ok := q.decision(leader); ok {
+			current.recordValue(key, value, index, revision, leader)
+			q.log.observe(current, key, value, revision, leader, "quorum")
+		}
+		// Also passed on when only renewed, like the members do on every
+		// read
+		select {
+		case out <- leader:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// evaluate returns whether a quorum of fresh votes names this node as
+// leader, and whether that is certain, i.e. the members that did not report
+// yet cannot change it.
+func (q *QuorumChecker) evaluate(started time.Time) (leader, certain bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	votes, pending := 0, 0
+	for i, m := range q.members {
+		s := q.states[i]
+		fresh := s.fresh(m, now)
+		switch {
+		case fresh && s.leader:
+			votes++
+			quorumVotes.With(m.Name).Set(1)
+		case fresh:
+			quorumVotes.With(m.Name).Set(0)
+		default:
+			quorumVotes.With(m.Name).Set(-1)
+			// Not reported yet, give it its staleness window
+			if s.reported.IsZero() && s.err == nil && now.Sub(started) <= m.StaleAfter {
+				pending++
+			}
+		}
+	}
+	leader = votes >= q.quorum
+	return leader, leader || votes+pending < q.quorum
+}
+
+// lastAttempt returns when a member last finished a request to the DCS.
+func (q *QuorumChecker) lastAttempt() time.Time {
+	var last time.Time
+	for _, p := range q.progress {
+		if at := p.lastAttempt(); at.After(last) {
+			last = at
+		}
+	}
+	return last
+}
+
+// decision returns the read the result of the quorum is based on: one that
+// names this node if it is leader, else the value most of the fresh votes
+// read. ok is false if no fresh vote backs the result.
+func (q *QuorumChecker) decision(leader bool) (key, value string, index, revision uint64, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	counts := make(map[string]int)
+	best := -1
+	for i, m := range q.members {
+		s := q.states[i]
+		if !s.fresh(m, now) || s.leader != leader {
+			continue
+		}
+		counts[s.value]++
+		if best < 0 || counts[s.value] > counts[q.states[best].value] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", "", 0, 0, false
+	}
+	s := q.states[best]
+	return s.key, s.value, s.index, s.revision, true
+}
+
+// votes returns the latest vote of every member.
+func (q *QuorumChecker) votes() []QuorumVote {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	votes := make([]QuorumVote, len(q.members))
+	for i, m := range q.members {
+		s := q.states[i]
+		votes[i] = QuorumVote{Member: m.Name, Leader: s.leader, Value: s.value, Fresh: s.fresh(m, now)}
+		if !s.reported.IsZero() {
+			reported := s.reported
+			votes[i].LastReport = &reported
+		}
+		if s.err != nil {
+			votes[i].Error = s.err.Error()
+		}
+	}
+	return votes
+}
+
+// QuorumVotes returns the votes of the members of the running quorum
+// checker, nil without one.
+func QuorumVotes() []QuorumVote {
+	activeQuorum.lock.Lock()
+	q := activeQuorum.checker
+	activeQuorum.lock.Unlock()
+	if q == nil {
+		return nil
+	}
+	return q.votes()
+}
@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NewTransport returns the HTTP transport for the connections to the DCS.
+// Proxies are taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY, unless
+// proxyURL is given, which is then used for all connections.
+func NewTransport(proxyURL string) (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %s", proxyURL, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}, nil
+}
+
+// describeError tells failures to reach the proxy apart from errors of the
+// DCS itself, so network problems are not mistaken for problems of the
+// cluster.
+func describeError(system string, err error) string {
+	if strings.Contains(err.Error(), "proxyconnect") {
+		return fmt.Sprintf("proxy error while connecting to %s: %s", system, err)
+	}
+	return fmt.Sprintf("%s error: %s", system, err)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// Delay before restarting a leader checker that stopped on its own, doubled
+// for every consecutive restart
+const (
+	checkerRestartMinDelay = time.Second
+	checkerRestartMaxDelay = time.Minute
+)
+
+var checkerRestarts = metrics.NewCounter("vip_manager_checker_restarts_total",
+	"Number of times the leader checker was restarted after it stopped on its own.")
+
+// checkerSupervisor restarts the leader checker with a backoff. The
+// manager keeps the last state it received in the meantime.
+type checkerSupervisor struct {
+	// Consecutive restarts, reset once a checker ran for longer than the
+	// maximum delay
+	restarts int
+	delay    time.Duration
+	// Creates the leader checker for a restart
+	newChecker func() (checker.LeaderChecker, error)
+}
+
+// run runs lc and restarts it whenever it stops on its own, until ctx is
+// done. A signal on hup calls reload, which may replace the leader checker.
+// It returns false if the leader checker could not be restarted while ctx
+// was still running.
+func (s *checkerSupervisor) run(ctx context.Context, lc checker.LeaderChecker, updates chan<- bool, hup <-chan os.Signal, reload func() checker.LeaderChecker) bool {
+supervise:
+	for {
+		checkerCtx, stopChecker := context.WithCancel(ctx)
+		checkerDone := make(chan error, 1)
+		started := time.Now()
+		go func() {
+			defer recoverPanic()
+			checkerDone <- lc.GetChangeNotificationStream(checkerCtx, updates)
+		}()
+
+		// Reloading the config may replace the leader checker, the
+		// manager keeps the last state in the meantime
+		var next checker.LeaderChecker
+		for next == nil {
+			select {
+			case err := <-checkerDone:
+				stopChecker()
+				if ctx.Err() != nil {
+					return true
+				}
+				if err == nil {
+					err = fmt.Errorf("returned without an error")
+				}
+				lc = s.restart(ctx, time.Since(started), err)
+				if lc == nil {
+					return ctx.Err() != nil
+				}
+				continue supervise
+			case <-hup:
+				next = reload()
+			}
+		}
+		stopChecker()
+		<-checkerDone
+		lc = next
+	}
+}
+
+// restart waits and creates a fresh leader checker, so that a broken client
+// is not reused. It returns nil if ctx is done first or -checker-max-restarts
+// is exceeded.
+func (s *checkerSupervisor) restart(ctx context.Context, ranFor time.Duration, cause error) checker.LeaderChecker {
+	if ranFor > checkerRestartMaxDelay {
+		s.restarts = 0
+		s.delay = 0
+	}
+	for {
+		if *checkerMaxRestarts > 0 && s.restarts >= *checkerMaxRestarts {
+			slog.Error("Leader checker keeps stopping, giving up", "restarts", s.restarts, "error", cause)
+			return nil
+		}
+		s.restarts++
+		s.delay *= 2
+		if s.delay == 0 {
+			s.delay = checkerRestartMinDelay
+		} else if s.delay > checkerRestartMaxDelay {
+			s.delay = checkerRestartMaxDelay
+		}
+		slog.Error("Leader checker stopped, restarting it", "type", *endpointType, "endpoint", *endpoint, "attempt", s.restarts, "delay", s.delay, "error", cause)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.delay):
+		}
+		checkerRestarts.Inc()
+		lc, err := s.newChecker()
+		if err == nil {
+			return lc
+		}
+		cause = err
+	}
+}
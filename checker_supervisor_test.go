@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"go.uber.org/goleak"
+)
+
+// fakeChecker sends its states and then stops with err, like a leader
+// checker whose connection breaks in the middle of the stream. It keeps
+// running until the context is done if it does not stop.
+type fakeChecker struct {
+	states []bool
+	stop   bool
+	err    error
+}
+
+func (c *fakeChecker) GetChangeNotificationStream(ctx context.Context, out chan<- bool) error {
+	for _, state := range c.states {
+		select {
+		case out <- state:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if c.stop {
+		return c.err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func TestCheckerSupervisor(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRestarts int
+		// The first checker and the ones created for the restarts
+		checkers []*fakeChecker
+		// Whether the supervisor gives up on its own
+		wantGiveUp bool
+		wantState  bool
+	}{
+		{
+			name:        "restarted checker takes over",
+			maxRestarts: 2,
+			checkers: []*fakeChecker{
+				{states: []bool{true}, stop: true, err: errors.New("connection reset")},
+				{states: []bool{false}},
+			},
+			wantState: false,
+		},
+		{
+			name:        "gives up after the maximum restarts",
+			maxRestarts: 1,
+			checkers: []*fakeChecker{
+				{states: []bool{true}, stop: true, err: errors.New("connection reset")},
+				{states: []bool{true}, stop: true},
+			},
+			wantGiveUp: true,
+			wantState:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+			defer func(saved int) { *checkerMaxRestarts = saved }(*checkerMaxRestarts)
+			*checkerMaxRestarts = test.maxRestarts
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			updates := make(chan bool)
+			states := make(chan bool)
+			go checker.Coalesce(ctx, updates, states)
+
+			created := 1
+			supervisor := checkerSupervisor{newChecker: func() (checker.LeaderChecker, error) {
+				if created == len(test.checkers) {
+					return nil, errors.New("more restarts than checkers")
+				}
+				created++
+				return test.checkers[created-1], nil
+			}}
+			result := make(chan bool, 1)
+			go func() {
+				result <- supervisor.run(ctx, test.checkers[0], updates, make(chan os.Signal), nil)
+			}()
+
+			// Every checker sends one state
+			var state bool
+			for i := range test.checkers {
+				select {
+				case state = <-states:
+				case <-time.After(10 * time.Second):
+					t.Fatalf("state %d was not delivered", i)
+				}
+			}
+			if state != test.wantState {
+				t.Errorf("the last state is %t, want %t", state, test.wantState)
+			}
+
+			if !test.wantGiveUp {
+				select {
+				case <-result:
+					t.Fatal("the supervisor returned while the restarted checker was running")
+				case <-time.After(50 * time.Millisecond):
+				}
+				cancel()
+			}
+			select {
+			case ok := <-result:
+				if ok == test.wantGiveUp {
+					t.Errorf("run() = %t, want %t", ok, !test.wantGiveUp)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("the supervisor did not return")
+			}
+			if created != len(test.checkers) {
+				t.Errorf("%d checkers were created, want %d", created, len(test.checkers))
+			}
+			cancel()
+		})
+	}
+}
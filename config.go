@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Every option can be given as flag, environment variable or in the config
+// file, in this order of precedence, else it keeps its default. The
+// environment variable is the option name in upper snake case with this
+// prefix, e.g. VIP_HTTP_LISTEN. Deprecated names work in all three places,
+// see options.go.
+const envPrefix = "VIP_"
+
+var configFile = stringOption("config", "", "YAML file with options, keys are the option names, e.g. \"iface: eth0\". Values may refer to environment variables as ${NAME}. Reloaded on SIGHUP.")
+
+// Remembered by loadConfig for reloading the config file
+var (
+	configPath   string
+	givenOptions map[string]bool
+)
+
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(option, "-", "_", -1))
+}
+
+// parseConfigFile reads the flat subset of YAML we need: "key: value" lines
+// and lists of values for repeatable options. Keys are option names, with
+// dashes or underscores. References to environment variables in values are
+// resolved, see interpolate.
+func parseConfigFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string][]string)
+	var listKey string
+	lineNumber := 0
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scn.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") {
+			if listKey == "" {
+				return nil, fmt.Errorf("%s:%d: list item without a key", path, lineNumber)
+			}
+			value, err := interpolate(unquote(strings.TrimSpace(line[2:])))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: option %s: %s", path, lineNumber, listKey, err)
+			}
+			values[listKey] = append(values[listKey], value)
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"key: value\"", path, lineNumber)
+		}
+		key := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1)
+		value := strings.TrimSpace(kv[1])
+		listKey = ""
+		if value == "" {
+			// Followed by a list
+			listKey = key
+			continue
+		}
+		value, err := interpolate(unquote(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: option %s: %s", path, lineNumber, key, err)
+		}
+		values[key] = append(values[key], value)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// readConfigFile parses the config file at path, if any, and checks that
+// it only contains known options.
+func readConfigFile(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	fileValues, err := parseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for key := range fileValues {
+		if o, _ := lookupOption(key); o == nil || key == "config" {
+			return nil, fmt.Errorf("%s: unknown option %s", path, key)
+		}
+	}
+	if err := resolveAliases(path, fileValues); err != nil {
+		return nil, err
+	}
+	return fileValues, nil
+}
+
+// loadConfig sets every option that was not given as flag from the
+// environment or the config file. Has to be called after flag.Parse.
+func loadConfig() error {
+	given, err := givenFlags()
+	if err != nil {
+		return err
+	}
+	for name := range given {
+		optionSources[name] = "flag"
+	}
+
+	path := *configFile
+	if !given["config"] {
+		path = os.Getenv(envName("config"))
+	}
+	configPath, givenOptions = path, given
+	fileValues, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range allOptions() {
+		if given[o.name] || o.name == "config" {
+			continue
+		}
+
+		var values []string
+		env, source, ok := o.lookupEnv()
+		if ok {
+			values = []string{env}
+			if o.repeatable {
+				values = strings.Fields(env)
+			}
+			optionSources[o.name] = "env"
+		} else if fileValues[o.name] != nil {
+			values = fileValues[o.name]
+			source = path
+			optionSources[o.name] = "file"
+		}
+		if len(values) > 1 && !o.repeatable {
+			return fmt.Errorf("%s: option %s given more than once", source, o.name)
+		}
+
+		for _, value := range values {
+			if err := o.flag.Value.Set(value); err != nil {
+				return fmt.Errorf("%s: invalid value %q for option %s: %s", source, value, o.name, err)
+			}
+		}
+	}
+	return loadSecretFiles()
+}
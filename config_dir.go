@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var configDir = stringOption("config-dir", "", "Directory with one config file per virtual IP, e.g. /etc/vip-manager.d. Every *.yml file in it runs as an instance of its own, SIGHUP scans it again. Flags apply to all of them, the environment only to the options their file does not set. -http-listen and -pprof-listen go in the files, -pid-file and -control-socket have to be directories.")
+
+// Wait before an instance that exited on its own is started again
+const instanceRestartDelay = 5 * time.Second
+
+// dirInstance is vip-manager running with one file of -config-dir, in a
+// process of its own.
+type dirInstance struct {
+	path    string
+	modTime time.Time
+	vips    []string
+	// The addresses and files it binds or locks, see exclusiveOptions
+	resources []string
+	cmd       *exec.Cmd
+	// Closed when the process exited
+	done chan struct{}
+	// Set once it is asked to stop, it is not restarted then
+	stopping bool
+}
+
+// configDirSupervisor runs an instance for every config file in a
+// directory. The instances cannot share a process, as the leader checker's
+// progress and the last transition are kept in package variables.
+type configDirSupervisor struct {
+	dir string
+	// Flags given to us, passed on to every instance
+	args []string
+
+	lock      sync.Mutex
+	instances map[string]*dirInstance
+	exiting   bool
+}
+
+// sharedArgs returns the flags given on the command line, except the ones
+// that only make sense for us.
+func sharedArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		o, _ := lookupOption(f.Name)
+		switch {
+		case o == nil || o.name == "config-dir" || o.name == "config":
+		case o.repeatable:
+			slog.Warn("Option is not passed on to the instances of -config-dir, set it in their files", "option", o.name)
+		default:
+			args = append(args, fmt.Sprintf("-%s=%s", o.name, o.flag.Value.String()))
+		}
+	})
+	return args
+}
+
+// The options whose value an instance binds or locks, no two instances may
+// have the same. A directory in -pid-file or -control-socket gives every
+// instance a file of its own, named after the instance.
+var exclusiveOptions = []string{"http-listen", "pprof-listen", "pid-file", "control-socket"}
+
+// checkExclusiveFlags rejects the flags that would give every instance the
+// same address or file.
+func checkExclusiveFlags() error {
+	for _, name := range exclusiveOptions {
+		value := optionDefs[name].flag.Value.String()
+		if !givenOptions[name] || value == "" {
+			continue
+		}
+		switch name {
+		case "pid-file", "control-socket":
+			if !isDirPath(value) {
+				return fmt.Errorf("-%s has to be a directory with -config-dir, every instance needs a file of its own", name)
+			}
+		default:
+			return fmt.Errorf("-%s cannot be given with -config-dir, every instance needs an address of its own, set it in the config files", name)
+		}
+	}
+	return nil
+}
+
+// instanceOption returns the value that the instance of a config file with
+// values gets for an option: the flag, else the file, else our environment.
+func instanceOption(values map[string][]string, name string) string {
+	o := optionDefs[name]
+	if givenOptions[name] {
+		return o.flag.Value.String()
+	}
+	for key, value := range values {
+		if fileOption, _ := lookupOption(key); fileOption == o && len(value) > 0 {
+			return value[len(value)-1]
+		}
+	}
+	for _, env := range o.envNames() {
+		if value, ok := os.LookupEnv(env); ok {
+			return value
+		}
+	}
+	return o.flag.DefValue
+}
+
+// dirInstanceName returns the name of the instance of the config file at
+// path, the one set for it or else the name of the file.
+func dirInstanceName(path string, values map[string][]string) string {
+	if name := instanceOption(values, "instance-name"); name != "" {
+		return name
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// fileResources returns the addresses and files that the instance of the
+// config file at path binds or locks, as option=value.
+func fileResources(path string) ([]string, error) {
+	values, err := parseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := dirInstanceName(path, values)
+	var resources []string
+	for _, option := range exclusiveOptions {
+		value := instanceOption(values, option)
+		if value == "" {
+			continue
+		}
+		switch option {
+		case "pid-file":
+			value = instanceFile(value, name, ".pid")
+		case "control-socket":
+			value = instanceFile(value, name, ".sock")
+		}
+		resources = append(resources, option+"="+value)
+	}
+	return resources, nil
+}
+
+// claim records the virtual IPs and resources of the file at path in
+// owners, unless another file already has one of them.
+func claim(owners map[string]string, path string, vips, resources []string) bool {
+	ok := true
+	for _, vip := range vips {
+		if other, taken := owners[vip]; taken {
+			slog.Error("Virtual IP is already configured in another file, not starting the instance", "config", path, "vip", vip, "other", other)
+			ok = false
+		}
+	}
+	for _, resource := range resources {
+		if other, taken := owners[resource]; taken {
+			option, value, _ := strings.Cut(resource, "=")
+			slog.Error("Option has the same value in another file, not starting the instance", "config", path, "option", option, "value", value, "other", other)
+			ok = false
+		}
+	}
+	if !ok {
+		return false
+	}
+	for _, vip := range vips {
+		owners[vip] = path
+	}
+	for _, resource := range resources {
+		owners[resource] = path
+	}
+	return true
+}
+
+// configDirFiles returns the config files in dir, sorted.
+func configDirFiles(dir string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// The options that configure the virtual IPs. A file that sets one of them
+// replaces all of them, so e.g. VIP_IP from the packaged environment file
+// does not add an address to a file with vip entries.
+var addressOptions = []string{"ip", "ip6", "vip"}
+
+// fileOptions returns the options set in the file with values, with
+// deprecated names resolved. An instance takes these from its file and not
+// from our environment.
+func fileOptions(values map[string][]string) map[string]bool {
+	options := make(map[string]bool)
+	for key := range values {
+		if o, _ := lookupOption(key); o != nil {
+			key = o.name
+		}
+		options[key] = true
+	}
+	for _, name := range addressOptions {
+		if options[name] {
+			for _, name := range addressOptions {
+				options[name] = true
+			}
+			break
+		}
+	}
+	return options
+}
+
+// fileVIPs returns the virtual IPs configured in the file at path, or in the
+// environment if the file configures none.
+func fileVIPs(path string) ([]string, error) {
+	values, err := parseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fromFile := fileOptions(values)
+	option := func(name string) []string {
+		if fromFile[name] {
+			return values[name]
+		}
+		if env, ok := os.LookupEnv(envName(name)); ok {
+			return strings.Fields(env)
+		}
+		return nil
+	}
+
+	var vips []string
+	for _, name := range []string{"ip", "ip6", "vip"} {
+		for _, value := range option(name) {
+			address, _, _ := strings.Cut(value, ",")
+			if vip, _, err := parseVIP(address); err == nil {
+				vips = append(vips, vip.String())
+			}
+		}
+	}
+	return vips, nil
+}
+
+// instanceEnv is our environment for the instance of the config file at
+// path. The options that only make sense for us are left out, an instance
+// would run a config directory of its own otherwise. So are the ones the
+// file sets, the environment would take precedence over the file.
+func instanceEnv(path string) []string {
+	values, _ := parseConfigFile(path)
+	drop := map[string]bool{envName("config-dir"): true, envName("config"): true}
+	for option := range fileOptions(values) {
+		if o := optionDefs[option]; o != nil {
+			for _, name := range o.envNames() {
+				drop[name] = true
+			}
+		}
+	}
+	var env []string
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		if !drop[name] {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+// validateFile runs validate-config on the file at path, so it is checked
+// exactly like the instance would check it.
+func (s *configDirSupervisor) validateFile(path string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"validate-config"}, s.args...)
+	cmd := exec.Command(self, append(args, path)...)
+	cmd.Env = instanceEnv(path)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// scan starts an instance for every new valid file, stops the ones whose
+// file is gone and tells the ones whose file changed to reload it. Files
+// with a virtual IP, address or file that another file already has are
+// rejected.
+func (s *configDirSupervisor) scan() {
+	files, err := configDirFiles(s.dir)
+	if err != nil {
+		slog.Error("Cannot read the config directory", "config_dir", s.dir, "error", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	present := make(map[string]bool)
+	for _, path := range files {
+		present[path] = true
+	}
+	for path, inst := range s.instances {
+		if !present[path] {
+			slog.Info("Config file removed, stopping its instance", "config", path)
+			s.stop(inst)
+			delete(s.instances, path)
+		}
+	}
+
+	// The running instances keep their addresses and files
+	owners := make(map[string]string)
+	for path, inst := range s.instances {
+		claim(owners, path, inst.vips, inst.resources)
+	}
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Cannot read config file", "config", path, "error", err)
+			continue
+		}
+		if inst := s.instances[path]; inst != nil {
+			if !info.ModTime().Equal(inst.modTime) {
+				slog.Info("Config file changed, reloading its instance", "config", path)
+				inst.modTime = info.ModTime()
+				if inst.cmd.Process != nil {
+					inst.cmd.Process.Signal(syscall.SIGHUP)
+				}
+			}
+			continue
+		}
+
+		if err := s.validateFile(path); err != nil {
+			slog.Error("Invalid config file, not starting its instance", "config", path, "error", err)
+			continue
+		}
+		vips, err := fileVIPs(path)
+		if err != nil {
+			slog.Error("Invalid config file, not starting its instance", "config", path, "error", err)
+			continue
+		}
+		resources, err := fileResources(path)
+		if err != nil {
+			slog.Error("Invalid config file, not starting its instance", "config", path, "error", err)
+			continue
+		}
+		if !claim(owners, path, vips, resources) {
+			continue
+		}
+		inst := &dirInstance{path: path, modTime: info.ModTime(), vips: vips, resources: resources}
+		s.instances[path] = inst
+		s.start(inst)
+	}
+}
+
+// instanceArgs names the instance after its file, unless the file or the
+// flags name it.
+func (s *configDirSupervisor) instanceArgs(path string) []string {
+	args := append([]string{"-config", path}, s.args...)
+	values, _ := parseConfigFile(path)
+	if instanceOption(values, "instance-name") == "" {
+		args = append(args, "-instance-name="+dirInstanceName(path, values))
+	}
+	return args
+}
+
+// start runs the instance of inst and restarts it if it exits on its own.
+// Has to be called with the lock held.
+func (s *configDirSupervisor) start(inst *dirInstance) {
+	self, err := os.Executable()
+	if err != nil {
+		slog.Error("Cannot start instance", "config", inst.path, "error", err)
+		return
+	}
+	inst.cmd = exec.Command(self, s.instanceArgs(inst.path)...)
+	inst.cmd.Env = instanceEnv(inst.path)
+	inst.cmd.Stdout, inst.cmd.Stderr = os.Stdout, os.Stderr
+	inst.done = make(chan struct{})
+	if err := inst.cmd.Start(); err != nil {
+		slog.Error("Cannot start instance", "config", inst.path, "error", err)
+		close(inst.done)
+		return
+	}
+	slog.Info("Started instance", "config", inst.path, "pid", inst.cmd.Process.Pid, "vip", strings.Join(inst.vips, ","))
+
+	go func() {
+		err := inst.cmd.Wait()
+		close(inst.done)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if inst.stopping || s.exiting {
+			return
+		}
+		code := inst.cmd.ProcessState.ExitCode()
+		if code == exitConfigError || code == exitPrivileges {
+			slog.Error("Instance exited, restarting does not help, fix it and send SIGHUP", "config", inst.path, "exit_code", code)
+			// Started again by the next scan
+			delete(s.instances, inst.path)
+			return
+		}
+		slog.Error("Instance exited, restarting it", "config", inst.path, "error", err, "restart_in", instanceRestartDelay)
+		time.AfterFunc(instanceRestartDelay, func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if !inst.stopping && !s.exiting && s.instances[inst.path] == inst {
+				s.start(inst)
+			}
+		})
+	}()
+}
+
+// stop asks the instance to shut down and does not wait for it. Has to be
+// called with the lock held.
+func (s *configDirSupervisor) stop(inst *dirInstance) {
+	inst.stopping = true
+	if inst.cmd != nil && inst.cmd.Process != nil && inst.cmd.ProcessState == nil {
+		inst.cmd.Process.Signal(syscall.SIGTERM)
+	}
+}
+
+// runConfigDir runs the instances of dir until we are asked to exit, then
+// stops all of them. It returns the exit code.
+func runConfigDir(dir string) int {
+	if err := checkExclusiveFlags(); err != nil {
+		slog.Error("Cannot start the instances of the config directory", "config_dir", dir, "error", err)
+		return exitConfigError
+	}
+	s := &configDirSupervisor{dir: dir, args: sharedArgs(), instances: make(map[string]*dirInstance)}
+	slog.Info("Starting "+versionString()+" for a config directory", "config_dir", dir, "pid", os.Getpid())
+
+	// Registered before the instances start, so a signal in between is not
+	// lost
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	s.scan()
+	s.lock.Lock()
+	started := len(s.instances)
+	s.lock.Unlock()
+	if started == 0 {
+		slog.Error("No valid config file in the config directory", "config_dir", dir)
+		return exitConfigError
+	}
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			reopenLogFile()
+			slog.Info("Received SIGHUP, scanning the config directory again", "config_dir", dir)
+			s.scan()
+			continue
+		}
+		break
+	}
+
+	slog.Info("Received exit signal, stopping all instances")
+	s.lock.Lock()
+	s.exiting = true
+	var running []*dirInstance
+	for _, inst := range s.instances {
+		s.stop(inst)
+		running = append(running, inst)
+	}
+	s.lock.Unlock()
+	// The instances have their own shutdown timeout
+	for _, inst := range running {
+		if inst.done != nil {
+			<-inst.done
+		}
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConfigDirCollisions checks that the second of two config files is not
+// started when it would bind or lock the same as the first.
+func TestConfigDirCollisions(t *testing.T) {
+	savedGiven := givenOptions
+	t.Cleanup(func() {
+		givenOptions = savedGiven
+		resetOptions(t)
+	})
+	isolateFlags(t)
+	run := t.TempDir()
+
+	tests := []struct {
+		name  string
+		files [2]string
+		flags map[string]string
+		env   map[string]string
+		// Whether the second file is started
+		want bool
+	}{
+		{
+			name:  "own addresses",
+			files: [2]string{"ip: 10.1.2.3\nhttp-listen: localhost:9090\n", "ip: 10.1.2.4\nhttp-listen: localhost:9091\n"},
+			want:  true,
+		},
+		{
+			name:  "same address in the files",
+			files: [2]string{"ip: 10.1.2.3\nhttp-listen: localhost:9090\n", "ip: 10.1.2.4\nhttp_listen: localhost:9090\n"},
+		},
+		{
+			name:  "same address in the environment",
+			files: [2]string{"ip: 10.1.2.3\n", "ip: 10.1.2.4\n"},
+			env:   map[string]string{"VIP_PPROF_LISTEN": "localhost:6060"},
+		},
+		{
+			name:  "pid file directory",
+			files: [2]string{"ip: 10.1.2.3\n", "ip: 10.1.2.4\n"},
+			flags: map[string]string{"pid-file": run, "control-socket": run + "/"},
+			want:  true,
+		},
+		{
+			name:  "pid file directory and the same instance name",
+			files: [2]string{"ip: 10.1.2.3\ninstance-name: batman\n", "ip: 10.1.2.4\ninstance-name: batman\n"},
+			flags: map[string]string{"pid-file": run},
+		},
+		{
+			name:  "same virtual IP",
+			files: [2]string{"ip: 10.1.2.3\n", "ip: 10.1.2.3\n"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetOptions(t)
+			givenOptions = make(map[string]bool)
+			for name, value := range test.flags {
+				if err := flag.Set(name, value); err != nil {
+					t.Fatalf("cannot set -%s: %s", name, err)
+				}
+				givenOptions[name] = true
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if err := checkExclusiveFlags(); err != nil {
+				t.Fatalf("checkExclusiveFlags() = %s", err)
+			}
+
+			dir := t.TempDir()
+			owners := make(map[string]string)
+			for i, content := range test.files {
+				path := filepath.Join(dir, []string{"a.yml", "b.yml"}[i])
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+				vips, err := fileVIPs(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resources, err := fileResources(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				started := claim(owners, path, vips, resources)
+				if i == 0 && !started {
+					t.Fatalf("the first file is not started")
+				}
+				if i == 1 && started != test.want {
+					t.Errorf("the second file is started: %t, want %t, owners %v", started, test.want, owners)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckExclusiveFlags(t *testing.T) {
+	savedGiven := givenOptions
+	t.Cleanup(func() {
+		givenOptions = savedGiven
+		resetOptions(t)
+	})
+	isolateFlags(t)
+	run := t.TempDir()
+	file := filepath.Join(run, "vip-manager.pid")
+
+	tests := []struct {
+		option, value string
+		wantErr       bool
+	}{
+		{option: "http-listen", value: "localhost:9090", wantErr: true},
+		{option: "pprof-listen", value: "localhost:6060", wantErr: true},
+		{option: "pid-file", value: file, wantErr: true},
+		{option: "control-socket", value: file, wantErr: true},
+		{option: "pid-file", value: run},
+		{option: "control-socket", value: filepath.Join(run, "sockets") + "/"},
+		{option: "http-listen", value: ""},
+	}
+	for _, test := range tests {
+		resetOptions(t)
+		givenOptions = map[string]bool{test.option: true}
+		if err := flag.Set(test.option, test.value); err != nil {
+			t.Fatalf("cannot set -%s: %s", test.option, err)
+		}
+		if err := checkExclusiveFlags(); (err != nil) != test.wantErr {
+			t.Errorf("-%s=%s: checkExclusiveFlags() = %v, want error %t", test.option, test.value, err, test.wantErr)
+		}
+	}
+}
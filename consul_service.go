@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+var consulServiceName = stringOption("consul-service", "", "Register a Consul service of this name with the virtual IP as address while this node holds it, e.g. master.pg for master.pg.service.consul. Empty disables the registration.")
+var consulServicePort = intOption("consul-service-port", 5432, "Port of the Consul service")
+var consulServiceTags = stringOption("consul-service-tags", "", "Tags of the Consul service, separated by commas")
+var consulServiceTTL = durationOption("consul-service-ttl", 30*time.Second, "TTL of the health check of the Consul service. It is kept passing while this node holds the virtual IP, so the service fails this long after vip-manager died, and is removed a minute later.")
+var consulServiceEndpoint = stringOption("consul-service-endpoint", "", "Consul agent to register the service with. Defaults to -dcs-endpoint with -dcs-type=consul, else http://127.0.0.1:8500.")
+
+// How long a request to the Consul agent may take, it is local and this
+// runs in the apply loop
+const consulServiceTimeout = 2 * time.Second
+
+// The service of -consul-service, if any
+var consulService *ConsulService
+
+// ConsulService registers a service with a TTL check for every virtual IP
+// this node holds, and keeps the checks passing.
+type ConsulService struct {
+	agent *api.Agent
+	name  string
+	port  int
+	tags  []string
+	ttl   time.Duration
+
+	lock sync.Mutex
+	// Service ids of the registered virtual IPs
+	registered map[string]string
+	// Only log the first of a series of failures
+	failing bool
+}
+
+func NewConsulService(endpoint, name string, port int, tags string, ttl time.Duration) (*ConsulService, error) {
+	client, err := api.NewClient(&api.Config{
+		Address:    endpoint,
+		HttpClient: &http.Client{Timeout: consulServiceTimeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &ConsulService{
+		agent:      client.Agent(),
+		name:       name,
+		port:       port,
+		ttl:        ttl,
+		registered: make(map[string]string),
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return s, nil
+}
+
+// consulServiceAgent returns the Consul agent to register with.
+func consulServiceAgent() string {
+	if *consulServiceEndpoint != "" {
+		return *consulServiceEndpoint
+	}
+	if *endpointType == "consul" {
+		return strings.TrimSpace(strings.Split(*endpoint, ",")[0])
+	}
+	return "http://127.0.0.1:8500"
+}
+
+func (s *ConsulService) serviceID(vip string) string {
+	return fmt.Sprintf("vip-manager-%s-%s", s.name, vip)
+}
+
+// failed logs err unless the previous request failed as well.
+func (s *ConsulService) failed(msg, vip string, err error) {
+	if !s.failing {
+		slog.Warn(msg, "service", s.name, "vip", vip, "error", err)
+	}
+	s.failing = true
+}
+
+func (s *ConsulService) succeeded() {
+	if s.failing {
+		slog.Info("Consul agent is reachable again", "service", s.name)
+	}
+	s.failing = false
+}
+
+// Register registers the service for vip, unless it already is.
+func (s *ConsulService) Register(vip string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.registered[vip]; ok {
+		return
+	}
+	id := s.serviceID(vip)
+	err := s.agent.ServiceRegister(&api.AgentServiceRegistration{
+		ID:      id,
+		Name:    s.name,
+		Tags:    s.tags,
+		Port:    s.port,
+		Address: vip,
+		Check: &api.AgentServiceCheck{
+			TTL:    s.ttl.String(),
+			Status: api.HealthPassing,
+			Notes:  "Passing while vip-manager holds " + vip,
+			// Consul does not remove services any sooner
+			DeregisterCriticalServiceAfter: time.Minute.String(),
+		},
+	})
+	if err != nil {
+		// Retried at the next recheck
+		s.failed("Cannot register the Consul service", vip, err)
+		return
+	}
+	s.succeeded()
+	s.registered[vip] = id
+	slog.Info("Registered the Consul service", "service", s.name, "id", id, "vip", vip)
+}
+
+// Deregister removes the service of vip. Should that fail, the check is no
+// longer passed and the service expires.
+func (s *ConsulService) Deregister(vip string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id, ok := s.registered[vip]
+	if !ok {
+		return
+	}
+	delete(s.registered, vip)
+	if err := s.agent.ServiceDeregister(id); err != nil {
+		s.failed("Cannot deregister the Consul service, it expires after -consul-service-ttl", vip, err)
+		return
+	}
+	s.succeeded()
+	slog.Info("Deregistered the Consul service", "service", s.name, "id", id, "vip", vip)
+}
+
+// Run keeps the checks of the registered services passing until ctx is
+// done, then deregisters them.
+func (s *ConsulService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.lock.Lock()
+			vips := make([]string, 0, len(s.registered))
+			for vip := range s.registered {
+				vips = append(vips, vip)
+			}
+			s.lock.Unlock()
+			for _, vip := range vips {
+				s.Deregister(vip)
+			}
+			return
+		case <-ticker.C:
+			s.passChecks()
+		}
+	}
+}
+
+func (s *ConsulService) passChecks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for vip, id := range s.registered {
+		if err := s.agent.UpdateTTL("service:"+id, "", api.HealthPassing); err != nil {
+			// E.g. the agent was restarted and lost the service, it is
+			// registered again at the next recheck
+			s.failed("Cannot update the check of the Consul service", vip, err)
+			delete(s.registered, vip)
+			continue
+		}
+		s.succeeded()
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var controlSocket = stringOption("control-socket", "", "Unix socket for runtime commands, e.g. vip-manager status. Only the owner may connect. For a directory, the socket is named after -instance-name, e.g. vip-manager-batman.sock.")
+
+// How long a client may take to send a request
+const controlSocketTimeout = 10 * time.Second
+
+// controlRequest is one line of JSON sent to the control socket.
+type controlRequest struct {
+	// status, pause, resume, force-release, force-reconcile or
+	// set-log-level
+	Command string `json:"command"`
+	// For set-log-level
+	Level string `json:"level,omitempty"`
+}
+
+// controlResponse is the line of JSON sent back for every request.
+type controlResponse struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+// controlSocketPath returns path, or a socket named after the instance if
+// path is a directory.
+func controlSocketPath(path string) string {
+	return instanceFile(path, instance(), ".sock")
+}
+
+// removeStaleSocket removes a socket left behind by an instance that did
+// not exit cleanly, and fails if another instance still answers on it,
+// unless -force is given.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		pid := peerPID(conn.(*net.UnixConn))
+		conn.Close()
+		if !*force {
+			return &runningError{pid: pid, source: path}
+		}
+		slog.Warn("Taking over the control socket of another vip-manager because of -force", "control_socket", path, "pid", pid)
+	}
+	return os.Remove(path)
+}
+
+// startControlSocket serves runtime commands on the unix socket at path
+// until ctx is done.
+func startControlSocket(ctx context.Context, path string, manager *ipmanager.IPManager) error {
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	slog.Info("Listening for commands", "control_socket", path)
+
+	go func() {
+		<-ctx.Done()
+		// Also removes the socket
+		l.Close()
+	}()
+	go func() {
+		defer recoverPanic()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Control socket failed", "control_socket", path, "error", err)
+				}
+				return
+			}
+			go serveControlConn(conn.(*net.UnixConn), manager)
+		}
+	}()
+	return nil
+}
+
+// serveControlConn answers requests on conn, one per line, until the client
+// closes it.
+func serveControlConn(conn *net.UnixConn, manager *ipmanager.IPManager) {
+	defer recoverPanic()
+	defer conn.Close()
+	uid := peerUID(conn)
+	scn := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(controlSocketTimeout))
+		if !scn.Scan() {
+			return
+		}
+		var req controlRequest
+		var resp controlResponse
+		if err := json.Unmarshal(scn.Bytes(), &req); err != nil {
+			resp.Error = "invalid request: " + err.Error()
+		} else {
+			resp.Result, err = runControlCommand(req, uid, manager)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		resp.OK = resp.Error == ""
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// runControlCommand carries out req for the user with uid and returns the
+// result for the client.
+func runControlCommand(req controlRequest, uid int, manager *ipmanager.IPManager) (any, error) {
+	switch req.Command {
+	case "status":
+		return currentStatus(), nil
+	case "pause", "resume":
+		active := req.Command == "pause"
+		slog.Info("Maintenance mode requested", "active", active, "uid", uid)
+		managers.SetMaintenance(active)
+	case "force-release":
+		slog.Warn("Forcing release on request", "uid", uid)
+		manager.ForceRelease()
+	case "force-reconcile":
+		slog.Info("Reconciling on request", "uid", uid)
+		manager.Reconcile()
+	case "set-log-level":
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			return nil, err
+		}
+		logLevel.Set(level)
+		slog.Warn("Log level changed on request", "level", levelName(level), "uid", uid)
+	default:
+		return nil, fmt.Errorf("unknown command %q", req.Command)
+	}
+	return nil, nil
+}
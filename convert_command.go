@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// convertConfigCommand implements "vip-manager convert-config [options]".
+// It takes the options of an existing installation as flags and from the
+// environment, e.g. the arguments of a systemd unit with its environment
+// file, and prints the equivalent config file. It returns the exit code.
+func convertConfigCommand(args []string) int {
+	output := flag.String("output", "", "Write the config file to this path instead of to stdout")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s convert-config [-output file] [options]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Prints a config file with the options given as flags, in the environment and in -config, for use with -config. Options at their default are left out.")
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitFailure
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		return exitFailure
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	doc, warnings := configDocument("config")
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+w)
+	}
+	if *output == "" {
+		fmt.Print(doc)
+		return 0
+	}
+	// It may well contain passwords
+	if err := os.WriteFile(*output, []byte(doc), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return 0
+}
+
+// configDocument returns a config file with every option that is not at its
+// default, except the ones in skip, and warnings about options that were
+// left out.
+func configDocument(skip ...string) (string, []string) {
+	var b strings.Builder
+	var warnings []string
+	fmt.Fprintf(&b, "# Converted by vip-manager %s\n", version)
+options:
+	for _, o := range allOptions() {
+		for _, name := range skip {
+			if o.name == name {
+				continue options
+			}
+		}
+		if l, ok := o.flag.Value.(*vipList); ok {
+			if specs := l.specs(); len(specs) > 0 {
+				fmt.Fprintf(&b, "%s:\n", o.name)
+				for _, spec := range specs {
+					fmt.Fprintf(&b, "  - %s\n", quoteConfigValue(spec))
+				}
+			}
+			continue
+		}
+		if optionSources[o.name] == "secret-file" {
+			// Its -file variant is written instead
+			continue
+		}
+		value := o.flag.Value.String()
+		if o.name == "host" && value == "none" {
+			warnings = append(warnings, "host: none is the old way of using the hostname, which is the default now, left out")
+			continue
+		}
+		if value != o.flag.DefValue {
+			fmt.Fprintf(&b, "%s: %s\n", o.name, quoteConfigValue(value))
+		}
+	}
+	return b.String(), warnings
+}
+
+// quoteConfigValue quotes every value, parseConfigFile takes everything
+// between the outer quotes as is, apart from references to environment
+// variables.
+func quoteConfigValue(value string) string {
+	return `"` + envReference.ReplaceAllString(value, "$$${0}") + `"`
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// optionValues returns the current value of every option.
+func optionValues() map[string]string {
+	values := make(map[string]string)
+	for _, o := range allOptions() {
+		if l, ok := o.flag.Value.(*vipList); ok {
+			values[o.name] = strings.Join(l.specs(), " ")
+			continue
+		}
+		values[o.name] = o.flag.Value.String()
+	}
+	return values
+}
+
+// resetOptions sets every option back to its default.
+func resetOptions(t *testing.T) {
+	t.Helper()
+	for _, o := range allOptions() {
+		if l, ok := o.flag.Value.(*vipList); ok {
+			*l = nil
+			continue
+		}
+		if err := o.flag.Value.Set(o.flag.DefValue); err != nil {
+			t.Fatalf("cannot reset -%s: %s", o.name, err)
+		}
+	}
+}
+
+// isolateFlags gives the test a command line of its own with the same
+// options, so that the flags it sets do not count as given in later tests.
+func isolateFlags(t *testing.T) {
+	saved := flag.CommandLine
+	flags := flag.NewFlagSet(saved.Name(), flag.ContinueOnError)
+	saved.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	flag.CommandLine = flags
+	t.Cleanup(func() { flag.CommandLine = saved })
+}
+
+// TestConvertConfigRoundTrip sets options as flags and in the environment,
+// converts them into a config file and reads that back. Every option has to
+// end up with the value it had before.
+func TestConvertConfigRoundTrip(t *testing.T) {
+	t.Cleanup(func() { resetOptions(t) })
+	isolateFlags(t)
+	flags := map[string]string{
+		"type":                  "consul",
+		"dcs-endpoint":          "http://127.0.0.1:8500",
+		"ip":                    "10.1.2.3",
+		"mask":                  "255.255.254.0",
+		"iface":                 "eth0",
+		"retain-vip-on-exit":    "true",
+		"shutdown-grace-period": "1m30s",
+		"primary-check-query":   `SELECT 'a: "b"' # not a comment`,
+	}
+	for name, value := range flags {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("-%s %s: %s", name, value, err)
+		}
+	}
+	t.Setenv("VIP_KEY", "/service/pg cluster/leader")
+	t.Setenv("VIP_VIP", "10.1.3.5/24,iface=eth1,label=eth1:repl fd00::10/64,announce=false")
+	t.Setenv("VIP_CHECKER_MAX_RESTARTS", "3")
+	t.Setenv("VIP_SMTP_PASSWORD", "pa$$word with $HOME")
+	t.Setenv("VIP_STATSD_TAGS", "env:prod,dc:${DC}")
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want := optionValues()
+
+	doc, warnings := configDocument("config")
+	if len(warnings) > 0 {
+		t.Errorf("warnings: %q", warnings)
+	}
+	path := filepath.Join(t.TempDir(), "vip-manager.yml")
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resetOptions(t)
+	for _, name := range []string{"VIP_KEY", "VIP_VIP", "VIP_CHECKER_MAX_RESTARTS", "VIP_SMTP_PASSWORD", "VIP_STATSD_TAGS"} {
+		os.Unsetenv(name)
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, given := range values {
+		for _, value := range given {
+			if err := flag.Set(name, value); err != nil {
+				t.Fatalf("%s: %s: %s", path, name, err)
+			}
+		}
+	}
+
+	got := optionValues()
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("-%s is %q after the round trip, want %q", name, got[name], want[name])
+		}
+	}
+	if t.Failed() {
+		t.Logf("config file:\n%s", doc)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	runtimedebug "runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var crashMarker = stringOption("crash-marker", "", "File to write the panic and stack to when vip-manager crashes. It is reported and removed at the next start. Empty disables it.")
+
+// How long releasing the addresses after a panic may take
+const crashReleaseTimeout = 5 * time.Second
+
+var (
+	crashing int32
+	// Set once the manager exists, to release the addresses on a panic
+	crashManager *ipmanager.IPManager
+)
+
+// recoverPanic hands a panic of the calling goroutine to crash, it has to be
+// deferred directly.
+func recoverPanic() {
+	if v := recover(); v != nil {
+		crash(v, runtimedebug.Stack())
+	}
+}
+
+// crash logs a panic, releases the addresses if this node was not the
+// leader, writes -crash-marker and exits with exitInternalError. A panic
+// during all this exits right away, a panic in another goroutine waits for
+// the first one to finish.
+func crash(value any, stack []byte) {
+	if !atomic.CompareAndSwapInt32(&crashing, 0, 1) {
+		select {}
+	}
+	defer func() {
+		recover()
+		os.Exit(exitInternalError)
+	}()
+
+	slog.Error("Internal error, releasing the virtual IP and exiting", "panic", value, "stack", string(stack))
+	if *crashMarker != "" {
+		content := fmt.Sprintf("%s %s\npanic: %v\n\n%s", time.Now().Format(time.RFC3339), versionString(), value, stack)
+		if err := os.WriteFile(*crashMarker, []byte(content), 0640); err != nil {
+			slog.Error("Cannot write crash marker", "crash_marker", *crashMarker, "error", err)
+		}
+	}
+	if crashManager != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), crashReleaseTimeout)
+		defer cancel()
+		crashManager.ReleaseAfterPanic(ctx)
+	}
+	if *pidFile != "" {
+		removePidFile(*pidFile)
+	}
+}
+
+// reportCrashMarker logs a crash of the previous run and removes its marker.
+func reportCrashMarker() {
+	if *crashMarker == "" {
+		return
+	}
+	content, err := os.ReadFile(*crashMarker)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Cannot read crash marker", "crash_marker", *crashMarker, "error", err)
+		return
+	}
+	header, _, _ := strings.Cut(string(content), "\n\n")
+	slog.Warn("The previous run crashed", "crash_marker", *crashMarker, "crash", header)
+	if err := os.Remove(*crashMarker); err != nil {
+		slog.Warn("Cannot remove crash marker", "crash_marker", *crashMarker, "error", err)
+	}
+}
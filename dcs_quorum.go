@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+var dcsQuorum = intOption("dcs-quorum", 0, "Only hold the virtual IP while this many of -dcs-endpoint and -dcs-quorum-endpoints agree that this node is leader, e.g. 2 of 3. 0 trusts -dcs-endpoint alone.", notNegative(), reloadable(true))
+var dcsQuorumEndpoints = stringOption("dcs-quorum-endpoints", "", "Further DCS endpoints that vote with -dcs-endpoint when -dcs-quorum is set, separated by commas. Each is read independently, with the same -key. Prefix an endpoint with consul= or etcd= when its type differs from -dcs-type.", reloadable(true))
+var dcsQuorumStaleAfter = durationOption("dcs-quorum-stale-after", 30*time.Second, "The vote of an endpoint that was not read successfully for this long no longer counts.", reloadable(true))
+
+type quorumEndpoint struct {
+	endpointType, endpoint string
+}
+
+// quorumEndpoints returns the endpoints that vote when -dcs-quorum is set,
+// -dcs-endpoint first.
+func quorumEndpoints() ([]quorumEndpoint, error) {
+	endpoints := []quorumEndpoint{{*endpointType, *endpoint}}
+	for _, e := range strings.Split(*dcsQuorumEndpoints, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		q := quorumEndpoint{*endpointType, e}
+		if t, rest, ok := strings.Cut(e, "="); ok {
+			q = quorumEndpoint{t, rest}
+		}
+		switch q.endpointType {
+		case "etcd", "consul":
+		default:
+			return nil, fmt.Errorf("%q is not supported, expected etcd or consul", q.endpointType)
+		}
+		endpoints = append(endpoints, q)
+	}
+	return endpoints, nil
+}
+
+// newQuorumChecker runs a leader checker for every endpoint of the quorum.
+func newQuorumChecker(name string, transport *http.Transport) (checker.LeaderChecker, error) {
+	endpoints, err := quorumEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]checker.QuorumMember, len(endpoints))
+	for i, e := range endpoints {
+		lc, err := checker.NewLeaderChecker(e.endpointType, e.endpoint, *key, name, transport)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.endpoint, err)
+		}
+		members[i] = checker.QuorumMember{Name: e.endpoint, Checker: lc, StaleAfter: *dcsQuorumStaleAfter}
+	}
+	return checker.NewQuorumChecker(members, *dcsQuorum)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// watchDCS tells the notifiers when the DCS was not read successfully for threshold,
+// and when it is read again. Nothing is sent before the first read.
+func watchDCS(ctx context.Context, threshold time.Duration, vip string, notifiers ...ipmanager.Notifier) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	down := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, _, at := checker.LastValue()
+		if at.IsZero() {
+			continue
+		}
+		if stale := time.Since(at) > threshold; stale != down {
+			down = stale
+			e := ipmanager.Event{Type: ipmanager.EventDCSUp, VIP: vip, Time: time.Now()}
+			if down {
+				e.Type = ipmanager.EventDCSDown
+			}
+			for _, n := range notifiers {
+				n.Notify(e)
+			}
+		}
+	}
+}
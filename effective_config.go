@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+)
+
+// Where each option got its value from: flag, env, file or default. Set by
+// loadConfig and reloadConfig.
+var optionSources = make(map[string]string)
+
+// effectiveOption is an option with its value, secrets redacted, and where
+// the value came from.
+type effectiveOption struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// fingerprint tells secrets apart without showing them: the first
+// characters, at most 4 and never more than half, and the length.
+func fingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	n := len(secret) / 2
+	if n > 4 {
+		n = 4
+	}
+	return fmt.Sprintf("%s...(%d characters)", secret[:n], len(secret))
+}
+
+// effectiveConfig returns every option as it is in effect now. All
+// redaction happens here, so neither the log nor /status can show a secret.
+func effectiveConfig() []effectiveOption {
+	var options []effectiveOption
+	for _, o := range allOptions() {
+		value := o.flag.Value.String()
+		if l, ok := o.flag.Value.(*vipList); ok {
+			value = strings.Join(l.specs(), " ")
+		}
+		if o.secret {
+			value = fingerprint(value)
+		} else {
+			// Passwords in URLs and connection strings
+			value = tracelog.Redact(value)
+		}
+		source := optionSources[o.name]
+		if source == "" {
+			source = "default"
+		}
+		options = append(options, effectiveOption{Name: o.name, Value: value, Source: source})
+	}
+	return options
+}
+
+// logEffectiveConfig logs all options in one message, so that it is clear
+// which configuration the process actually runs with.
+func logEffectiveConfig() {
+	var attrs []any
+	for _, o := range effectiveConfig() {
+		attrs = append(attrs, slog.Group(o.Name, "value", o.Value, "source", o.Source))
+	}
+	slog.Info("Effective configuration", slog.Group("options", attrs...))
+}
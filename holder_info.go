@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var holderInfoPrefix = stringOption("holder-info-prefix", "", "Write which addresses this node holds as JSON to <prefix>/<host> in the DCS, e.g. /vip-manager/batman. Empty disables it.")
+var holderInfoInterval = durationOption("holder-info-interval", time.Minute, "How often the holder information is refreshed, it is also written right after every transition")
+var holderInfoTTL = durationOption("holder-info-ttl", 3*time.Minute, "How long the holder information of a node that stopped refreshing it stays in the DCS")
+
+// How long writing the holder information may take
+const holderInfoTimeout = 5 * time.Second
+
+// The holder information of -holder-info-prefix, if any
+var holderInfo *HolderInfo
+
+// holderDocument is the JSON written to the DCS.
+type holderDocument struct {
+	Node     string `json:"node"`
+	Hostname string `json:"hostname"`
+	VIP      string `json:"vip"`
+	// held or released
+	State   string    `json:"state"`
+	PID     int       `json:"pid"`
+	Version string    `json:"version"`
+	Time    time.Time `json:"timestamp"`
+}
+
+// HolderInfo keeps a document in the DCS that tells whether this node holds
+// the virtual IPs, next to the leader key that only tells whether it should.
+type HolderInfo struct {
+	writer   checker.HolderWriter
+	key      string
+	node     string
+	interval time.Duration
+	// Signals a transition, the document is written right away
+	changed chan struct{}
+	failing bool
+}
+
+func NewHolderInfo(prefix string, interval, ttl time.Duration) (*HolderInfo, error) {
+	node, err := nodeName()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := checker.NewTransport(*proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	key := path.Join(prefix, node)
+	w, err := checker.NewHolderWriter(*endpointType, *endpoint, key, ttl, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &HolderInfo{writer: w, key: key, node: node, interval: interval, changed: make(chan struct{}, 1)}, nil
+}
+
+// Notify marks the document as outdated after a transition.
+func (h *HolderInfo) Notify(e ipmanager.Event) {
+	if e.Type != ipmanager.EventAcquire && e.Type != ipmanager.EventRelease {
+		return
+	}
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run writes the document at every interval and transition until ctx is
+// done, then deletes it.
+func (h *HolderInfo) Run(ctx context.Context, manager *ipmanager.IPManager) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.write(ctx, manager)
+		select {
+		case <-ctx.Done():
+			delCtx, cancel := context.WithTimeout(context.Background(), holderInfoTimeout)
+			defer cancel()
+			if err := h.writer.Delete(delCtx); err != nil {
+				slog.Warn("Cannot delete the holder information, it expires after -holder-info-ttl", "key", h.key, "error", err)
+			}
+			return
+		case <-ticker.C:
+		case <-h.changed:
+		}
+	}
+}
+
+func (h *HolderInfo) write(ctx context.Context, manager *ipmanager.IPManager) {
+	hostname, _ := os.Hostname()
+	doc := holderDocument{
+		Node:     h.node,
+		Hostname: hostname,
+		VIP:      manager.CIDRs(),
+		State:    "released",
+		PID:      os.Getpid(),
+		Version:  version,
+		Time:     time.Now().UTC(),
+	}
+	if manager.Status(*healthDCSThreshold).ActualState {
+		doc.State = "held"
+	}
+	value, _ := json.Marshal(doc)
+
+	ctx, cancel := context.WithTimeout(ctx, holderInfoTimeout)
+	defer cancel()
+	err := h.writer.Write(ctx, value)
+	if err != nil && !h.failing && ctx.Err() == nil {
+		slog.Warn("Cannot write the holder information", "key", h.key, "error", err)
+	} else if err == nil && h.failing {
+		slog.Info("Writing the holder information again", "key", h.key)
+	}
+	h.failing = err != nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var readiness = stringOption("readiness", "dcs", "What /readyz checks unless ?mode= is given: dcs for a successful read of the DCS within -health-dcs-threshold, leader for this node holding the virtual IP, e.g. for a load balancer", oneOf("dcs", "leader"))
+
+// The JSON served on /livez and /readyz, so that failed probes can be
+// understood from the logs of the prober.
+type probeResponse struct {
+	OK     bool   `json:"ok"`
+	Mode   string `json:"mode,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func writeProbe(w http.ResponseWriter, mode string, err error) {
+	resp := probeResponse{OK: err == nil, Mode: mode, Reason: "ok"}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		resp.Reason = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkReadiness reports why this node is not ready in mode, which is dcs
+// or leader.
+func checkReadiness(mode string) error {
+	switch mode {
+	case "dcs":
+		return ipmanager.DCSReachable(*healthDCSThreshold)
+	case "leader":
+		s := managers.Status(*healthDCSThreshold)
+		switch {
+		case !s.DesiredState:
+			return fmt.Errorf("not the leader according to %s", s.TriggerKey)
+		case !s.ActualState:
+			return fmt.Errorf("the leader, but the virtual IP is not configured yet")
+		}
+	}
+	return nil
+}
+
+// The JSON served on /status, the status of the manager with the version and
+// instance in front.
+type statusResponse struct {
+	Version  string `json:"version"`
+	Instance string `json:"instance"`
+	Pid      int    `json:"pid"`
+	ipmanager.Status
+	// Only with ?config=true
+	Config []effectiveOption `json:"config,omitempty"`
+}
+
+func currentStatus() statusResponse {
+	return statusResponse{
+		Version:  version,
+		Instance: instance(),
+		Pid:      os.Getpid(),
+		Status:   managers.Status(*healthDCSThreshold),
+	}
+}
+
+func startHTTPServer(addr string, manager *ipmanager.IPManager) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := managers.Health(*healthDCSThreshold); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	// Only whether the process works, for restarting it
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, "", manager.Liveness())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = *readiness
+		} else if mode != "dcs" && mode != "leader" {
+			http.Error(w, "expected ?mode=dcs or ?mode=leader", http.StatusBadRequest)
+			return
+		}
+		writeProbe(w, mode, checkReadiness(mode))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		s := currentStatus()
+		if withConfig, _ := strconv.ParseBool(r.URL.Query().Get("config")); withConfig {
+			s.Config = effectiveConfig()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	})
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		active, err := strconv.ParseBool(r.URL.Query().Get("active"))
+		if err != nil {
+			http.Error(w, "expected ?active=true or ?active=false", http.StatusBadRequest)
+			return
+		}
+		slog.Info("Maintenance mode requested", "active", active, "remote", r.RemoteAddr)
+		managers.SetMaintenance(active)
+	})
+	if *arpProbe {
+		mux.HandleFunc("/force-takeover", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			slog.Warn("Forcing takeover on request", "remote", r.RemoteAddr)
+			manager.ForceTakeover()
+		})
+	}
+
+	srv := &http.Server{Addr: addr, Handler: requireBearerToken(mux)}
+	if *httpTLSCert != "" {
+		var err error
+		if httpCerts, err = newCertificates(); err != nil {
+			fatal("Cannot load the certificates of the HTTP listener", "cert", *httpTLSCert, "error", err)
+		}
+		srv.TLSConfig = httpCerts.tlsConfig()
+	} else if !isLoopbackListen(addr) {
+		slog.Warn("HTTP listener is reachable from other hosts without TLS", "listen", addr)
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("HTTP server failed", "listen", addr, "error", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// The /status document that scripts and load balancers rely on. Fields may
+// be added, but these must keep their name, type and meaning.
+const statusContract = `{
+	"version": "2.1.0",
+	"instance": "main",
+	"pid": 1234,
+	"healthy": true,
+	"maintenance": false,
+	"desired_state": true,
+	"actual_state": true,
+	"addresses": [{"address": "10.0.0.1/24", "interface": "eth0", "present": true}],
+	"trigger_key": "/service/pgcluster/leader",
+	"trigger_value": "node1",
+	"trigger_revision": 42,
+	"trigger_stale": false,
+	"last_dcs_read": "2026-01-02T03:04:05Z",
+	"last_transition": null,
+	"managers": [{"name": "dns", "healthy": false, "error": "timeout"}],
+	"quorum": [{"member": "http://etcd1:2379", "leader": true, "value": "node1", "fresh": true, "last_report": "2026-01-02T03:04:05Z"}]
+}`
+
+func TestStatusContract(t *testing.T) {
+	readAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := statusResponse{
+		Version:  "2.1.0",
+		Instance: "main",
+		Pid:      1234,
+		Status: ipmanager.Status{
+			Healthy:         true,
+			DesiredState:    true,
+			ActualState:     true,
+			Addresses:       []ipmanager.AddressStatus{{Address: "10.0.0.1/24", Interface: "eth0", Present: true}},
+			TriggerKey:      "/service/pgcluster/leader",
+			TriggerValue:    "node1",
+			TriggerRevision: 42,
+			LastDCSRead:     &readAt,
+			Managers:        []ipmanager.ManagerHealth{{Name: "dns", Error: "timeout"}},
+			Quorum:          []checker.QuorumVote{{Member: "http://etcd1:2379", Leader: true, Value: "node1", Fresh: true, LastReport: &readAt}},
+		},
+	}
+	output, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got interface{}
+	if err := json.Unmarshal([]byte(statusContract), &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(output, &got); err != nil {
+		t.Fatal(err)
+	}
+	checkContains(t, "status", want, got)
+
+	// Without ?config=true, and for a single manager without a quorum
+	output, err = json.Marshal(statusResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(output, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, omitted := range []string{"config", "managers", "quorum"} {
+		if _, ok := fields[omitted]; ok {
+			t.Errorf("status has %s although it is empty", omitted)
+		}
+	}
+	for _, null := range []string{"last_dcs_read", "last_transition"} {
+		if value, ok := fields[null]; !ok || value != nil {
+			t.Errorf("status has %s = %v, want null", null, value)
+		}
+	}
+}
+
+// checkContains reports every field of want that got lacks or has with
+// another value. Fields only in got are fine.
+func checkContains(t *testing.T, path string, want, got interface{}) {
+	t.Helper()
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is %v, want an object", path, got)
+			return
+		}
+		for key, value := range want {
+			field, ok := got[key]
+			if !ok {
+				t.Errorf("%s.%s is missing", path, key)
+				continue
+			}
+			checkContains(t, path+"."+key, value, field)
+		}
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			t.Errorf("%s is %v, want %d elements", path, got, len(want))
+			return
+		}
+		for i := range want {
+			checkContains(t, fmt.Sprintf("%s[%d]", path, i), want[i], got[i])
+		}
+	default:
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s is %#v, want %#v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var httpTLSCert = stringOption("http-tls-cert", "", "Certificate file to serve the HTTP listener with TLS, requires -http-tls-key. Reloaded on SIGHUP.")
+var httpTLSKey = stringOption("http-tls-key", "", "Private key file of -http-tls-cert. Reloaded on SIGHUP.")
+var httpClientCA = stringOption("http-client-ca", "", "CA file to require client certificates signed by it on the HTTP listener, requires -http-tls-cert. Reloaded on SIGHUP.")
+var httpBearerToken = stringOption("http-bearer-token", "", "Token that requests to the HTTP listener have to send as bearer token, except for /livez. Empty allows all requests.", secret())
+
+// The certificates of the HTTP listener, nil without TLS
+var httpCerts *certificates
+
+// certificates holds the server certificate and client CAs of the HTTP
+// listener, so they can be replaced while serving.
+type certificates struct {
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// loadCertificates reads the files of -http-tls-cert, -http-tls-key and
+// -http-client-ca.
+func loadCertificates() (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(*httpTLSCert, *httpTLSKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *httpClientCA == "" {
+		return &cert, nil, nil
+	}
+	pem, err := os.ReadFile(*httpClientCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, errors.New(*httpClientCA + " contains no certificates")
+	}
+	return &cert, pool, nil
+}
+
+func newCertificates() (*certificates, error) {
+	cert, pool, err := loadCertificates()
+	if err != nil {
+		return nil, err
+	}
+	return &certificates{cert: cert, clientCAs: pool}, nil
+}
+
+// reload reads the files again. The previous certificates stay in use if
+// that fails, e.g. while a renewal has written only one of the files.
+func (c *certificates) reload() error {
+	cert, pool, err := loadCertificates()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.cert, c.clientCAs = cert, pool
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *certificates) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+			}
+			if c.clientCAs != nil {
+				config.ClientCAs = c.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// reloadHTTPCertificates is called on SIGHUP.
+func reloadHTTPCertificates() {
+	if httpCerts == nil {
+		return
+	}
+	if err := httpCerts.reload(); err != nil {
+		slog.Error("Cannot reload the certificates of the HTTP listener, keeping the previous ones", "cert", *httpTLSCert, "error", err)
+		return
+	}
+	slog.Info("Reloaded the certificates of the HTTP listener", "cert", *httpTLSCert)
+}
+
+// requireBearerToken rejects requests without -http-bearer-token, except
+// for /livez, which probes have to reach without credentials.
+func requireBearerToken(next http.Handler) http.Handler {
+	if *httpBearerToken == "" {
+		return next
+	}
+	want := []byte(*httpBearerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if r.URL.Path != "/livez" && (!ok || subtle.ConstantTimeCompare([]byte(token), want) != 1) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
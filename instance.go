@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var instanceName = stringOption("instance-name", "", "Name of this instance in log lines, metrics and the status, to tell several instances on one host apart. Defaults to the cluster scope in -key, e.g. batman for /service/batman/leader, or else -ip.")
+
+// instance returns -instance-name or its default.
+func instance() string {
+	if *instanceName != "" {
+		return *instanceName
+	}
+	// Patroni keeps the leader in <namespace>/<scope>/leader
+	parts := strings.Split(strings.Trim(*key, "/"), "/")
+	if len(parts) >= 2 && parts[len(parts)-1] == "leader" && parts[len(parts)-2] != "" {
+		return parts[len(parts)-2]
+	}
+	if vip, _, err := parseVIP(*ip); err == nil {
+		return vip.String()
+	}
+	return *ip
+}
+
+// isDirPath reports whether path is a directory, or is meant to be one by
+// its trailing slash.
+func isDirPath(path string) bool {
+	info, err := os.Stat(path)
+	return (err == nil && info.IsDir()) || strings.HasSuffix(path, "/")
+}
+
+// instanceFile returns path, or a file named after the instance name with
+// the extension ext if path is a directory.
+func instanceFile(path, name, ext string) string {
+	if isDirPath(path) {
+		return filepath.Join(path, "vip-manager-"+strings.Replace(name, "/", "_", -1)+ext)
+	}
+	return path
+}
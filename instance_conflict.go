@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var force = boolOption("force", false, "Start even when another vip-manager seems to manage the same virtual IP, e.g. according to -pid-file or -control-socket. Two instances add and remove the address out of phase, only use it when the other one is known to be gone.")
+
+// runningError is another vip-manager that is still running.
+type runningError struct {
+	// 0 if it is not known
+	pid int
+	// Where it was found, e.g. the pid file
+	source string
+}
+
+func (e *runningError) Error() string {
+	if e.pid == 0 {
+		return fmt.Sprintf("another vip-manager is running according to %s", e.source)
+	}
+	return fmt.Sprintf("another vip-manager is running with pid %d according to %s", e.pid, e.source)
+}
+
+// refuseIfRunning exits if err is another running instance, unless -force
+// is given. It returns whether it was one.
+func refuseIfRunning(err error, args ...any) bool {
+	var running *runningError
+	if !errors.As(err, &running) {
+		return false
+	}
+	args = append(args, "pid", running.pid, "source", running.source)
+	if !*force {
+		fatalWithCode(exitAlreadyRunning, "Another vip-manager manages the same virtual IP, refusing to start, see -force", args...)
+	}
+	slog.Warn("Another vip-manager manages the same virtual IP, starting anyway because of -force", args...)
+	return true
+}
+
+// checkOtherInstances exits if another running vip-manager manages one of
+// the virtual IPs of manager, unless -force is given. The pid file is
+// checked when it is written.
+func checkOtherInstances(manager *ipmanager.IPManager) {
+	var own []string
+	for _, a := range manager.Status(*healthDCSThreshold).Addresses {
+		own = append(own, a.Address)
+	}
+	if *controlSocket != "" {
+		path := controlSocketPath(*controlSocket)
+		if err := checkControlSocketOwner(path, own); err != nil {
+			refuseIfRunning(err, "vip", strings.Join(own, ", "))
+		}
+	}
+	marked, err := manager.MarkedAddresses()
+	if err != nil || len(marked) == 0 {
+		return
+	}
+	// Usually left behind by ourselves, e.g. with -retain-on-exit, so only
+	// a process that names the address or our config file counts
+	if err := findProcessFor(marked); err != nil {
+		refuseIfRunning(err, "vip", strings.Join(marked, ", "))
+	}
+}
+
+// checkControlSocketOwner asks the instance listening on the control socket
+// at path for its virtual IPs.
+func checkControlSocketOwner(path string, own []string) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		// Nobody listening
+		return nil
+	}
+	defer conn.Close()
+	pid := peerPID(conn.(*net.UnixConn))
+	conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+	var resp struct {
+		controlResponse
+		Result statusResponse `json:"result"`
+	}
+	if err := json.NewEncoder(conn).Encode(controlRequest{Command: "status"}); err != nil {
+		return nil
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil || !resp.OK {
+		// Listening, but no status, the socket cannot be shared anyway
+		return &runningError{pid: pid, source: path}
+	}
+	if resp.Result.Pid != 0 {
+		pid = resp.Result.Pid
+	}
+	for _, a := range resp.Result.Addresses {
+		for _, o := range own {
+			if a.Address == o {
+				return &runningError{pid: pid, source: path}
+			}
+		}
+	}
+	return nil
+}
+
+// findProcessFor looks for another vip-manager process whose command line
+// names one of addresses or our config file.
+func findProcessFor(addresses []string) error {
+	ips := make(map[string]bool)
+	for _, a := range addresses {
+		ip, _, _ := strings.Cut(a, "/")
+		ips[ip] = true
+	}
+	config := ""
+	if configPath != "" {
+		config, _ = filepath.Abs(configPath)
+	}
+	for _, p := range otherProcesses() {
+		for _, arg := range p.args {
+			if config != "" && (arg == config || strings.HasSuffix(arg, "="+config)) {
+				return &runningError{pid: p.pid, source: "its command line, it uses " + config}
+			}
+			// E.g. -ip=10.1.2.3 or -vip 10.1.2.4/24,iface=eth1
+			for _, field := range strings.FieldsFunc(arg, func(r rune) bool { return r == '=' || r == ',' }) {
+				ip, _, _ := strings.Cut(field, "/")
+				if ips[ip] {
+					return &runningError{pid: p.pid, source: "its command line, it names " + ip}
+				}
+			}
+		}
+	}
+	return nil
+}
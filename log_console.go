@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logColor = stringOption("log-color", "auto", "Color the level of log messages in the console format: auto colors when stderr is a terminal, always or never", oneOfIgnoreCase("auto", "always", "never"))
+
+// ANSI colors of the levels
+var levelColors = map[slog.Level]string{
+	slog.LevelDebug: "\x1b[90m",
+	slog.LevelInfo:  "\x1b[32m",
+	slog.LevelWarn:  "\x1b[33m",
+	slog.LevelError: "\x1b[31m",
+}
+
+const colorReset = "\x1b[0m"
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor reports whether console output to w gets colors.
+func useColor(w io.Writer) bool {
+	switch strings.ToLower(*logColor) {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// consoleHandler writes one line per record for people reading along, e.g.
+// "15:04:05.000 WARN  Cannot query the virtual IP vip=10.1.2.3/24 error=...".
+// Values with spaces, quotes or line breaks are quoted, so that multi-line
+// command output stays on one line.
+type consoleHandler struct {
+	w     io.Writer
+	lock  *sync.Mutex
+	opts  slog.HandlerOptions
+	color bool
+	// Rendered attributes of WithAttrs, and the prefix of WithGroup
+	attrs  string
+	prefix string
+}
+
+func newConsoleHandler(w io.Writer, opts *slog.HandlerOptions) *consoleHandler {
+	return &consoleHandler{w: w, lock: new(sync.Mutex), opts: *opts, color: useColor(w)}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return level >= min
+}
+
+func consoleValue(v slog.Value) string {
+	s := v.Resolve().String()
+	if v.Kind() == slog.KindTime {
+		s = v.Time().Format(time.RFC3339)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// appendAttr renders a, flattening groups into dotted keys.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	b.WriteString(" " + prefix + a.Key + "=" + consoleValue(a.Value))
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	// Only the time is passed to ReplaceAttr, targets that add their own
+	// drop it there
+	timeAttr := slog.Time(slog.TimeKey, r.Time)
+	if h.opts.ReplaceAttr != nil {
+		timeAttr = h.opts.ReplaceAttr(nil, timeAttr)
+	}
+	if !r.Time.IsZero() && timeAttr.Key != "" {
+		b.WriteString(r.Time.Format("15:04:05.000 "))
+	}
+	level := fmt.Sprintf("%-5s", levelName(r.Level))
+	if h.color {
+		color, ok := levelColors[r.Level]
+		if !ok && r.Level < slog.LevelInfo {
+			color = levelColors[slog.LevelDebug]
+		} else if !ok {
+			color = levelColors[slog.LevelError]
+		}
+		level = color + level + colorReset
+	}
+	b.WriteString(level + " " + r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	c.attrs += b.String()
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.prefix += name + "."
+	return &c
+}
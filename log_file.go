@@ -0,0 +1,159 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var logFilePath = stringOption("log-file", "", "File to append log messages to, in addition to -log-target. It is rotated by size, and reopened on SIGHUP for an external logrotate. Empty disables it.")
+var logFileMaxSize = intOption("log-file-max-size", 100, "Size in megabytes at which the log file is rotated")
+var logFileMaxBackups = intOption("log-file-max-backups", 5, "Number of rotated log files to keep")
+var logFileCompress = boolOption("log-file-compress", false, "Compress rotated log files with gzip")
+
+// The -log-file writer, reopened on SIGHUP
+var logFile *rotatingFile
+
+// rotatingFile appends to a file that is rotated by size, keeping a number
+// of old files as path.1 etc. Every Write goes into one file, slog writes a
+// record with a single Write, so no line is split or lost by a rotation.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	compress   bool
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	// Closed when the compression of the previous rotated file finished
+	compressed chan struct{}
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, compress: compress}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if f.compressed != nil {
+		// Renaming the file while it is being compressed would lose it
+		<-f.compressed
+		f.compressed = nil
+	}
+	f.file.Close()
+	f.file = nil
+	if f.maxBackups == 0 {
+		os.Remove(f.path)
+		return f.open()
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		old, next := fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)
+		os.Rename(old, next)
+		os.Rename(old+".gz", next+".gz")
+	}
+	os.Rename(f.path, f.path+".1")
+	if f.compress {
+		done := make(chan struct{})
+		f.compressed = done
+		go func() {
+			err := compressFile(f.path + ".1")
+			// Before logging, a rotation may be waiting with the lock held
+			close(done)
+			if err != nil {
+				slog.Warn("Cannot compress rotated log file", "log_file", f.path+".1", "error", err)
+			}
+		}()
+	}
+	return f.open()
+}
+
+// compressFile replaces name with name.gz.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz.tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(name+".gz.tmp", name+".gz")
+	}
+	if err != nil {
+		os.Remove(name + ".gz.tmp")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		// Opening failed before, try again
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens it again, after it was moved away by an
+// external logrotate.
+func (f *rotatingFile) Reopen() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// reopenLogFile reopens -log-file, if any.
+func reopenLogFile() {
+	if logFile == nil {
+		return
+	}
+	if err := logFile.Reopen(); err != nil {
+		slog.Error("Cannot reopen log file", "log_file", logFile.path, "error", err)
+	}
+}
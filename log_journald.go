@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalConnected reports whether stderr is connected to the journal, in
+// which case systemd sets JOURNAL_STREAM to its device and inode.
+func journalConnected() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+// journalHandler sends records with the native journal protocol, so that
+// they have the right priority, multi-line messages stay one entry and the
+// attributes become journal fields, e.g. VIP and IFACE.
+type journalHandler struct {
+	conn  *net.UnixConn
+	level slog.Leveler
+	// Sent with every entry, but not part of the message
+	fields map[string]string
+	// Keys already include the prefix of the groups
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newJournalHandler(level slog.Leveler, fields map[string]string) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, level: level, fields: fields}, nil
+}
+
+// journalFieldName turns an attribute key into a valid field name: upper
+// case letters, digits and underscores, not starting with an underscore.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
+
+func journalPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// writeJournalField appends a field in the journal export format, values
+// with newlines are sent with an explicit length.
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func (h *journalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	msg := []string{r.Message}
+	addAttr := func(key string, v slog.Value) {
+		value := v.Resolve().String()
+		if strings.ContainsAny(value, " \"\n") {
+			msg = append(msg, key+"="+strconv.Quote(value))
+		} else {
+			msg = append(msg, key+"="+value)
+		}
+		if name := journalFieldName(key); name != "" {
+			writeJournalField(&buf, name, value)
+		}
+	}
+	for _, a := range h.attrs {
+		addAttr(a.Key, a.Value)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != "" {
+			addAttr(h.prefix+a.Key, a.Value)
+		}
+		return true
+	})
+
+	writeJournalField(&buf, "MESSAGE", strings.Join(msg, " "))
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(journalPriority(r.Level)))
+	for name, value := range h.fields {
+		writeJournalField(&buf, name, value)
+	}
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if a.Key != "" {
+			c.attrs = append(c.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+		}
+	}
+	return &c
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.prefix = h.prefix + name + "_"
+	return &c
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// Identical warnings and errors logged within the window before they
+	// are suppressed
+	logRepeatBurst  = 3
+	logRepeatWindow = 5 * time.Minute
+)
+
+// repeatedRecord tracks the warnings and errors with one message in the
+// current window.
+type repeatedRecord struct {
+	// Message and attributes of the last record
+	text        string
+	level       slog.Level
+	windowStart time.Time
+	count       int
+	suppressed  int
+	// Handler to send the summary to
+	handler slog.Handler
+	timer   *time.Timer
+}
+
+type repeatLimiter struct {
+	lock    sync.Mutex
+	records map[string]*repeatedRecord
+}
+
+// repeatLimitHandler passes on warnings and errors until the same message
+// with the same attributes was logged logRepeatBurst times within
+// logRepeatWindow. Further repeats are counted and summarized at the end of
+// the window. A record with other attributes, e.g. a new error text, ends
+// the window early and is passed on right away.
+type repeatLimitHandler struct {
+	inner   slog.Handler
+	limiter *repeatLimiter
+	// Attributes and groups of WithAttrs and WithGroup, part of the key
+	context string
+}
+
+func newRepeatLimitHandler(inner slog.Handler) *repeatLimitHandler {
+	return &repeatLimitHandler{inner: inner, limiter: &repeatLimiter{records: make(map[string]*repeatedRecord)}}
+}
+
+func (h *repeatLimitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *repeatLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.inner.Handle(ctx, r)
+	}
+	key := h.context + r.Message
+	text := key
+	r.Attrs(func(a slog.Attr) bool {
+		text += fmt.Sprintf(" %s=%v", a.Key, a.Value.Resolve())
+		return true
+	})
+
+	l := h.limiter
+	l.lock.Lock()
+	rec := l.records[key]
+	if rec != nil && rec.text == text {
+		rec.count++
+		if rec.count > logRepeatBurst {
+			rec.suppressed++
+			l.lock.Unlock()
+			return nil
+		}
+		l.lock.Unlock()
+		return h.inner.Handle(ctx, r)
+	}
+	if rec != nil {
+		rec.timer.Stop()
+		l.summarize(rec)
+	}
+	rec = &repeatedRecord{text: text, level: r.Level, windowStart: time.Now(), count: 1, handler: h.inner}
+	rec.timer = time.AfterFunc(logRepeatWindow, func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.records[key] == rec {
+			delete(l.records, key)
+			l.summarize(rec)
+		}
+	})
+	l.records[key] = rec
+	l.lock.Unlock()
+	return h.inner.Handle(ctx, r)
+}
+
+// summarize logs how often rec was suppressed, if at all. Has to be called
+// with the lock held, so the summary cannot overtake the next record.
+func (l *repeatLimiter) summarize(rec *repeatedRecord) {
+	if rec.suppressed == 0 {
+		return
+	}
+	since := time.Since(rec.windowStart).Round(time.Second)
+	summary := slog.NewRecord(time.Now(), rec.level, fmt.Sprintf("Previous message repeated %d times in the last %s", rec.suppressed, since), 0)
+	summary.AddAttrs(slog.String("message", rec.text))
+	rec.handler.Handle(context.Background(), summary)
+}
+
+func (h *repeatLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithAttrs(attrs)
+	for _, a := range attrs {
+		c.context += fmt.Sprintf("%s=%v ", a.Key, a.Value.Resolve())
+	}
+	return &c
+}
+
+func (h *repeatLimitHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithGroup(name)
+	c.context += name + "."
+	return &c
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+	"authpriv": syslog.LOG_AUTHPRIV,
+}
+
+func parseSyslogFacility(name string) (syslog.Priority, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// syslogOutput formats a record with the handler of the chosen log format
+// into buf and sends it with the severity matching its level.
+type syslogOutput struct {
+	w    *syslog.Writer
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+type syslogHandler struct {
+	out   *syslogOutput
+	inner slog.Handler
+}
+
+func newSyslogHandler(facility syslog.Priority, tag string, newHandler func(*bytes.Buffer) slog.Handler) (slog.Handler, error) {
+	w, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	out := &syslogOutput{w: w}
+	return &syslogHandler{out: out, inner: newHandler(&out.buf)}, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.lock.Lock()
+	defer h.out.lock.Unlock()
+	h.out.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(h.out.buf.String(), "\n")
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.out.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.out.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.out.w.Info(msg)
+	default:
+		return h.out.w.Debug(msg)
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{out: h.out, inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{out: h.out, inner: h.inner.WithGroup(name)}
+}
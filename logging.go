@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/steadylog"
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+)
+
+var logLevelName = stringOption("log-level", "info", "Minimum level of log messages: trace, debug, info, warn or error", reloadable(false))
+var trace = boolOption("trace", false, "Log at trace level: every command with its output, every response of the DCS and every decision about the virtual IP, with secrets redacted")
+var logFormat = stringOption("log-format", "text", "Format of log messages: text, json, or console for people reading along in a terminal", oneOfIgnoreCase("text", "json", "console"))
+var logTarget = stringOption("log-target", "auto", "Where to send log messages: stderr, syslog, journald, or several of them separated by commas. auto is journald when stderr is connected to the journal, stderr otherwise.")
+var syslogFacility = stringOption("syslog-facility", "daemon", "Facility of messages sent to syslog, e.g. daemon or local0")
+var syslogTag = stringOption("syslog-tag", "vip-manager", "Tag of messages sent to syslog or the journal")
+var statusLogInterval = durationOption("status-log-interval", 5*time.Minute, "How often to repeat the status of the virtual IP in the log while it does not change. 0 logs it at every check.", notNegative())
+var dcsSummaryInterval = durationOption("dcs-summary-interval", 0, "How often to log a summary of the leader key while it does not change, with the number of reads and errors since it last changed, e.g. 1h. Changes are always logged. 0 disables the summary.", notNegative())
+
+// logLevel can be changed while running, e.g. on reload
+var logLevel = new(slog.LevelVar)
+
+func parseLogLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "trace") {
+		return tracelog.Level, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// levelName is the name of level in the log, slog would call trace DEBUG-4.
+func levelName(level slog.Level) string {
+	if level == tracelog.Level {
+		return "TRACE"
+	}
+	return level.String()
+}
+
+func renameLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.LevelKey {
+		if level, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.StringValue(levelName(level))
+		}
+	}
+	return a
+}
+
+// setLogLevel applies -log-level, -debug lowers it to debug and -trace to
+// trace.
+func setLogLevel() {
+	level, err := parseLogLevel(*logLevelName)
+	if err != nil {
+		// Checked by validateConfig
+		level = slog.LevelInfo
+	}
+	if *debug && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	if *trace {
+		level = tracelog.Level
+	}
+	logLevel.Set(level)
+}
+
+func logTargets() []string {
+	var targets []string
+	for _, target := range strings.Split(*logTarget, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, strings.ToLower(target))
+		}
+	}
+	return targets
+}
+
+// setupLogging replaces the default logger, messages of the log package,
+// e.g. from libraries, end up there as well. Targets that cannot be set up
+// are replaced by stderr.
+func setupLogging() {
+	setLogLevel()
+	steadylog.SetHeartbeat(*statusLogInterval)
+	checker.SetSummaryInterval(*dcsSummaryInterval)
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: renameLevel}
+	newHandler := func(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+		switch strings.ToLower(*logFormat) {
+		case "json":
+			return slog.NewJSONHandler(w, opts)
+		case "console":
+			return newConsoleHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	var handlers multiHandler
+	var failures []string
+	useStderr := false
+	for _, target := range logTargets() {
+		switch target {
+		case "stderr":
+			useStderr = true
+		case "auto":
+			if !journalConnected() {
+				useStderr = true
+				continue
+			}
+			fallthrough
+		case "journald":
+			h, err := newJournalHandler(logLevel, map[string]string{
+				"SYSLOG_IDENTIFIER": *syslogTag,
+				"DCS_TYPE":          *endpointType,
+			})
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("journald: %s", err))
+				useStderr = true
+				continue
+			}
+			handlers = append(handlers, h)
+		case "syslog":
+			// Validated by validateConfig
+			facility, _ := parseSyslogFacility(*syslogFacility)
+			h, err := newSyslogHandler(facility, *syslogTag, func(buf *bytes.Buffer) slog.Handler {
+				return newHandler(buf, &slog.HandlerOptions{Level: logLevel, ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					return renameLevel(groups, dropTime(groups, a))
+				}})
+			})
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("syslog: %s", err))
+				useStderr = true
+				continue
+			}
+			handlers = append(handlers, h)
+		}
+	}
+	if *logFilePath != "" {
+		f, err := newRotatingFile(*logFilePath, int64(*logFileMaxSize)<<20, *logFileMaxBackups, *logFileCompress)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", *logFilePath, err))
+			useStderr = true
+		} else {
+			logFile = f
+			handlers = append(handlers, newHandler(f, opts))
+		}
+	}
+	if useStderr || len(handlers) == 0 {
+		handlers = append(handlers, newHandler(os.Stderr, opts))
+	}
+	var handler slog.Handler = handlers
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	}
+	slog.SetDefault(slog.New(newRepeatLimitHandler(handler)).With("instance", instance()))
+
+	for _, failure := range failures {
+		slog.Warn("Cannot log to target, logging to stderr instead", "error", failure)
+	}
+}
+
+// dropTime removes the timestamp for targets that add their own
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// multiHandler sends every record to all of its handlers
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// fatal logs msg as error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	fatalWithCode(exitFailure, msg, args...)
+}
+
+// fatalWithCode logs msg as error and exits with one of the documented exit
+// codes.
+func fatalWithCode(code int, msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(code)
+}
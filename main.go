@@ -3,100 +3,414 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
-	"net"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
 	//"github.com/milosgajdos83/tenus"
 )
 
-var ip = flag.String("ip", "none", "Virtual IP address to configure")
-var mask = flag.Int("mask", -1, "The netmask used for the IP address. Defaults to -1 which assigns ipv4 default mask.")
-var iface = flag.String("iface", "none", "Network interface to configure on")
-var key = flag.String("key", "none", "key to monitor, e.g. /service/batman/leader")
-var host = flag.String("host", "none", "Value to monitor for")
-var endpointType = flag.String("type", "etcd", "type of endpoint used for key storage. Supported values: etcd, consul")
-var endpoint = flag.String("endpoint", "http://localhost:2379", "endpoint")
+var ip = stringOption("ip", "none", "Virtual IP address to configure, optionally with its prefix length, e.g. 10.1.2.3/24")
+var mask = netmaskFlag("mask", -1, "The netmask used for the IP address, as prefix length, e.g. 24, or in dotted-quad form, e.g. 255.255.255.0. Defaults to -1 which takes the prefix length from -ip, or else assigns /32.")
+var ip6 = stringOption("ip6", "", "IPv6 address to manage together with the IPv4 address given in -ip, optionally with its prefix length, e.g. fd00::10/64")
+var mask6 = intOption("mask6", -1, "The prefix length used for the IPv6 address. Defaults to -1 which takes the prefix length from -ip6, or else assigns /128.")
+var vipAddressWarnOnly = boolOption("vip-address-warn-only", false, "Only warn instead of refusing to start when a virtual IP is the network or broadcast address of its prefix")
+var iface = stringOption("iface", "none", "Network interface to configure on")
+var key = stringOption("key", "none", "key to monitor, e.g. /service/batman/leader", reloadable(true))
+var host = stringOption("host", "", "Value to monitor for, the name of this node as used by Patroni. Defaults to the hostname of this machine, see -host-short-name.", reloadable(true))
+var endpointType = stringOption("dcs-type", "etcd", "type of endpoint used for key storage. Supported values: etcd, consul", deprecatedAs("type"), oneOf("etcd", "consul"), reloadable(true))
+var endpoint = stringOption("dcs-endpoint", "http://localhost:2379", "endpoint", deprecatedAs("endpoint"), reloadable(true))
+var firewall = stringOption("firewall", "none", "Firewall rules to toggle together with the virtual IP. Supported values: none, nft. The nft rules live in the table vip_manager_<instance>, named after -instance-name.", oneOf("none", "", "nft"))
+var firewallRules = stringOption("firewall-rules", "", "File with nft rules for an input chain while holding the virtual IP, templated with {{.VIP}}, {{.Mask}}, {{.CIDR}}, {{.Iface}} and {{.Family}}. None by default.")
+var firewallStandbyRules = stringOption("firewall-standby-rules", "", "File with nft rules for a prerouting chain while not holding the virtual IP, templated like -firewall-rules. Defaults to rejecting connections to port 5432 on the virtual IP once it is not local, so clients with a stale ARP entry fail right away.")
+var prefix = stringOption("command-prefix", "", "Prefix for commands that need network privileges, e.g. \"sudo -n\" to run as an unprivileged user")
+var dryRun = boolOption("dry-run", false, "Only log the changes that would be made to the system")
+var httpListen = stringOption("http-listen", "", "Address to serve /metrics, /healthz and /status on, e.g. localhost:9090. Empty disables the HTTP server.")
+var debug = boolOption("debug", false, "Log at debug level, including every command that is run", reloadable(false))
+var macvlanName = stringOption("macvlan", "", "Name of a macvlan interface to create on iface for the virtual IP while holding it, so the MAC address moves together with the address")
+var macvlanMAC = stringOption("macvlan-mac", "", "MAC address of the macvlan interface")
+var foreignAddresses = stringOption("foreign-addresses", "adopt", "What to do with copies of the virtual IP that were not added by vip-manager. Supported values: adopt, ignore, remove", oneOf(string(ipmanager.ForeignAdopt), string(ipmanager.ForeignIgnore), string(ipmanager.ForeignRemove)))
+var forceReleaseOnExit = boolOption("force-release-on-exit", false, "Remove the virtual IP on exit even if it was not added by vip-manager, i.e. is not labeled as ours")
+var retainOnExit = boolOption("retain-vip-on-exit", false, "Keep the virtual IP configured on exit while this node is leader, so that restarting vip-manager does not interrupt connections")
+var primaryCheckDSN = stringOption("primary-check-dsn", "", "Connection string of the local PostgreSQL that has to be a primary before the virtual IP is configured. Empty disables the check.")
+var primaryCheckQuery = stringOption("primary-check-query", ipmanager.DefaultPrimaryCheckQuery, "Query that must return true before the virtual IP is configured")
+var connectivityTarget = stringOption("connectivity-check-target", "", "Host to ping, or host:port to connect to, from the virtual IP after it was configured, e.g. the default gateway. Failures are only logged. Empty disables the check.")
+var connectivityTimeout = durationOption("connectivity-check-timeout", 2*time.Second, "Timeout of the connectivity check")
+var connectivityInterval = durationOption("connectivity-check-interval", time.Minute, "How often to repeat the connectivity check while holding the virtual IP")
+var arpSysctls = boolOption("arp-sysctls", false, "Set arp_announce and arp_ignore on the interface while holding the virtual IP and restore the previous values on release")
+var arpAnnounce = intOption("arp-announce", 2, "Value of arp_announce to set with -arp-sysctls")
+var arpIgnore = intOption("arp-ignore", 1, "Value of arp_ignore to set with -arp-sysctls")
+var ignoreCarrier = boolOption("ignore-carrier", false, "Configure the virtual IP even if the interface has no carrier, e.g. for dummy devices")
+var shutdownGracePeriod = durationOption("shutdown-grace-period", 10*time.Second, "How long the shutdown may take, changes to the addresses still running then are aborted and vip-manager exits with code 3")
+var carp = boolOption("carp", false, "Let CARP move the virtual IP: iface is a carp interface, whose advskew is lowered while this node is leader")
+var carpAdvskew = intOption("carp-advskew", 0, "advskew of the carp interface while leader")
+var carpStandbyAdvskew = intOption("carp-standby-advskew", 100, "advskew of the carp interface while not leader")
+var proxyArp = boolOption("proxy-arp", false, "Answer ARP for the virtual IP with a proxy neighbor entry on iface instead of adding the address, for traffic that is routed on from this host")
+var proxyURL = stringOption("proxy-url", "", "Proxy for the connections to the DCS, overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY", reloadable(true))
+var maxConfirmAge = durationOption("max-confirm-age", 30*time.Second, "Remove the virtual IP when the DCS did not confirm this node as leader for this long, e.g. because it cannot be reached, so that two nodes never hold it for longer. Should not exceed the ttl of Patroni. It is only added again after a successful read. 0 keeps the virtual IP.")
+var releaseGracePeriod = durationOption("release-grace-period", 0, "Keep the virtual IP for this long after losing leadership, so connections can be drained. The release_pending event is sent right away, e.g. to -on-state-change. The release is cancelled with a release_cancelled event if leadership returns in the meantime.")
+var releaseGracePlannedOnly = boolOption("release-grace-planned-only", false, "Only keep the virtual IP for -release-grace-period in a switchover. It is released right away when the leader key names no node, or when the leadership was not confirmed within -health-dcs-threshold before another node took over, e.g. after an outage of the DCS.")
+var delayBeforeAcquire = durationOption("delay-before-acquire", 0, "Wait this long after becoming leader before configuring the virtual IP")
+var delayBeforeRelease = durationOption("delay-before-release", 0, "Wait this long after losing leadership before removing the virtual IP")
+var startupJitter = durationOption("startup-jitter", 0, "Wait a random time up to this long before configuring the virtual IP for the first time, so that many instances starting together do not act at the same instant")
+var startupHoldOff = durationOption("startup-hold-off", 30*time.Second, "When the virtual IP is already configured at startup, e.g. after a restart on the leader, keep it for up to this long until the leader checker reports the first state. 0 removes it right away.", notNegative())
+var stateFile = stringOption("state-file", "", "Keep the last state reported by the leader checker in this file. After a restart, a state at most -state-file-max-age old is used until the leader checker reports, instead of -startup-hold-off.")
+var stateFileMaxAge = durationOption("state-file-max-age", time.Minute, "Ignore a state in -state-file older than this")
+var interfaceWaitTimeout = durationOption("interface-wait-timeout", 0, "At startup, wait up to this long for -iface and the interfaces of -vips to appear, e.g. when they are created by a container runtime or hotplugged. 0 does not wait unless -interface-wait-policy is wait.", notNegative())
+var interfaceWaitPolicy = stringOption("interface-wait-policy", "exit", "What to do when an interface is still missing after -interface-wait-timeout: exit, or wait for it indefinitely", oneOf("exit", "wait"))
+var reassertInterval = durationOption("reassert-interval", 0, "While holding the virtual IP, announce it again this often even if nothing changed, for neighbours and middleboxes that forget about it after a long idle time. 0 only announces it when it is added.", notNegative())
+var divergenceThreshold = durationOption("divergence-threshold", time.Minute, "Warn and count an alert in vip_manager_state_divergence_alerts_total when the virtual IP is not in the desired state for this long. The configured delays, grace periods and maintenance mode do not count. 0 only measures it in vip_manager_state_divergence_seconds.", notNegative())
+var arpProbe = boolOption("arp-probe", false, "Send an ARP probe before taking over the virtual IP and wait while another host still answers for it. A POST to /force-takeover on the HTTP listener skips the probe once.")
+var arpProbeTimeout = durationOption("arp-probe-timeout", time.Second, "How long to wait for answers to the ARP probe")
+var arpProbeRetryInterval = durationOption("arp-probe-retry-interval", 5*time.Second, "How often to probe again while another host answers for the virtual IP")
+var duplicateScanInterval = durationOption("duplicate-scan-interval", 5*time.Minute, "While holding the virtual IP, probe this often whether another host answers ARP for it as well, waiting -arp-probe-timeout for answers. 0 disables the scan.", notNegative())
+var onDuplicateAddress = stringOption("on-duplicate-address", "", "Executable to run when the scan finds another host answering for the virtual IP, with VIP_ADDRESS, VIP_IFACE and VIP_MAC in the environment")
+var showVersion = boolOption("version", false, "Print the version and build information and exit")
+var clockSkewThreshold = durationOption("clock-skew-threshold", 5*time.Second, "Warn when the clock of this node differs from the clock of the DCS by more than this, as seen in the Date header of its responses. Only logged and exported as metric, the virtual IP is not affected. 0 disables the warning.", notNegative())
+var healthDCSThreshold = durationOption("health-dcs-threshold", 30*time.Second, "/healthz and /readyz report a problem when the DCS was not read successfully for this long", positive("30s"))
+var pidFile = stringOption("pid-file", "", "Write the process id to this file and refuse to start while it names another running process. For a directory, the file is named after -instance-name, e.g. vip-manager-batman.pid.")
+var auditLogPath = stringOption("audit-log", "", "File to append a JSON line to for every transition and announcement of the virtual IP. Empty disables the audit log.")
+var auditLogMaxSize = intOption("audit-log-max-size", 10, "Size in megabytes at which the audit log is rotated")
+var auditLogRetention = intOption("audit-log-retention", 5, "Number of rotated audit log files to keep")
+var statsdAddress = stringOption("statsd-address", "", "host:port of a statsd server to push the metrics to over UDP. Empty disables pushing.")
+var statsdPrefix = stringOption("statsd-prefix", "", "Prefix of the metric names sent to statsd, e.g. \"db.\"")
+var statsdTags = stringOption("statsd-tags", "", "Tags sent with every metric in the DogStatsD format, as a comma separated list of name:value, e.g. cluster:main,node:db1")
+var statsdInterval = durationOption("statsd-interval", 10*time.Second, "How often to push the metrics to statsd")
+var once = boolOption("once", false, "Read the leader key once, add or remove the virtual IP accordingly, print what was done and exit")
+var checkerMaxRestarts = intOption("checker-max-restarts", 0, "Release the virtual IP and exit after restarting a leader checker that keeps stopping on its own this many times in a row. 0 restarts it forever.", notNegative())
+var dcsStartupTimeout = durationOption("dcs-startup-timeout", 0, "Exit with code 69 if the leader key cannot be read for this long after starting, so that the supervisor restarts vip-manager with a backoff. 0 waits forever.", notNegative())
 
-func checkFlag(f *string, name string) {
-	if *f == "none" || *f == "" {
-		log.Fatalf("Setting %s is mandatory", name)
-	}
+// Exit codes, so that supervisors can tell whether a restart may help. They
+// are listed in the -help output. 2 is left out, Go uses it for panics.
+const (
+	exitFailure = 1
+	// The shutdown did not finish within -shutdown-grace-period
+	exitShutdownTimeout = 3
+	// The DCS did not answer within -dcs-startup-timeout, or with -once
+	exitDCSUnreachable = 69
+	// A panic, see crash
+	exitInternalError = 70
+	// Another instance manages the same virtual IP, see -force
+	exitAlreadyRunning = 73
+	// Missing capabilities or a failing -command-prefix
+	exitPrivileges = 77
+	// Invalid flags, config file or environment
+	exitConfigError = 78
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, `
+Exit codes:
+  0   clean shutdown
+  1   other errors, e.g. the leader checker failed or -once did not reach the desired state
+  3   the shutdown did not finish within -shutdown-grace-period
+  69  the DCS did not answer within -dcs-startup-timeout, or when reading the key with -once
+  70  internal error, the virtual IP was released unless this node was the leader
+  73  another vip-manager manages the same virtual IP, see -force
+  77  insufficient privileges, restarting does not help
+  78  invalid configuration, restarting does not help
+
+Run "%s validate-config -help" to check a configuration without starting,
+"%s status -help" to show the status of the running instance,
+"%s convert-config -help" to turn flags into a config file and
+"%s simulate -help" to rehearse a failover on this host.
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 }
 
-func getMask(vip net.IP, mask *int) net.IPMask {
-	if *mask > 0 || *mask < 33 {
-		return net.CIDRMask(*mask, 32)
-	}
-	return vip.DefaultMask()
+var vips vipList
+
+func init() {
+	varOption(&vips, "vip", "Additional virtual IP as address[/prefix][,iface=name][,label=label][,announce=false], may be given more than once. Settings default to the ones of ip.", repeatable())
 }
 
-func getNetIface(iface *string) *net.Interface {
-	netIface, err := net.InterfaceByName(*iface)
+func newLeaderChecker() (checker.LeaderChecker, error) {
+	transport, err := checker.NewTransport(*proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	name, err := nodeName()
 	if err != nil {
-		log.Fatalf("Obtaining the interface raised an error: %s", err)
+		return nil, err
+	}
+	checker.SetClockSkewThreshold(*clockSkewThreshold)
+	checker.SetStaleThreshold(*healthDCSThreshold)
+	if *dcsQuorum > 0 {
+		return newQuorumChecker(name, transport)
 	}
-	return netIface
+	return checker.NewLeaderChecker(*endpointType, *endpoint, *key, name, transport)
 }
 
 func main() {
-	flag.Parse()
-	checkFlag(ip, "IP")
-	checkFlag(iface, "network interface")
-	checkFlag(key, "key")
-	checkFlag(host, "host name")
+	flag.Usage = usage
+	// flag.ExitOnError would exit with 2
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		os.Exit(statusCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "convert-config" {
+		os.Exit(convertConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(simulateCommand(os.Args[2:]))
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		os.Exit(exitConfigError)
+	}
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if err := loadConfig(); err != nil {
+		fatalWithCode(exitConfigError, "Cannot load configuration", "error", err)
+	}
+	if *configDir != "" {
+		if configPath != "" {
+			fatalWithCode(exitConfigError, "Cannot start with -config-dir and -config, the config files are in the directory")
+		}
+		setupLogging()
+		os.Exit(runConfigDir(*configDir))
+	}
+	if err := validateConfig(); err != nil {
+		fatalWithCode(exitConfigError, "Cannot start with an "+err.Error())
+	}
+	setupLogging()
+	metrics.SetConstLabel("instance", instance())
+	if *pidFile != "" {
+		*pidFile = pidFilePath(*pidFile)
+		err := writePidFile(*pidFile)
+		if refuseIfRunning(err, "vip", *ip) {
+			// Taken over because of -force
+			os.Remove(*pidFile)
+			err = writePidFile(*pidFile)
+		}
+		if err != nil {
+			fatal("Cannot write pid file", "pid_file", *pidFile, "error", err)
+		}
+		defer removePidFile(*pidFile)
+		slog.Info("Starting "+versionString(), "pid", os.Getpid(), "pid_file", *pidFile)
+	} else {
+		slog.Info("Starting "+versionString(), "pid", os.Getpid())
+	}
+	logDeprecations()
+	logEffectiveConfig()
+	spanExporter := setupTracing()
+	logNodeName()
+	reportCrashMarker()
+	defer recoverPanic()
+	ipmanager.PanicHandler = crash
+	ipmanager.CommandPrefix = strings.Fields(*prefix)
+	ipmanager.DryRun = *dryRun
+	checkCapabilities()
 
 	states := make(chan bool)
-	lc, err := checker.NewLeaderChecker(*endpointType, *endpoint, *key, *host)
+	lc, err := newLeaderChecker()
 	if err != nil {
-		log.Fatalf("Failed to initialize leader checker: %s", err)
-	}
-
-	vip := net.ParseIP(*ip)
-	vipMask := getMask(vip, mask)
-	netIface := getNetIface(iface)
-	manager, err := NewIPManager(
-		&IPConfiguration{
-			vip:     vip,
-			netmask: vipMask,
-			iface:   *netIface,
-		},
-		states,
-	)
-	if err != nil {
-		log.Fatalf("Problems with generating the virtual ip manager: %s", err)
+		fatalWithCode(exitConfigError, "Failed to initialize leader checker", "type", *endpointType, "endpoint", *endpoint, "error", err)
+	}
+	manager := newManager(states)
+	crashManager = manager
+	checkOtherInstances(manager)
+	managers = ipmanager.NewChain(ipmanager.ChainLink{Name: "address", Manager: manager})
+
+	if *once {
+		code := runOnce(lc, manager)
+		if *pidFile != "" {
+			removePidFile(*pidFile)
+		}
+		os.Exit(code)
+	}
+
+	if *httpListen != "" {
+		srv := startHTTPServer(*httpListen, manager)
+		defer srv.Close()
+	}
+	if *pprofListen != "" {
+		srv := startPprofServer(*pprofListen)
+		defer srv.Close()
 	}
 
 	mainCtx, cancel := context.WithCancel(context.Background())
+	checkerCtx, stopCheckers := context.WithCancel(context.Background())
+	// Closed when the leader checker stopped on its own, with exitCode set
+	checkerFailed := make(chan struct{})
+	exitCode := 0
 
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
+	if *controlSocket != "" {
+		*controlSocket = controlSocketPath(*controlSocket)
+		if err := startControlSocket(mainCtx, *controlSocket, manager); err != nil {
+			fatal("Cannot listen on the control socket", "control_socket", *controlSocket, "error", err)
+		}
+	}
 
-		<-c
+	go func() {
+		defer recoverPanic()
+		c := make(chan os.Signal, 2)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
-		log.Printf("Received exit signal")
+		select {
+		case <-c:
+			slog.Info("Received exit signal, shutting down")
+		case <-checkerFailed:
+			// Without a leader checker the state goes stale, better to
+			// release the address and let the service manager restart us
+			slog.Error("Leader checker stopped, releasing the virtual IP and shutting down", "vip", *ip)
+		}
+		sdNotify("STOPPING=1")
+		// No state changes should arrive while the addresses are removed
+		stopCheckers()
 		cancel()
+
+		// Aborted operations get a moment to return
+		deadline := *shutdownGracePeriod + time.Second
+		select {
+		case <-c:
+			slog.Error("Received another exit signal, exiting without finishing the shutdown")
+		case <-time.After(deadline):
+			slog.Error("Shutdown did not finish in time, exiting", "deadline", deadline)
+		}
+		os.Exit(exitShutdownTimeout)
 	}()
 
+	// The manager may be busy applying a state, the checkers must not wait
+	// for it
+	updates := make(chan bool)
+	go checker.Coalesce(mainCtx, updates, states)
+
 	var wg sync.WaitGroup
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	wg.Add(1)
 	go func() {
-		err := lc.GetChangeNotificationStream(mainCtx, states)
-		if err != nil {
-			log.Fatalf("Leader checker returned the following error: %s", err)
+		defer recoverPanic()
+		defer wg.Done()
+		supervisor := checkerSupervisor{newChecker: newLeaderChecker}
+		ok := supervisor.run(checkerCtx, lc, updates, hup, func() checker.LeaderChecker {
+			reopenLogFile()
+			reloadHTTPCertificates()
+			slog.Info("Received SIGHUP, reloading the config file", "config", configPath)
+			return reloadConfig()
+		})
+		if !ok {
+			exitCode = exitFailure
+			close(checkerFailed)
 		}
-		wg.Done()
 	}()
 
 	wg.Add(1)
 	go func() {
-		manager.SyncStates(mainCtx, states)
+		defer recoverPanic()
+		managers.SyncStates(mainCtx, states)
 		wg.Done()
 	}()
 
+	go notifyReady(mainCtx, manager)
+
+	if *dcsStartupTimeout > 0 {
+		go func() {
+			select {
+			case <-managers.Ready():
+			case <-time.After(*dcsStartupTimeout):
+				fatalWithCode(exitDCSUnreachable, "Cannot read the leader key at startup", "type", *endpointType, "endpoint", *endpoint, "timeout", *dcsStartupTimeout)
+			}
+		}()
+	}
+
+	var dcsNotifiers []ipmanager.Notifier
+	if webhook != nil {
+		go webhook.Run(mainCtx)
+		if *webhookDCSEvents {
+			dcsNotifiers = append(dcsNotifiers, webhook)
+		}
+	}
+	if stateChangeScript != nil {
+		go stateChangeScript.Run(mainCtx)
+		dcsNotifiers = append(dcsNotifiers, stateChangeScript)
+	}
+	if len(dcsNotifiers) > 0 {
+		go watchDCS(mainCtx, *healthDCSThreshold, manager.CIDRs(), dcsNotifiers...)
+	}
+
+	if mailer != nil {
+		go mailer.Run(mainCtx)
+	}
+
+	// These clean up on exit, which has to finish before we return
+	if consulService != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consulService.Run(mainCtx)
+		}()
+	}
+
+	if holderInfo != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			holderInfo.Run(mainCtx, manager)
+		}()
+	}
+
+	if *textfilePath != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewTextfileWriter(*textfilePath, *textfileInterval).Run(mainCtx)
+		}()
+	}
+
+	if spanExporter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spanExporter.Run(mainCtx)
+		}()
+	}
+
+	if *statsdAddress != "" {
+		go NewStatsdSink(*statsdAddress, *statsdPrefix, *statsdTags, *statsdInterval).Run(mainCtx)
+	}
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		defer recoverPanic()
+		for range usr1 {
+			managers.SetMaintenance(!managers.Maintenance())
+		}
+	}()
+
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		defer recoverPanic()
+		for range usr2 {
+			manager.DumpState()
+		}
+	}()
+
 	wg.Wait()
+	if exitCode != 0 {
+		if *pidFile != "" {
+			removePidFile(*pidFile)
+		}
+		os.Exit(exitCode)
+	}
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// getMask returns the mask for a prefix length, a host route if none was
+// given.
+func getMask(vip net.IP, mask int) net.IPMask {
+	m, given := prefixMask(vip, mask)
+	if !given {
+		bits, _ := m.Size()
+		slog.Info(fmt.Sprintf("No prefix length given for the virtual IP, using /%d", bits), "vip", vip)
+	}
+	return m
+}
+
+// prefixMask is getMask without the log message, it reports whether the
+// prefix length was given.
+func prefixMask(vip net.IP, mask int) (net.IPMask, bool) {
+	bits := 8 * net.IPv4len
+	if vip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	if mask > 0 && mask <= bits {
+		return net.CIDRMask(mask, bits), true
+	}
+	return net.CIDRMask(bits, bits), false
+}
+
+func getNetIface(iface *string) *net.Interface {
+	netIface, err := net.InterfaceByName(*iface)
+	if err == nil {
+		return netIface
+	}
+	if *interfaceWaitTimeout <= 0 && *interfaceWaitPolicy != "wait" {
+		fatalWithCode(exitConfigError, "Cannot find interface", "iface", *iface, "error", err)
+	}
+	if *interfaceWaitTimeout > 0 {
+		netIface, err = ipmanager.WaitForInterface(context.Background(), *iface, *interfaceWaitTimeout)
+		if err == nil {
+			return netIface
+		}
+		if *interfaceWaitPolicy != "wait" {
+			fatalWithCode(exitConfigError, "Cannot find interface", "iface", *iface, "error", err)
+		}
+		slog.Error("Interface still does not exist, waiting for it indefinitely", "iface", *iface, "error", err)
+	}
+	netIface, err = ipmanager.WaitForInterface(context.Background(), *iface, 0)
+	if err != nil {
+		fatalWithCode(exitConfigError, "Cannot find interface", "iface", *iface, "error", err)
+	}
+	return netIface
+}
+
+func newIPConfiguration(vip net.IP, netmask net.IPMask, iface net.Interface, label string, announce bool) *ipmanager.IPConfiguration {
+	a, err := ipmanager.NewIPConfiguration(vip, netmask, iface, label, announce)
+	if err != nil {
+		fatalWithCode(exitConfigError, "Invalid virtual IP", "vip", vip, "error", err)
+	}
+	// Only left for us to see with -vip-address-warn-only
+	length, _ := netmask.Size()
+	if problem := hostAddressProblem(vip, length); problem != "" {
+		slog.Warn("Virtual IP is "+problem, "vip", a.GetCIDR())
+	}
+	return a
+}
+
+// All managers that follow the desired state, the address manager first.
+// Status, health and maintenance mode go through it.
+var managers *ipmanager.Chain
+
+// newManager sets up the manager of the virtual IPs from the options.
+func newManager(states <-chan bool) *ipmanager.IPManager {
+	vip, vipLength := vipAddress(*ip, *mask)
+	vipMask := getMask(vip, vipLength)
+	netIface := getNetIface(iface)
+	checkCommand(netIface.Name)
+
+	var macvlan *ipmanager.Macvlan
+	if *macvlanName != "" {
+		var err error
+		macvlan, err = ipmanager.NewMacvlan(netIface.Name, *macvlanName, *macvlanMAC)
+		if err != nil {
+			fatal("Failed to initialize macvlan", "macvlan", *macvlanName, "error", err)
+		}
+		if err := macvlan.CheckOwnership(); err != nil {
+			fatal("Failed to initialize macvlan", "macvlan", *macvlanName, "error", err)
+		}
+		vipIface := macvlan.Interface()
+		netIface = &vipIface
+	}
+
+	addresses := []*ipmanager.IPConfiguration{
+		newIPConfiguration(vip, vipMask, *netIface, "", true),
+	}
+
+	if *ip6 != "" {
+		vip6, vip6Length := vipAddress(*ip6, *mask6)
+		addresses = append(addresses, newIPConfiguration(vip6, getMask(vip6, vip6Length), *netIface, "", true))
+	}
+
+	for _, e := range vips {
+		vipIface := *netIface
+		if e.iface != "" {
+			vipIface = *getNetIface(&e.iface)
+		}
+		addresses = append(addresses, newIPConfiguration(e.vip, getMask(e.vip, e.mask), vipIface, e.label, e.announce))
+	}
+
+	options := ipmanager.ManagerOptions{
+		Macvlan:                 macvlan,
+		ForeignPolicy:           ipmanager.ForeignPolicy(*foreignAddresses),
+		ForceReleaseOnExit:      *forceReleaseOnExit,
+		RetainOnExit:            *retainOnExit,
+		IgnoreCarrier:           *ignoreCarrier,
+		ShutdownGracePeriod:     *shutdownGracePeriod,
+		ReleaseGracePeriod:      *releaseGracePeriod,
+		ReleaseGracePlannedOnly: *releaseGracePlannedOnly,
+		DelayBeforeAcquire:      *delayBeforeAcquire,
+		DelayBeforeRelease:      *delayBeforeRelease,
+		StartupJitter:           *startupJitter,
+		StartupHoldOff:          *startupHoldOff,
+		MaxConfirmAge:           *maxConfirmAge,
+		ReassertInterval:        *reassertInterval,
+		DivergenceThreshold:     *divergenceThreshold,
+	}
+
+	if *stateFile != "" {
+		options.StateFile = ipmanager.NewStateFile(*stateFile, *stateFileMaxAge)
+	}
+
+	var err error
+	if *firewall == "nft" {
+		options.Firewall, err = ipmanager.NewNftFirewall(instance(), *firewallRules, *firewallStandbyRules, addresses)
+		if err != nil {
+			fatal("Failed to initialize firewall rules", "error", err)
+		}
+	}
+
+	if *primaryCheckDSN != "" {
+		options.PrimaryCheck = ipmanager.NewPrimaryCheck(*primaryCheckDSN, *primaryCheckQuery)
+	}
+
+	if *arpSysctls {
+		options.ArpSysctls = ipmanager.NewArpSysctls(addresses, *arpAnnounce, *arpIgnore)
+	}
+
+	if *carp {
+		options.Carp, err = ipmanager.NewCarp(netIface.Name, *carpAdvskew, *carpStandbyAdvskew)
+		if err != nil {
+			fatal("Failed to initialize carp", "iface", netIface.Name, "error", err)
+		}
+	}
+
+	if *proxyArp {
+		options.ProxyArp = ipmanager.NewProxyArp(addresses)
+	}
+
+	if *arpProbe {
+		options.ArpProbe = ipmanager.NewArpProbe(*arpProbeTimeout, *arpProbeRetryInterval)
+	}
+
+	if *duplicateScanInterval > 0 {
+		options.DuplicateScan = ipmanager.NewDuplicateScan(*duplicateScanInterval, *arpProbeTimeout, *onDuplicateAddress)
+	}
+
+	if *connectivityTarget != "" {
+		options.ConnectivityCheck = ipmanager.NewConnectivityCheck(*connectivityTarget, *connectivityTimeout, *connectivityInterval)
+	}
+
+	if *auditLogPath != "" {
+		options.AuditLog, err = ipmanager.NewAuditLog(*auditLogPath, int64(*auditLogMaxSize)<<20, *auditLogRetention)
+		if err != nil {
+			fatal("Cannot open audit log", "audit_log", *auditLogPath, "error", err)
+		}
+	}
+
+	if *webhookURLs != "" {
+		webhook = NewWebhook(*webhookURLs, *webhookTimeout, *webhookRetries)
+		options.Notifiers = append(options.Notifiers, webhook)
+	}
+	if *onStateChange != "" {
+		stateChangeScript = NewStateChangeScript(*onStateChange, *onStateChangeTimeout)
+		options.Notifiers = append(options.Notifiers, stateChangeScript)
+	}
+	if *smtpHost != "" {
+		// The subject template was checked by validateConfig
+		mailer, _ = NewMailer()
+		options.Notifiers = append(options.Notifiers, mailer)
+	}
+
+	if *holderInfoPrefix != "" {
+		holderInfo, err = NewHolderInfo(*holderInfoPrefix, *holderInfoInterval, *holderInfoTTL)
+		if err != nil {
+			fatalWithCode(exitConfigError, "Failed to initialize the holder information", "prefix", *holderInfoPrefix, "error", err)
+		}
+		options.Notifiers = append(options.Notifiers, holderInfo)
+	}
+
+	if *consulServiceName != "" {
+		consulService, err = NewConsulService(consulServiceAgent(), *consulServiceName, *consulServicePort, *consulServiceTags, *consulServiceTTL)
+		if err != nil {
+			fatalWithCode(exitConfigError, "Failed to initialize the Consul service registration", "endpoint", consulServiceAgent(), "error", err)
+		}
+		options.ServiceRegistry = consulService
+	}
+
+	manager, err := ipmanager.NewIPManager(addresses, states, options)
+	if err != nil {
+		fatal("Problems with generating the virtual ip manager", "error", err)
+	}
+	return manager
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parsePrefixLength accepts a prefix length, e.g. 24, or an IPv4 netmask in
+// dotted-quad form, e.g. 255.255.255.0.
+func parsePrefixLength(s string) (int, error) {
+	if !strings.Contains(s, ".") {
+		length, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is neither a prefix length nor a netmask", s)
+		}
+		return length, nil
+	}
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("%q is not a netmask, expected e.g. 255.255.255.0", s)
+	}
+	length, bits := net.IPMask(ip).Size()
+	if bits == 0 {
+		return 0, fmt.Errorf("netmask %s is not contiguous", s)
+	}
+	return length, nil
+}
+
+// netmaskValue is a prefix length that may be given as netmask.
+type netmaskValue int
+
+func (v *netmaskValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *netmaskValue) Set(s string) error {
+	length, err := parsePrefixLength(s)
+	if err != nil {
+		return err
+	}
+	*v = netmaskValue(length)
+	return nil
+}
+
+// netmaskFlag defines a flag like flag.Int that also accepts a netmask.
+func netmaskFlag(name string, value int, usage string, attrs ...optionAttr) *int {
+	p := new(int)
+	*p = value
+	varOption((*netmaskValue)(p), name, usage, attrs...)
+	return p
+}
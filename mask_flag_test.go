@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParsePrefixLength(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "24", want: 24},
+		{value: "64", want: 64},
+		{value: "128", want: 128},
+		{value: "255.255.255.0", want: 24},
+		{value: "255.255.254.0", want: 23},
+		{value: "255.0.0.0", want: 8},
+		{value: "255.255.255.255", want: 32},
+		{value: "255.255.255.252", want: 30},
+		{value: "0.0.0.0", want: 0},
+		// Not contiguous
+		{value: "255.0.255.0", wantErr: true},
+		{value: "255.255.255.1", wantErr: true},
+		{value: "0.255.255.255", wantErr: true},
+		{value: "255.255.253.0", wantErr: true},
+		// Not a netmask at all
+		{value: "255.255.256.0", wantErr: true},
+		{value: "255.255.255", wantErr: true},
+		{value: "ffff:ffff:ffff:ffff::", wantErr: true},
+		{value: "/24", wantErr: true},
+		{value: "24a", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parsePrefixLength(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parsePrefixLength(%q) error = %v, want error %t", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("parsePrefixLength(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestNetmaskOption(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		mask string
+		// The CIDR of the address, or "" if the configuration is invalid
+		want string
+	}{
+		{name: "IPv4 netmask", ip: "10.1.2.3", mask: "255.255.255.0", want: "10.1.2.3/24"},
+		{name: "IPv4 odd netmask", ip: "10.1.3.3", mask: "255.255.254.0", want: "10.1.3.3/23"},
+		{name: "IPv4 class A netmask", ip: "10.1.2.3", mask: "255.0.0.0", want: "10.1.2.3/8"},
+		{name: "IPv4 prefix length", ip: "10.1.2.3", mask: "24", want: "10.1.2.3/24"},
+		{name: "IPv4 netmask in the address", ip: "10.1.2.3/255.255.255.0", mask: "-1", want: "10.1.2.3/24"},
+		{name: "IPv4 netmask matching the address", ip: "10.1.2.3/24", mask: "255.255.255.0", want: "10.1.2.3/24"},
+		{name: "IPv4 netmask conflicting with the address", ip: "10.1.2.3/16", mask: "255.255.255.0"},
+		{name: "IPv4 prefix length too long", ip: "10.1.2.3", mask: "33"},
+		{name: "IPv4 netmask of zero", ip: "10.1.2.3", mask: "0.0.0.0"},
+		{name: "IPv6 prefix length", ip: "fd00::10", mask: "64", want: "fd00::10/64"},
+		{name: "IPv6 prefix length in the address", ip: "fd00::10/64", mask: "-1", want: "fd00::10/64"},
+		{name: "IPv6 host prefix", ip: "fd00::10", mask: "128", want: "fd00::10/128"},
+		{name: "IPv6 prefix length too long", ip: "fd00::10", mask: "129"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mask netmaskValue
+			if err := mask.Set(test.mask); err != nil {
+				t.Fatalf("Set(%q) failed: %s", test.mask, err)
+			}
+			var p configProblems
+			checkVIPAddress(&p, "ip", test.ip, "mask", int(mask), "10.1.2.3")
+			if test.want == "" {
+				if len(p) == 0 {
+					t.Errorf("-ip %s -mask %s was accepted", test.ip, test.mask)
+				}
+				return
+			}
+			if len(p) > 0 {
+				t.Fatalf("-ip %s -mask %s was rejected: %s", test.ip, test.mask, p)
+			}
+			vip, length := vipAddress(test.ip, int(mask))
+			a := newIPConfiguration(vip, getMask(vip, length), net.Interface{Name: "eth0"}, "", false)
+			if got := a.GetCIDR(); got != test.want {
+				t.Errorf("-ip %s -mask %s is %s, want %s", test.ip, test.mask, got, test.want)
+			}
+		})
+	}
+
+	// An invalid value is rejected and keeps the previous one
+	mask := netmaskValue(24)
+	for _, junk := range []string{"255.0.255.0", "255.255.255.1", "ffff::", "abc"} {
+		if err := mask.Set(junk); err == nil {
+			t.Errorf("Set(%q) did not fail", junk)
+		}
+	}
+	if mask != 24 {
+		t.Errorf("the netmask is %s after invalid values, want 24", mask.String())
+	}
+}
@@ -0,0 +1,425 @@
+// Package metrics keeps counters, gauges and histograms in memory and exposes
+// them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// value is a float64 that can be updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (v *value) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter only ever goes up
+type Counter struct {
+	v *value
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	c.v.Add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.Value()
+}
+
+// Gauge can be set to arbitrary values
+type Gauge struct {
+	v *value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.Set(f)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.Add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.Value()
+}
+
+// histogram counts observations in buckets with the given upper bounds
+type histogram struct {
+	upperBounds []float64
+	counts      []value
+	sum         value
+	count       value
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.upperBounds {
+		if v <= bound {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+// Histogram tracks the distribution of observed values, e.g. durations
+type Histogram struct {
+	h *histogram
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.h.observe(v)
+}
+
+type family struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	// Only for histograms
+	upperBounds []float64
+
+	lock     sync.Mutex
+	children map[string]*child
+}
+
+type child struct {
+	labelValues []string
+	value       *value
+	histogram   *histogram
+	// Only for gauges computed when read
+	fn func() float64
+}
+
+// current returns the value of a counter or gauge.
+func (c *child) current() float64 {
+	if c.fn != nil {
+		return c.fn()
+	}
+	return c.value.Value()
+}
+
+func (f *family) with(labelValues []string) *child {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{labelValues: append([]string(nil), labelValues...), value: &value{}}
+		if f.typ == histogramType {
+			c.histogram = &histogram{
+				upperBounds: f.upperBounds,
+				counts:      make([]value, len(f.upperBounds)),
+			}
+		}
+		f.children[key] = c
+	}
+	return c
+}
+
+type registry struct {
+	lock     sync.Mutex
+	families []*family
+	// Added to the labels of every metric
+	constLabelNames  []string
+	constLabelValues []string
+}
+
+var defaultRegistry = &registry{}
+
+func register(name, help string, typ metricType, labelNames []string) *family {
+	f := &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		children:   make(map[string]*child),
+	}
+	for _, label := range labelNames {
+		if typ == histogramType && label == "le" {
+			panic(fmt.Sprintf("histogram %s must not have a label le", f.name))
+		}
+	}
+
+	defaultRegistry.lock.Lock()
+	defer defaultRegistry.lock.Unlock()
+	for _, other := range defaultRegistry.families {
+		if other.name == name {
+			panic(fmt.Sprintf("metric %s registered twice", name))
+		}
+	}
+	defaultRegistry.families = append(defaultRegistry.families, f)
+	return f
+}
+
+// SetConstLabel adds a label with the same value to all metrics, e.g. to
+// tell several instances on one host apart.
+func SetConstLabel(name, value string) {
+	defaultRegistry.lock.Lock()
+	defer defaultRegistry.lock.Unlock()
+	defaultRegistry.constLabelNames = append(defaultRegistry.constLabelNames, name)
+	defaultRegistry.constLabelValues = append(defaultRegistry.constLabelValues, value)
+}
+
+// snapshot returns the registered families and the constant labels.
+func (r *registry) snapshot() (families []*family, constNames, constValues []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*family(nil), r.families...), r.constLabelNames, r.constLabelValues
+}
+
+// labels returns the label names and values of c including the constant
+// labels.
+func labels(constNames, constValues []string, f *family, c *child) (names, values []string) {
+	names = append(append([]string(nil), constNames...), f.labelNames...)
+	values = append(append([]string(nil), constValues...), c.labelValues...)
+	return names, values
+}
+
+func NewCounter(name, help string) *Counter {
+	return &Counter{register(name, help, counterType, nil).with(nil).value}
+}
+
+func NewGauge(name, help string) *Gauge {
+	return &Gauge{register(name, help, gaugeType, nil).with(nil).value}
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn whenever the
+// metrics are read, e.g. the age of something.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	f := register(name, help, gaugeType, nil)
+	c := f.with(nil)
+	f.lock.Lock()
+	c.fn = fn
+	f.lock.Unlock()
+}
+
+// CounterVec is a set of counters told apart by their label values
+type CounterVec struct {
+	f *family
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: register(name, help, counterType, labelNames)}
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{v.f.with(labelValues).value}
+}
+
+// GaugeVec is a set of gauges told apart by their label values
+type GaugeVec struct {
+	f *family
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: register(name, help, gaugeType, labelNames)}
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{v.f.with(labelValues).value}
+}
+
+// SetOnly replaces all gauges of v by one with labelValues, whose value is
+// returned by fn whenever the metrics are read. For info metrics that only
+// export the current value, which keeps the number of series bounded.
+func (v *GaugeVec) SetOnly(fn func() float64, labelValues ...string) {
+	if len(labelValues) != len(v.f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.f.name, len(v.f.labelNames), len(labelValues)))
+	}
+	c := &child{labelValues: append([]string(nil), labelValues...), value: &value{}, fn: fn}
+	v.f.lock.Lock()
+	defer v.f.lock.Unlock()
+	v.f.children = map[string]*child{strings.Join(labelValues, "\x00"): c}
+}
+
+func registerHistogram(name, help string, upperBounds []float64, labelNames []string) *family {
+	if !sort.Float64sAreSorted(upperBounds) {
+		panic(fmt.Sprintf("buckets of histogram %s are not sorted", name))
+	}
+	f := register(name, help, histogramType, labelNames)
+	f.upperBounds = upperBounds
+	return f
+}
+
+// NewHistogram counts observations in buckets with the given upper bounds.
+// Values above the last bound are only counted in the +Inf bucket.
+func NewHistogram(name, help string, upperBounds []float64) *Histogram {
+	return &Histogram{registerHistogram(name, help, upperBounds, nil).with(nil).histogram}
+}
+
+// HistogramVec is a set of histograms told apart by their label values
+type HistogramVec struct {
+	f *family
+}
+
+func NewHistogramVec(name, help string, upperBounds []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{f: registerHistogram(name, help, upperBounds, labelNames)}
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{v.f.with(labelValues).histogram}
+}
+
+// Sample is the current value of one metric with its labels
+type Sample struct {
+	Name        string
+	Type        string
+	LabelNames  []string
+	LabelValues []string
+	// For counters and gauges
+	Value float64
+	// For histograms
+	Sum   float64
+	Count float64
+}
+
+// Gather returns the current values of all metrics, e.g. to push them
+// somewhere else.
+func Gather() []Sample {
+	families, constNames, constValues := defaultRegistry.snapshot()
+
+	var samples []Sample
+	for _, f := range families {
+		f.lock.Lock()
+		for _, c := range f.children {
+			names, values := labels(constNames, constValues, f, c)
+			s := Sample{Name: f.name, Type: string(f.typ), LabelNames: names, LabelValues: values}
+			if c.histogram != nil {
+				s.Sum = c.histogram.sum.Value()
+				s.Count = c.histogram.count.Value()
+			} else {
+				s.Value = c.current()
+			}
+			samples = append(samples, s)
+		}
+		f.lock.Unlock()
+	}
+	return samples
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func WritePrometheus(w io.Writer) error {
+	families, constNames, constValues := defaultRegistry.snapshot()
+
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ); err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		children := make([]*child, 0, len(f.children))
+		for _, c := range f.children {
+			children = append(children, c)
+		}
+		f.lock.Unlock()
+		sort.Slice(children, func(i, j int) bool {
+			return strings.Join(children[i].labelValues, "\x00") < strings.Join(children[j].labelValues, "\x00")
+		})
+
+		for _, c := range children {
+			var err error
+			names, values := labels(constNames, constValues, f, c)
+			if c.histogram != nil {
+				err = writeHistogram(w, f.name, c.histogram, names, values)
+			} else {
+				_, err = fmt.Fprintf(w, "%s%s %v\n", f.name, formatLabels(names, values), c.current())
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeHistogram(w io.Writer, name string, h *histogram, labelNames, labelValues []string) error {
+	names := append(append([]string(nil), labelNames...), "le")
+	values := append(append([]string(nil), labelValues...), "")
+
+	var cumulative float64
+	for i, bound := range h.upperBounds {
+		cumulative += h.counts[i].Value()
+		values[len(values)-1] = fmt.Sprint(bound)
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %v\n", name, formatLabels(names, values), cumulative); err != nil {
+			return err
+		}
+	}
+	values[len(values)-1] = "+Inf"
+	count := h.count.Value()
+	if _, err := fmt.Fprintf(w, "%s_bucket%s %v\n", name, formatLabels(names, values), count); err != nil {
+		return err
+	}
+
+	labels := formatLabels(labelNames, labelValues)
+	_, err := fmt.Fprintf(w, "%s_sum%s %v\n%s_count%s %v\n", name, labels, h.sum.Value(), name, labels, count)
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", names[i], labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w)
+	})
+}
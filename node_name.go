@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var hostShortName = boolOption("host-short-name", false, "Use the hostname up to the first dot when -host is not set, e.g. db1 for db1.example.com")
+
+// nodeName returns -host, or the hostname of this machine if it is unset.
+// The error is only set if the hostname is needed and cannot be read.
+func nodeName() (string, error) {
+	if !isUnset(*host) {
+		return *host, nil
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if *hostShortName {
+		name, _, _ = strings.Cut(name, ".")
+	}
+	return name, nil
+}
+
+// logNodeName tells which value of the leader key makes this node leader,
+// a wrong one means the virtual IP never appears.
+func logNodeName() {
+	name, _ := nodeName()
+	if isUnset(*host) {
+		slog.Info("Configuring the virtual IP when the leader key is "+name+", the hostname of this machine; set -host if Patroni uses another name", "host", name)
+	} else {
+		slog.Info("Configuring the virtual IP when the leader key is "+name, "host", name)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// How long -once may take to read the key and reach the desired state
+const onceTimeout = time.Minute
+
+// readStateOnce returns the first state reported by lc.
+func readStateOnce(ctx context.Context, lc checker.LeaderChecker) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	states := make(chan bool)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- lc.GetChangeNotificationStream(ctx, states)
+	}()
+	select {
+	case state := <-states:
+		return state, nil
+	case err := <-errs:
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("no answer in time")
+		}
+		return false, err
+	}
+}
+
+// runOnce reads the leader key, brings the addresses into the state it
+// asks for and prints what was done. It returns the exit code.
+func runOnce(lc checker.LeaderChecker, m *ipmanager.IPManager) int {
+	ctx, cancel := context.WithTimeout(context.Background(), onceTimeout)
+	defer cancel()
+
+	state, err := readStateOnce(ctx, lc)
+	if err != nil {
+		slog.Error("Cannot read the leader key", "type", *endpointType, "endpoint", *endpoint, "error", err)
+		return exitDCSUnreachable
+	}
+	changes, err := m.Once(ctx, state)
+	for _, c := range changes {
+		fmt.Printf("%s %s on %s\n", c.Result, c.Address, c.Interface)
+	}
+	if err != nil {
+		slog.Error("Desired state not reached", "state", state, "timeout", onceTimeout, "error", err)
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every option is defined once, with the functions below instead of the
+// ones of package flag. The definition holds its name, default and help,
+// its deprecated names, how its value is checked and whether it is a
+// secret. The flag, the environment variable, the config file key, the
+// check in validateConfig, reloading and the redaction in the effective
+// configuration are all derived from it.
+
+// optionDef is the definition of an option.
+type optionDef struct {
+	name string
+	// Old names that keep working as flag, environment variable and config
+	// file key, with a warning
+	aliases []string
+	// Shown redacted and read from a file with secretFile
+	secret     bool
+	secretFile *optionDef
+	// May be given more than once, as a list in the config file and
+	// separated by spaces in the environment
+	repeatable bool
+	// Applied when the config file is reloaded, the others need a restart
+	reloadable bool
+	// A reload with a change creates a new leader checker
+	checker bool
+	// Checks the value on its own and returns what is wrong with it, or
+	// "". The checks that involve other options are in validateConfig.
+	checks []func(f *flag.Flag) string
+	flag   *flag.Flag
+}
+
+// The options by name
+var optionDefs = make(map[string]*optionDef)
+
+// optionAttr is an optional part of the definition of an option.
+type optionAttr func(o *optionDef)
+
+// deprecatedAs keeps the old names of an option working.
+func deprecatedAs(names ...string) optionAttr {
+	return func(o *optionDef) {
+		o.aliases = append(o.aliases, names...)
+	}
+}
+
+// secret marks an option that holds a secret, e.g. a password.
+func secret() optionAttr {
+	return func(o *optionDef) {
+		o.secret = true
+	}
+}
+
+func repeatable() optionAttr {
+	return func(o *optionDef) {
+		o.repeatable = true
+	}
+}
+
+// reloadable marks an option that is applied on reload. newChecker replaces
+// the leader checker when it changes.
+func reloadable(newChecker bool) optionAttr {
+	return func(o *optionDef) {
+		o.reloadable = true
+		o.checker = newChecker
+	}
+}
+
+func check(fn func(f *flag.Flag) string) optionAttr {
+	return func(o *optionDef) {
+		o.checks = append(o.checks, fn)
+	}
+}
+
+// oneOf only accepts values, "" is accepted if it is one of them without
+// being mentioned.
+func oneOf(values ...string) optionAttr {
+	return oneOfMatching(func(a, b string) bool { return a == b }, values)
+}
+
+// oneOfIgnoreCase is oneOf for an option that is compared in lower case.
+func oneOfIgnoreCase(values ...string) optionAttr {
+	return oneOfMatching(strings.EqualFold, values)
+}
+
+func oneOfMatching(equal func(a, b string) bool, values []string) optionAttr {
+	var named []string
+	for _, v := range values {
+		if v != "" {
+			named = append(named, v)
+		}
+	}
+	expected := strings.Join(named, ", ")
+	if len(named) > 1 {
+		expected = strings.Join(named[:len(named)-1], ", ") + " or " + named[len(named)-1]
+	}
+	return check(func(f *flag.Flag) string {
+		value := f.Value.String()
+		for _, v := range values {
+			if equal(value, v) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%q is not supported, expected %s", value, expected)
+	})
+}
+
+// notNegative only accepts durations and numbers of at least 0.
+func notNegative() optionAttr {
+	return check(func(f *flag.Flag) string {
+		if sign(f) < 0 {
+			return "must not be negative"
+		}
+		return ""
+	})
+}
+
+// positive only accepts durations and numbers above 0.
+func positive(example string) optionAttr {
+	return check(func(f *flag.Flag) string {
+		if sign(f) <= 0 {
+			return "must be positive, e.g. " + example
+		}
+		return ""
+	})
+}
+
+// sign returns -1, 0 or 1 for the value of a duration or number option.
+func sign(f *flag.Flag) int {
+	var v int64
+	switch value := f.Value.(flag.Getter).Get().(type) {
+	case time.Duration:
+		v = int64(value)
+	case int:
+		v = int64(value)
+	default:
+		panic(fmt.Sprintf("option %s is not a number", f.Name))
+	}
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
+
+// defineOption completes the definition of the option whose flag was just
+// defined.
+func defineOption(name string, attrs []optionAttr) *optionDef {
+	o := &optionDef{name: name, flag: flag.Lookup(name)}
+	for _, attr := range attrs {
+		attr(o)
+	}
+	o.flag.Usage += fmt.Sprintf(" [$%s]", envName(name))
+	for _, alias := range o.aliases {
+		flag.Var(aliasValue{name}, alias, fmt.Sprintf("Deprecated, use -%s. [$%s]", name, envName(alias)))
+	}
+	optionDefs[name] = o
+	if o.secret {
+		o.secretFile = defineSecretFile(name)
+	}
+	return o
+}
+
+func stringOption(name, value, usage string, attrs ...optionAttr) *string {
+	p := flag.String(name, value, usage)
+	defineOption(name, attrs)
+	return p
+}
+
+func boolOption(name string, value bool, usage string, attrs ...optionAttr) *bool {
+	p := flag.Bool(name, value, usage)
+	defineOption(name, attrs)
+	return p
+}
+
+func intOption(name string, value int, usage string, attrs ...optionAttr) *int {
+	p := flag.Int(name, value, usage)
+	defineOption(name, attrs)
+	return p
+}
+
+func durationOption(name string, value time.Duration, usage string, attrs ...optionAttr) *time.Duration {
+	p := flag.Duration(name, value, usage)
+	defineOption(name, attrs)
+	return p
+}
+
+// varOption defines an option with a value of its own type, like flag.Var.
+func varOption(value flag.Value, name, usage string, attrs ...optionAttr) {
+	flag.Var(value, name, usage)
+	defineOption(name, attrs)
+}
+
+// allOptions returns the definitions sorted by name, like flag.VisitAll.
+func allOptions() []*optionDef {
+	options := make([]*optionDef, 0, len(optionDefs))
+	for _, o := range optionDefs {
+		options = append(options, o)
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].name < options[j].name })
+	return options
+}
+
+// lookupOption returns the option called name, also by a deprecated name.
+func lookupOption(name string) (o *optionDef, deprecated bool) {
+	if o := optionDefs[name]; o != nil {
+		return o, false
+	}
+	for _, o := range optionDefs {
+		for _, alias := range o.aliases {
+			if alias == name {
+				return o, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// checkOptions runs the checks of every option.
+func checkOptions(p *configProblems) {
+	for _, o := range allOptions() {
+		for _, check := range o.checks {
+			if problem := check(o.flag); problem != "" {
+				p.add(o.name, "%s", problem)
+			}
+		}
+	}
+}
+
+// Uses of deprecated names seen while loading the config, logged once
+// logging is set up
+var deprecationWarnings []deprecationWarning
+
+type deprecationWarning struct {
+	option, replacement, source string
+}
+
+// aliasValue is the flag of a deprecated option, it sets its replacement.
+type aliasValue struct {
+	target string
+}
+
+func (a aliasValue) String() string {
+	return ""
+}
+
+// Set bypasses flag.Set, so only the alias counts as given.
+func (a aliasValue) Set(value string) error {
+	return flag.Lookup(a.target).Value.Set(value)
+}
+
+func (a aliasValue) IsBoolFlag() bool {
+	if f := flag.Lookup(a.target); f != nil {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		return ok && b.IsBoolFlag()
+	}
+	return false
+}
+
+func isAlias(f *flag.Flag) bool {
+	_, ok := f.Value.(aliasValue)
+	return ok
+}
+
+func deprecated(alias, option, source string) {
+	deprecationWarnings = append(deprecationWarnings, deprecationWarning{alias, option, source})
+}
+
+// givenFlags returns the options given on the command line, with deprecated
+// names resolved.
+func givenFlags() (map[string]bool, error) {
+	given := make(map[string]bool)
+	var aliases []string
+	flag.Visit(func(f *flag.Flag) {
+		if isAlias(f) {
+			aliases = append(aliases, f.Name)
+		} else if optionDefs[f.Name] != nil {
+			given[f.Name] = true
+		}
+	})
+	for _, name := range aliases {
+		o, _ := lookupOption(name)
+		if given[o.name] {
+			return nil, fmt.Errorf("options -%s and -%s given both, -%s is deprecated", name, o.name, name)
+		}
+		given[o.name] = true
+		deprecated(name, o.name, "flag")
+	}
+	return given, nil
+}
+
+// envNames returns the environment variables of the option, its own first.
+func (o *optionDef) envNames() []string {
+	names := []string{envName(o.name)}
+	for _, alias := range o.aliases {
+		names = append(names, envName(alias))
+	}
+	return names
+}
+
+// lookupEnv returns the environment variable of the option, or of one of
+// its deprecated names. The current name wins.
+func (o *optionDef) lookupEnv() (value, name string, ok bool) {
+	for i, name := range o.envNames() {
+		if value, ok = os.LookupEnv(name); ok {
+			if i > 0 {
+				deprecated(o.aliases[i-1], o.name, name)
+			}
+			return value, name, true
+		}
+	}
+	return "", envName(o.name), false
+}
+
+// resolveAliases moves the values of deprecated keys in a config file to
+// their replacements.
+func resolveAliases(path string, values map[string][]string) error {
+	for _, o := range allOptions() {
+		for _, alias := range o.aliases {
+			if values[alias] == nil {
+				continue
+			}
+			if values[o.name] != nil {
+				return fmt.Errorf("%s: options %s and %s given both, %s is deprecated", path, alias, o.name, alias)
+			}
+			values[o.name] = values[alias]
+			delete(values, alias)
+			deprecated(alias, o.name, path)
+		}
+	}
+	return nil
+}
+
+// logDeprecations warns about every deprecated name used.
+func logDeprecations() {
+	for _, w := range deprecationWarnings {
+		slog.Warn("Option is deprecated, use its replacement", "option", w.option, "replacement", w.replacement, "source", w.source)
+	}
+	deprecationWarnings = nil
+}
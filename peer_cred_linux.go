@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user id of the process on the other end of conn, or
+// -1 if it cannot be told.
+func peerUID(conn *net.UnixConn) int {
+	if cred := peerCred(conn); cred != nil {
+		return int(cred.Uid)
+	}
+	return -1
+}
+
+// peerPID returns the process id of the process on the other end of conn,
+// or 0 if it cannot be told.
+func peerPID(conn *net.UnixConn) int {
+	if cred := peerCred(conn); cred != nil {
+		return int(cred.Pid)
+	}
+	return 0
+}
+
+func peerCred(conn *net.UnixConn) *syscall.Ucred {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil
+	}
+	return cred
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "net"
+
+// peerUID returns -1, the user id of the peer is only known on Linux.
+func peerUID(conn *net.UnixConn) int {
+	return -1
+}
+
+// peerPID returns 0, the process id of the peer is only known on Linux.
+func peerPID(conn *net.UnixConn) int {
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pidFilePath returns path, or a file named after the instance if path is a
+// directory, so that several instances can share it.
+func pidFilePath(path string) string {
+	return instanceFile(path, instance(), ".pid")
+}
+
+// processAlive reports whether a process with pid exists. A process owned by
+// another user still counts.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// checkStalePidFile removes the pid file at path if the process it names is
+// gone, and fails if it is still running.
+func checkStalePidFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return os.Remove(path)
+	}
+	// Our own pid is left over from an earlier run, e.g. in a container
+	// where we always are pid 1
+	if pid == os.Getpid() {
+		return os.Remove(path)
+	}
+	// A pid that was reused by another program after an unclean shutdown
+	// does not count
+	if processAlive(pid) && sameProgram(pid) {
+		return &runningError{pid: pid, source: path}
+	}
+	return os.Remove(path)
+}
+
+// writePidFile writes our pid to path, unless it names another process that
+// is still running. The pid is written to a temporary file that is then
+// hard linked to path, which fails if path exists. So readers never see a
+// partial pid and two instances starting at once cannot both succeed.
+func writePidFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%d\n", os.Getpid()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	err = os.Link(tmp.Name(), path)
+	if os.IsExist(err) {
+		if err := checkStalePidFile(path); err != nil {
+			return err
+		}
+		err = os.Link(tmp.Name(), path)
+	}
+	return err
+}
+
+// removePidFile removes path if it still contains our pid.
+func removePidFile(path string) {
+	content, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	os.Remove(path)
+}
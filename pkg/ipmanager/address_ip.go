@@ -0,0 +1,175 @@
+//go:build !solaris
+// +build !solaris
+
+package ipmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// Addresses are managed with ip from iproute2.
+const AddressBackend = "iproute2"
+
+// Gratuitous ARP and unsolicited neighbor advertisements are sent from raw
+// sockets.
+const canAnnounce = true
+
+// Label tags an address as added by vip-manager. Labels only exist for IPv4,
+// have to start with the interface name and are limited to 15 characters.
+func (c *IPConfiguration) Label() string {
+	if c.label != "" {
+		return c.label
+	}
+	label := c.iface.Name + ":vip"
+	if c.vip.To4() == nil || len(label) > 15 {
+		return ""
+	}
+	return label
+}
+
+func (c *IPConfiguration) checkLabel() error {
+	return CheckLabel(c.vip, c.iface.Name, c.label)
+}
+
+// CheckLabel fails if label cannot be the label of vip on iface. Without
+// iface, only what does not depend on the interface is checked.
+func CheckLabel(vip net.IP, iface, label string) error {
+	if label == "" {
+		return nil
+	}
+	if vip.To4() == nil {
+		return fmt.Errorf("labels are only supported for IPv4 addresses")
+	}
+	if len(label) > 15 {
+		return fmt.Errorf("label %s must not be longer than 15 characters", label)
+	}
+	if iface != "" && !strings.HasPrefix(label, iface) {
+		return fmt.Errorf("label %s has to start with %s", label, iface)
+	}
+	return nil
+}
+
+// showAddressCommand lists the addresses of iface as JSON.
+func showAddressCommand(iface string) (name string, args []string) {
+	return "ip", []string{"-j", "addr", "show", "dev", iface}
+}
+
+// listAddresses returns no addresses if iface does not exist (yet). It fails
+// if ip fails for any other reason or its output cannot be parsed, as the
+// addresses are unknown then.
+func listAddresses(c Commander, iface string) ([]interfaceAddress, error) {
+	name, args := showAddressCommand(iface)
+	output, stderr, exitCode, err := c.Run(context.Background(), name, args...)
+	if err != nil {
+		if exitCode > 0 && ClassifyCommandFailure(err, exitCode, stderr) == FailureDevice {
+			return nil, nil
+		}
+		class := commandFailed(name, err, exitCode, stderr)
+		return nil, &CommandError{Err: err, Output: strings.TrimSpace(string(stderr)), Class: class}
+	}
+
+	addresses, err := parseAddresses(output)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the addresses of %s: %w", iface, err)
+	}
+	return addresses, nil
+}
+
+type ipAddrOutput []struct {
+	AddrInfo []struct {
+		Family    string `json:"family"`
+		Local     string `json:"local"`
+		PrefixLen int    `json:"prefixlen"`
+		Label     string `json:"label"`
+		Tentative bool   `json:"tentative"`
+		DADFailed bool   `json:"dadfailed"`
+	} `json:"addr_info"`
+}
+
+// parseAddresses extracts the addresses from the output of ip -j addr show.
+// Addresses are compared as parsed IPs, never as strings, so 10.0.0.1 does
+// not match 10.0.0.10 or 110.0.0.1.
+func parseAddresses(output []byte) ([]interfaceAddress, error) {
+	var links ipAddrOutput
+	if err := json.Unmarshal(output, &links); err != nil {
+		return nil, err
+	}
+
+	var addresses []interfaceAddress
+	for _, link := range links {
+		for _, info := range link.AddrInfo {
+			if info.Family != "inet" && info.Family != "inet6" {
+				continue
+			}
+			ip := net.ParseIP(info.Local)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", info.Local)
+			}
+			addresses = append(addresses, interfaceAddress{
+				ip:        ip,
+				prefix:    info.PrefixLen,
+				label:     info.Label,
+				tentative: info.Tentative && !info.DADFailed,
+				dadFailed: info.DADFailed,
+			})
+		}
+	}
+	return addresses, nil
+}
+
+func (m *IPManager) runAddressConfiguration(ctx context.Context, a *IPConfiguration, action string) bool {
+	var args []string
+	if label := a.Label(); label != "" && action == "add" {
+		args = append(args, "label", label)
+	}
+	return m.runIPAddr(ctx, action, a.GetCIDR(), a.iface.Name, args...)
+}
+
+func (m *IPManager) runIPAddr(ctx context.Context, action, cidr, iface string, extraArgs ...string) bool {
+	args := append([]string{"addr", action, cidr, "dev", iface}, extraArgs...)
+	if skipDryRun("ip", args...) {
+		return true
+	}
+	_, stderr, exitCode, err := m.Commander.Run(ctx, "ip", args...)
+	if ctx.Err() != nil {
+		slog.Warn("Aborted ip address "+action, "vip", cidr, "iface", iface, "error", ctx.Err())
+		return false
+	}
+
+	switch {
+	case exitCode == 2 && alreadyDone(action, stderr):
+		return true
+	case exitCode > 0:
+		slog.Error("Error running ip address "+action, "vip", cidr, "iface", iface, "exit_status", exitCode,
+			"output", strings.TrimSpace(string(stderr)), "failure_class", commandFailed("ip", err, exitCode, stderr))
+		return false
+	case err != nil:
+		slog.Error("Error running ip address "+action, "vip", cidr, "iface", iface, "error", err,
+			"failure_class", commandFailed("ip", err, exitCode, stderr))
+		return false
+	}
+	return true
+}
+
+// alreadyDone tells whether ip failed only because the address was added or
+// removed before. ip exits with 2 for other errors as well, e.g. when it
+// lacks CAP_NET_ADMIN.
+func alreadyDone(action string, stderr []byte) bool {
+	switch action {
+	case "add":
+		return bytes.Contains(stderr, []byte("File exists"))
+	case "delete":
+		return bytes.Contains(stderr, []byte("Cannot assign requested address"))
+	}
+	return false
+}
+
+func (m *IPManager) removeAddress(ctx context.Context, cidr, iface string) bool {
+	return m.runIPAddr(ctx, "delete", cidr, iface)
+}
@@ -0,0 +1,347 @@
+//go:build !solaris
+// +build !solaris
+
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestAddressCommands(t *testing.T) {
+	// Blocks until the command is killed, like a hung ip
+	hang := func(ctx context.Context, argv []string) *fakeResult {
+		<-ctx.Done()
+		return &fakeResult{exitCode: -1, err: ctx.Err()}
+	}
+	notFound := func(ctx context.Context, argv []string) *fakeResult {
+		return &fakeResult{exitCode: -1, err: &exec.Error{Name: argv[0], Err: exec.ErrNotFound}}
+	}
+	notPermitted := func(ctx context.Context, argv []string) *fakeResult {
+		return exit(2, "RTNETLINK answers: Operation not permitted")
+	}
+	tests := []struct {
+		name    string
+		present bool
+		action  string
+		hook    func(ctx context.Context, argv []string) *fakeResult
+		want    bool
+		// Whether the address is there afterwards
+		wantPresent bool
+	}{
+		{name: "add", action: "add", want: true, wantPresent: true},
+		{name: "add existing, exit code 2", present: true, action: "add", want: true, wantPresent: true},
+		{name: "delete", present: true, action: "delete", want: true},
+		{name: "delete missing, exit code 2", action: "delete", want: true},
+		{name: "add fails", action: "add", want: false,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return exit(1, "RTNETLINK answers: Operation not permitted")
+			}},
+		// ip exits with 2 without CAP_NET_ADMIN as well
+		{name: "add not permitted, exit code 2", action: "add", hook: notPermitted, want: false},
+		{name: "delete not permitted, exit code 2", present: true, action: "delete", hook: notPermitted, want: false, wantPresent: true},
+		{name: "add times out", action: "add", hook: hang, want: false},
+		{name: "delete times out", present: true, action: "delete", hook: hang, want: false, wantPresent: true},
+		{name: "add without ip", action: "add", hook: notFound, want: false},
+		{name: "delete without ip", present: true, action: "delete", hook: notFound, want: false, wantPresent: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := newFakeIP("eth0")
+			ip.hook = test.hook
+			if test.present {
+				ip.add("eth0", "10.1.2.3/24", "")
+			}
+			m := &IPManager{ManagerOptions: ManagerOptions{Commander: ip}}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if got := m.runIPAddr(ctx, test.action, "10.1.2.3/24", "eth0"); got != test.want {
+				t.Errorf("runIPAddr(%s) = %t, want %t", test.action, got, test.want)
+			}
+			if got := ip.has("eth0", "10.1.2.3/24"); got != test.wantPresent {
+				t.Errorf("address present afterwards = %t, want %t", got, test.wantPresent)
+			}
+		})
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	// Shortened output of ip -j addr show dev eth0
+	output := []byte(`[{"ifindex":2,"ifname":"eth0","flags":["BROADCAST","MULTICAST","UP","LOWER_UP"],"mtu":1500,` +
+		`"operstate":"UP","link_type":"ether","address":"52:54:00:12:34:56","addr_info":[` +
+		`{"family":"inet","local":"110.0.0.1","prefixlen":24,"scope":"global","label":"eth0"},` +
+		`{"family":"inet","local":"10.0.0.10","prefixlen":24,"scope":"global","secondary":true,"label":"eth0"},` +
+		`{"family":"inet","local":"10.0.0.1","prefixlen":24,"scope":"global","secondary":true,"label":"eth0:vip"},` +
+		`{"family":"inet6","local":"fd00::10","prefixlen":64,"scope":"global","tentative":true},` +
+		`{"family":"inet6","local":"fe80::5054:ff:fe12:3456","prefixlen":64,"scope":"link","dadfailed":true,"tentative":true}]}]`)
+	want := []interfaceAddress{
+		{ip: net.ParseIP("110.0.0.1"), prefix: 24, label: "eth0"},
+		{ip: net.ParseIP("10.0.0.10"), prefix: 24, label: "eth0"},
+		{ip: net.ParseIP("10.0.0.1"), prefix: 24, label: "eth0:vip"},
+		{ip: net.ParseIP("fd00::10"), prefix: 64, tentative: true},
+		{ip: net.ParseIP("fe80::5054:ff:fe12:3456"), prefix: 64, dadFailed: true},
+	}
+	got, err := parseAddresses(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseAddresses() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].ip.Equal(want[i].ip) || got[i].prefix != want[i].prefix || got[i].label != want[i].label ||
+			got[i].tentative != want[i].tentative || got[i].dadFailed != want[i].dadFailed {
+			t.Errorf("address %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := parseAddresses([]byte("2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP>")); err == nil {
+		t.Error("parseAddresses() accepted the text output")
+	}
+}
+
+func TestQueryAddressSimilarAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		vip       string
+		addresses []string
+		want      bool
+		// Prefixes of the virtual IP other than the configured one
+		wantStale []int
+	}{
+		{name: "only the address", vip: "10.0.0.1", addresses: []string{"10.0.0.1/24"}, want: true},
+		{name: "longer address", vip: "10.0.0.1", addresses: []string{"10.0.0.10/24"}},
+		{name: "address with a prefix", vip: "10.0.0.1", addresses: []string{"110.0.0.1/24"}},
+		{name: "shorter address", vip: "10.0.0.10", addresses: []string{"10.0.0.1/24"}},
+		{name: "shorter address with a prefix", vip: "110.0.0.1", addresses: []string{"10.0.0.1/24"}},
+		{name: "among similar ones", vip: "10.0.0.1", addresses: []string{"110.0.0.1/24", "10.0.0.10/24", "10.0.0.1/24"}, want: true},
+		{name: "similar ones with other prefixes", vip: "10.0.0.1", addresses: []string{"10.0.0.10/16", "110.0.0.1/8"}},
+		{name: "other prefix", vip: "10.0.0.1", addresses: []string{"10.0.0.10/24", "10.0.0.1/16"}, wantStale: []int{16}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := newFakeIP("eth0")
+			for _, cidr := range test.addresses {
+				ip.add("eth0", cidr, "eth0:vip")
+			}
+			a, err := NewIPConfiguration(net.ParseIP(test.vip), net.CIDRMask(24, 32), net.Interface{Name: "eth0"}, "", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &IPManager{ManagerOptions: ManagerOptions{Commander: ip}}
+			state, err := m.QueryAddress(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Present != test.want {
+				t.Errorf("QueryAddress(%s) with %q: present = %t, want %t", test.vip, test.addresses, state.Present, test.want)
+			}
+			if fmt.Sprint(state.StalePrefixes) != fmt.Sprint(test.wantStale) {
+				t.Errorf("QueryAddress(%s) with %q: stale prefixes %v, want %v", test.vip, test.addresses, state.StalePrefixes, test.wantStale)
+			}
+		})
+	}
+}
+
+func TestConfigureAddressCommand(t *testing.T) {
+	ip := newFakeIP("eth0")
+	a, err := NewIPConfiguration(net.ParseIP("10.1.2.3"), net.CIDRMask(24, 32), net.Interface{Name: "eth0"}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &IPManager{ManagerOptions: ManagerOptions{Commander: ip}, addresses: []*IPConfiguration{a},
+		added: make([]bool, 1), announcer: newAnnouncer(nil, nil, nil)}
+
+	if !m.ConfigureAddress(context.Background(), a) {
+		t.Fatal("ConfigureAddress failed")
+	}
+	state, err := m.QueryAddress(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Present || state.Foreign {
+		t.Errorf("after ConfigureAddress the state is %+v, want present and not foreign", state)
+	}
+	if !m.DeconfigureAddress(context.Background(), a) {
+		t.Fatal("DeconfigureAddress failed")
+	}
+	if state, _ := m.QueryAddress(a); state.Present {
+		t.Error("still present after DeconfigureAddress")
+	}
+
+	want := []string{
+		"ip addr add 10.1.2.3/24 dev eth0 label eth0:vip",
+		"ip -j addr show dev eth0",
+		"ip addr delete 10.1.2.3/24 dev eth0",
+		"ip -j addr show dev eth0",
+	}
+	got := ip.commands()
+	if len(got) != len(want) {
+		t.Fatalf("commands are %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestListAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		iface   string
+		hook    func(ctx context.Context, argv []string) *fakeResult
+		want    int
+		wantErr bool
+	}{
+		{name: "addresses", iface: "eth0", want: 2},
+		// Waited for, not an error
+		{name: "missing interface", iface: "eth1"},
+		{name: "without ip", iface: "eth0", wantErr: true,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return &fakeResult{exitCode: -1, err: &exec.Error{Name: argv[0], Err: exec.ErrNotFound}}
+			}},
+		// The addresses are unknown, not absent
+		{name: "not permitted", iface: "eth0", wantErr: true,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return exit(2, "RTNETLINK answers: Operation not permitted")
+			}},
+		{name: "other failure", iface: "eth0", wantErr: true,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return exit(255, "Error: either \"dev\" is duplicate, or \"-j\" is a garbage.")
+			}},
+		{name: "unparsable output", iface: "eth0", wantErr: true,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return &fakeResult{stdout: []byte("2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500")}
+			}},
+		{name: "invalid address", iface: "eth0", wantErr: true,
+			hook: func(ctx context.Context, argv []string) *fakeResult {
+				return &fakeResult{stdout: []byte(`[{"addr_info":[{"family":"inet","local":"10.1.2","prefixlen":24}]}]`)}
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := newFakeIP("eth0")
+			ip.add("eth0", "10.1.2.3/24", "eth0:vip")
+			ip.add("eth0", "fd00::10/64", "")
+			ip.hook = test.hook
+			got, err := listAddresses(ip, test.iface)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("listAddresses() error = %v, want error %t", err, test.wantErr)
+			}
+			if len(got) != test.want {
+				t.Errorf("listAddresses() = %+v, want %d addresses", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClassifyCommandFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	killed := exec.CommandContext(ctx, "sleep", "10").Run()
+
+	tests := []struct {
+		name     string
+		err      error
+		exitCode int
+		stderr   string
+		want     string
+	}{
+		{"exit code 2", exit(2, "").err, 2, "RTNETLINK answers: File exists", FailureUnknown},
+		{"timeout", context.DeadlineExceeded, -1, "", FailureTimeout},
+		{"killed", killed, -1, "", FailureTimeout},
+		{"binary not found", &exec.Error{Name: "ip", Err: exec.ErrNotFound}, -1, "", FailureMissing},
+		{"not found by the prefix", exit(127, "").err, 127, "sudo: ip: command not found", FailureMissing},
+		{"not executable", exit(126, "").err, 126, "", FailurePermission},
+		{"not permitted", exit(2, "").err, 2, "RTNETLINK answers: Operation not permitted", FailurePermission},
+		{"sudo password", exit(1, "").err, 1, "sudo: a password is required", FailurePermission},
+		{"missing device", exit(1, "").err, 1, "Cannot find device \"eth9\"", FailureDevice},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ClassifyCommandFailure(test.err, test.exitCode, []byte(test.stderr)); got != test.want {
+				t.Errorf("ClassifyCommandFailure(%v, %d, %q) = %s, want %s", test.err, test.exitCode, test.stderr, got, test.want)
+			}
+		})
+	}
+}
+
+// TestQueryAddressFailure makes sure a failed listing is not taken for an
+// address that is absent, which a leader would try to add again.
+func TestQueryAddressFailure(t *testing.T) {
+	ip := newFakeIP("eth0")
+	ip.add("eth0", "10.1.2.3/24", "eth0:vip")
+	ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+		return &fakeResult{stdout: []byte("garbage")}
+	}
+	a, err := NewIPConfiguration(net.ParseIP("10.1.2.3"), net.CIDRMask(24, 32), net.Interface{Name: "eth0"}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &IPManager{ManagerOptions: ManagerOptions{Commander: ip}, addresses: []*IPConfiguration{a}}
+	if states, err := m.QueryAddresses(); err == nil {
+		t.Errorf("QueryAddresses() = %+v without an error", states)
+	}
+}
+
+// TestAddressCommandNotPermitted makes sure that ip failing without
+// CAP_NET_ADMIN is counted as a permission problem and backed off from.
+func TestAddressCommandNotPermitted(t *testing.T) {
+	states := make(chan bool)
+	m, ip, a := newFakeManager(t, "fd00::10", states, ManagerOptions{})
+	ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+		if argv[1] == "addr" && argv[2] == "add" {
+			return exit(2, "RTNETLINK answers: Operation not permitted")
+		}
+		return nil
+	}
+	failures := commandFailures.With("ip", FailurePermission)
+	before := failures.Value()
+
+	actual, err := m.QueryAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.reconcile(context.Background(), actual, FirewallAbsent, false, true)
+	if ip.has(a.iface.Name, a.GetCIDR()) {
+		t.Fatal("the address was added")
+	}
+	if got := failures.Value() - before; got != 1 {
+		t.Errorf("%v permission failures were counted, want 1", got)
+	}
+	if !m.backoff.Waiting() {
+		t.Error("the failure is not backed off from")
+	}
+	m.reconcile(context.Background(), actual, FirewallAbsent, false, true)
+	if adds := count(ip.commands(), "ip addr add"); adds != 1 {
+		t.Errorf("ip addr add was run %d times during the backoff, want once", adds)
+	}
+}
+
+func TestCheckLabel(t *testing.T) {
+	vip4, vip6 := net.ParseIP("10.1.2.3"), net.ParseIP("fd00::10")
+	tests := []struct {
+		vip          net.IP
+		iface, label string
+		wantErr      bool
+	}{
+		{vip: vip4, iface: "eth0", label: ""},
+		{vip: vip6, iface: "eth0", label: ""},
+		{vip: vip4, iface: "eth0", label: "eth0:repl"},
+		{vip: vip4, iface: "", label: "bond1:repl"},
+		{vip: vip4, iface: "eth0", label: "bond1:repl", wantErr: true},
+		{vip: vip4, iface: "", label: "eth0:replication", wantErr: true},
+		{vip: vip6, iface: "eth0", label: "eth0:repl", wantErr: true},
+		{vip: vip6, iface: "", label: "eth0:repl", wantErr: true},
+	}
+	for _, test := range tests {
+		if err := CheckLabel(test.vip, test.iface, test.label); (err != nil) != test.wantErr {
+			t.Errorf("CheckLabel(%s, %q, %q) = %v, want error %t", test.vip, test.iface, test.label, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+//go:build solaris
+// +build solaris
+
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// Addresses are managed with ipadm on Solaris and illumos. Our addresses are
+// temporary address objects named <iface>/vipmgr, so they do not survive a
+// reboot and are easy to tell apart from the rest.
+const AddressBackend = "ipadm"
+
+// There is no raw socket support for sending ARP or neighbor advertisements.
+const canAnnounce = false
+
+// Label is the name of our address object on the interface.
+func (c *IPConfiguration) Label() string {
+	if c.label != "" {
+		return c.label
+	}
+	if c.vip.To4() == nil {
+		return "vipmgr6"
+	}
+	return "vipmgr"
+}
+
+func (c *IPConfiguration) checkLabel() error {
+	return CheckLabel(c.vip, c.iface.Name, c.label)
+}
+
+// CheckLabel fails if label cannot be the name of the address object of vip
+// on iface.
+func CheckLabel(vip net.IP, iface, label string) error {
+	if strings.Contains(label, "/") {
+		return fmt.Errorf("address object name %s must not contain a slash", label)
+	}
+	return nil
+}
+
+func (c *IPConfiguration) addrobj() string {
+	return c.iface.Name + "/" + c.Label()
+}
+
+// showAddressCommand lists the addresses of iface in parsable form.
+func showAddressCommand(iface string) (name string, args []string) {
+	return "ipadm", []string{"show-addr", "-p", "-o", "addrobj,addr,state", iface + "/"}
+}
+
+// listAddresses returns no addresses if iface does not exist (yet). It fails
+// if ipadm fails for any other reason or its output cannot be parsed, as the
+// addresses are unknown then.
+func listAddresses(c Commander, iface string) ([]interfaceAddress, error) {
+	name, args := showAddressCommand(iface)
+	output, stderr, exitCode, err := c.Run(context.Background(), name, args...)
+	if err != nil {
+		if exitCode > 0 && ClassifyCommandFailure(err, exitCode, stderr) == FailureDevice {
+			return nil, nil
+		}
+		class := commandFailed(name, err, exitCode, stderr)
+		return nil, &CommandError{Err: err, Output: strings.TrimSpace(string(stderr)), Class: class}
+	}
+
+	addresses, err := parseAddresses(output)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the addresses of %s: %w", iface, err)
+	}
+	return addresses, nil
+}
+
+// splitParsable splits a line of ipadm -p output. Colons within a field,
+// e.g. in IPv6 addresses, are escaped with a backslash.
+func splitParsable(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// parseAddresses extracts the addresses from the output of
+// ipadm show-addr -p -o addrobj,addr,state. The label of an address is the
+// name of its address object without the interface.
+func parseAddresses(output []byte) ([]interfaceAddress, error) {
+	var addresses []interfaceAddress
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := splitParsable(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		addrobj, addr, state := fields[0], fields[1], fields[2]
+		if addr == "" || addr == "?" {
+			// E.g. a DHCP address object without a lease
+			continue
+		}
+
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		var label string
+		if i := strings.Index(addrobj, "/"); i >= 0 {
+			label = addrobj[i+1:]
+		}
+		addresses = append(addresses, interfaceAddress{
+			ip:        ip,
+			prefix:    prefix,
+			label:     label,
+			tentative: state == "tentative",
+			dadFailed: state == "duplicate",
+		})
+	}
+	return addresses, nil
+}
+
+func (m *IPManager) runAddressConfiguration(ctx context.Context, a *IPConfiguration, action string) bool {
+	if action == "delete" {
+		// May also be an adopted address with another address object
+		return m.removeAddress(ctx, a.GetCIDR(), a.iface.Name)
+	}
+	return m.runIpadm(ctx, "create-addr", "-t", "-T", "static", "-a", a.GetCIDR(), a.addrobj())
+}
+
+// removeAddress deletes the address objects holding cidr on iface. Nothing
+// to delete counts as success.
+func (m *IPManager) removeAddress(ctx context.Context, cidr, iface string) bool {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		slog.Error("Invalid address", "vip", cidr, "error", err)
+		return false
+	}
+	prefix, _ := ipNet.Mask.Size()
+
+	addresses, err := listAddresses(m.Commander, iface)
+	if err != nil {
+		slog.Error("Cannot list addresses", "iface", iface, "error", err)
+		return false
+	}
+	ok := true
+	for _, addr := range addresses {
+		if !addr.ip.Equal(ip) || addr.prefix != prefix {
+			continue
+		}
+		if !m.runIpadm(ctx, "delete-addr", iface+"/"+addr.label) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (m *IPManager) runIpadm(ctx context.Context, args ...string) bool {
+	if skipDryRun("ipadm", args...) {
+		return true
+	}
+	_, stderr, exitCode, err := m.Commander.Run(ctx, "ipadm", args...)
+	if err != nil {
+		slog.Error("Error running ipadm "+strings.Join(args, " "), "error", err, "output", strings.TrimSpace(string(stderr)),
+			"failure_class", commandFailed("ipadm", err, exitCode, stderr))
+		return false
+	}
+	return true
+}
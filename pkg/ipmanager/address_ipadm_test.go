@@ -0,0 +1,77 @@
+//go:build solaris
+// +build solaris
+
+package ipmanager
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSplitParsable(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"net0/v4:192.168.1.10/24:ok", []string{"net0/v4", "192.168.1.10/24", "ok"}},
+		{`net0/v6:fe80\:\:8\:20ff\:fe12\:3456/10:ok`, []string{"net0/v6", "fe80::8:20ff:fe12:3456/10", "ok"}},
+		{"net0/dhcp::disabled", []string{"net0/dhcp", "", "disabled"}},
+		{"trailing backslash\\", []string{"trailing backslash\\"}},
+	}
+	for _, test := range tests {
+		got := splitParsable(test.line)
+		if len(got) != len(test.want) {
+			t.Errorf("splitParsable(%q) = %q, want %q", test.line, got, test.want)
+			continue
+		}
+		for i := range test.want {
+			if got[i] != test.want[i] {
+				t.Errorf("splitParsable(%q) = %q, want %q", test.line, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	// Output of ipadm show-addr -p -o addrobj,addr,state
+	output := []byte(`lo0/v4:127.0.0.1/8:ok
+net0/v4:110.0.0.1/24:ok
+net0/other:10.0.0.10/24:ok
+net0/vipmgr:10.0.0.1/24:ok
+net0/dhcp:?:disabled
+lo0/v6:\:\:1/128:ok
+net0/v6:fe80\:\:8\:20ff\:fe12\:3456/10:ok
+net0/vipmgr6:fd00\:\:10/64:tentative
+net0/dup:10.0.0.2/24:duplicate
+`)
+	want := []interfaceAddress{
+		{ip: net.ParseIP("127.0.0.1"), prefix: 8, label: "v4"},
+		{ip: net.ParseIP("110.0.0.1"), prefix: 24, label: "v4"},
+		{ip: net.ParseIP("10.0.0.10"), prefix: 24, label: "other"},
+		{ip: net.ParseIP("10.0.0.1"), prefix: 24, label: "vipmgr"},
+		{ip: net.ParseIP("::1"), prefix: 128, label: "v6"},
+		{ip: net.ParseIP("fe80::8:20ff:fe12:3456"), prefix: 10, label: "v6"},
+		{ip: net.ParseIP("fd00::10"), prefix: 64, label: "vipmgr6", tentative: true},
+		{ip: net.ParseIP("10.0.0.2"), prefix: 24, label: "dup", dadFailed: true},
+	}
+	got, err := parseAddresses(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseAddresses() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].ip.Equal(want[i].ip) || got[i].prefix != want[i].prefix || got[i].label != want[i].label ||
+			got[i].tentative != want[i].tentative || got[i].dadFailed != want[i].dadFailed {
+			t.Errorf("address %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	for _, invalid := range []string{"net0/v4:192.168.1.10/24", "net0/v4:192.168.1.300/24:ok", "net0/v4:fd00::10/64:ok"} {
+		if _, err := parseAddresses([]byte(invalid + "\n")); err == nil {
+			t.Errorf("parseAddresses(%q) did not fail", invalid)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ipmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// Announcements are repeated, as single packets get lost
+	announceCount    = 3
+	announceInterval = time.Second
+	// Upper bound for one burst, sending an unsolicited neighbor
+	// advertisement waits for duplicate address detection
+	announceDeadline = 10 * time.Second
+)
+
+// announcer sends the announcements for newly configured addresses in the
+// background, so they do not delay configuring the other addresses and
+// reconciliation in general. Requests for an address while a burst is
+// pending are coalesced, so flapping does not stack up bursts.
+type announcer struct {
+	announce func(ctx context.Context, a *IPConfiguration) error
+	// Called with the result of the first announcement of a burst
+	report func(a *IPConfiguration, err error)
+	// Starts the step of a burst, from the first request until the first
+	// round was sent
+	startStep func(name string) *step
+
+	lock    sync.Mutex
+	step    *step
+	pending map[*IPConfiguration]bool
+	// Addresses that are still wanted, a burst stops for released ones
+	active  map[*IPConfiguration]bool
+	trigger chan struct{}
+}
+
+func newAnnouncer(announce func(ctx context.Context, a *IPConfiguration) error, report func(a *IPConfiguration, err error),
+	startStep func(name string) *step) *announcer {
+	return &announcer{
+		announce:  announce,
+		report:    report,
+		startStep: startStep,
+		pending:   make(map[*IPConfiguration]bool),
+		active:    make(map[*IPConfiguration]bool),
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Request schedules a burst of announcements for a.
+func (an *announcer) Request(a *IPConfiguration) {
+	an.lock.Lock()
+	an.pending[a] = true
+	an.active[a] = true
+	if an.step == nil {
+		an.step = an.startStep("announce")
+	}
+	an.lock.Unlock()
+
+	select {
+	case an.trigger <- struct{}{}:
+	default:
+		// Already triggered
+	}
+}
+
+// Cancel stops announcing a, e.g. because it was removed again.
+func (an *announcer) Cancel(a *IPConfiguration) {
+	an.lock.Lock()
+	delete(an.pending, a)
+	delete(an.active, a)
+	an.lock.Unlock()
+}
+
+func (an *announcer) isActive(a *IPConfiguration) bool {
+	an.lock.Lock()
+	defer an.lock.Unlock()
+	return an.active[a]
+}
+
+func (an *announcer) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-an.trigger:
+		}
+
+		an.lock.Lock()
+		addresses := make([]*IPConfiguration, 0, len(an.pending))
+		for a := range an.pending {
+			addresses = append(addresses, a)
+		}
+		an.pending = make(map[*IPConfiguration]bool)
+		step := an.step
+		an.step = nil
+		an.lock.Unlock()
+
+		an.burst(ctx, addresses, step)
+	}
+}
+
+func (an *announcer) burst(ctx context.Context, addresses []*IPConfiguration, step *step) {
+	ctx, cancel := context.WithTimeout(ctx, announceDeadline)
+	defer cancel()
+	// Ended after the first round, unless the burst is cut short
+	defer func() { step.End(ctx.Err()) }()
+
+	for i := 0; i < announceCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(announceInterval):
+			}
+		}
+		for _, a := range addresses {
+			if an.isActive(a) {
+				// Errors are logged by announce, a missed announcement
+				// only delays clients noticing the move
+				err := an.announce(ctx, a)
+				if i == 0 {
+					an.report(a, err)
+				}
+			}
+		}
+		if i == 0 {
+			step.SetAttributes("addresses", len(addresses))
+			step.End(nil)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package ipmanager
+
+import (
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	arp "github.com/mdlayher/arp"
+)
+
+var splitBrainDetections = metrics.NewCounter("vip_manager_split_brain_detections_total",
+	"Number of times another host still answered ARP for the virtual IP before takeover.")
+
+// ArpProbe checks that no other host answers for the virtual IP before we
+// take it over, e.g. an old primary that is hung but not demoted.
+type ArpProbe struct {
+	timeout       time.Duration
+	retryInterval time.Duration
+	// Set to take over once without probing
+	force int32
+}
+
+// NewArpProbe waits timeout for answers to a probe and probes again every
+// retryInterval while another host answers.
+func NewArpProbe(timeout, retryInterval time.Duration) *ArpProbe {
+	return &ArpProbe{timeout: timeout, retryInterval: retryInterval}
+}
+
+// Force makes the next takeover skip the probe, for emergencies where the
+// other host is known to be dead.
+func (p *ArpProbe) Force() {
+	atomic.StoreInt32(&p.force, 1)
+}
+
+// probeARP sends an ARP probe for vip and returns the MAC address of another
+// host answering for it, or nil if there is none. Packets from one of our
+// own MAC addresses are ignored.
+func probeARP(iface *net.Interface, vip net.IP, timeout time.Duration, own ...net.HardwareAddr) (net.HardwareAddr, error) {
+	c, err := arp.Dial(iface)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	// Sender address 0.0.0.0, so nobody updates their cache from the probe.
+	// Like arping, the target MAC is broadcast, some kernels ignore probes
+	// with an all zero target MAC.
+	probe, err := arp.NewPacket(arp.OperationRequest, iface.HardwareAddr, net.IPv4zero,
+		ethernetBroadcast, vip)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.WriteTo(probe, ethernetBroadcast); err != nil {
+		return nil, err
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	for {
+		p, _, err := c.Read()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !p.SenderIP.Equal(vip) || isOwnMAC(p.SenderHardwareAddr, own) {
+			continue
+		}
+		return p.SenderHardwareAddr, nil
+	}
+}
+
+func isOwnMAC(mac net.HardwareAddr, own []net.HardwareAddr) bool {
+	for _, o := range own {
+		if mac.String() == o.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// splitBrainDetected probes the IPv4 addresses we are about to configure.
+// IPv6 addresses are covered by the duplicate address detection of the
+// kernel.
+func (m *IPManager) splitBrainDetected(states []AddressState) bool {
+	if m.ArpProbe == nil || m.Carp != nil || m.ProxyArp != nil {
+		return false
+	}
+	if atomic.SwapInt32(&m.ArpProbe.force, 0) == 1 {
+		slog.Warn("Forced takeover, skipping the ARP probe", "vip", m.cidrs())
+		return false
+	}
+
+	for i, a := range m.addresses {
+		if a.vip.To4() == nil || states[i].Present {
+			continue
+		}
+
+		iface := &a.iface
+		own := []net.HardwareAddr{a.iface.HardwareAddr}
+		if m.Macvlan != nil {
+			// The macvlan does not exist yet, probe on its parent
+			parent, err := net.InterfaceByName(m.Macvlan.parent)
+			if err != nil {
+				slog.Error("Cannot send ARP probe", "vip", a.vip, "error", err)
+				continue
+			}
+			iface = parent
+			own = append(own, parent.HardwareAddr)
+		}
+
+		mac, err := probeARP(iface, a.vip, m.ArpProbe.timeout, own...)
+		if err != nil {
+			slog.Error("Cannot send ARP probe", "vip", a.vip, "iface", iface.Name, "error", err)
+			continue
+		}
+		if mac != nil {
+			splitBrainDetections.Inc()
+			slog.Error("Possible split brain: another host still answers for the virtual IP, not taking it over",
+				"vip", a.vip, "mac", mac, "iface", iface.Name, "retry_in", m.ArpProbe.retryInterval)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package ipmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ArpSysctls sets arp_announce and arp_ignore on the interfaces of the
+// virtual IP while we hold it, so that multi-homed hosts neither answer ARP
+// for it on the wrong interface nor use it as ARP source elsewhere. The
+// values found before are restored on release.
+type ArpSysctls struct {
+	ifaces []string
+	values map[string]string
+	// Values before we changed them, per interface and setting
+	saved map[string]map[string]string
+}
+
+// NewArpSysctls sets arp_announce and arp_ignore to the given values on the
+// interfaces of addresses.
+func NewArpSysctls(addresses []*IPConfiguration, announce, ignore int) *ArpSysctls {
+	return newArpSysctls(addresses, map[string]string{
+		"arp_announce": strconv.Itoa(announce),
+		"arp_ignore":   strconv.Itoa(ignore),
+	})
+}
+
+// newArpSysctls manages arbitrary IPv4 settings of the interfaces, values
+// maps their names to the values to set.
+func newArpSysctls(addresses []*IPConfiguration, values map[string]string) *ArpSysctls {
+	s := &ArpSysctls{
+		values: values,
+		saved:  make(map[string]map[string]string),
+	}
+	for _, a := range addresses {
+		if a.vip.To4() == nil || s.hasIface(a.iface.Name) {
+			continue
+		}
+		s.ifaces = append(s.ifaces, a.iface.Name)
+	}
+	return s
+}
+
+func (s *ArpSysctls) hasIface(name string) bool {
+	for _, iface := range s.ifaces {
+		if iface == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sysctlPath(iface, name string) string {
+	return filepath.Join("/proc/sys/net/ipv4/conf", iface, name)
+}
+
+// Apply remembers the current values and sets ours. Values are only saved
+// once, so applying again after a failed attempt does not overwrite the
+// originals with our own settings.
+func (s *ArpSysctls) Apply() error {
+	for _, iface := range s.ifaces {
+		if s.saved[iface] == nil {
+			s.saved[iface] = make(map[string]string)
+		}
+		for name, value := range s.values {
+			if _, ok := s.saved[iface][name]; !ok {
+				old, err := ioutil.ReadFile(sysctlPath(iface, name))
+				if err != nil {
+					return err
+				}
+				s.saved[iface][name] = strings.TrimSpace(string(old))
+			}
+			if err := writeSysctl(iface, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore sets the saved values again. Nothing is done for interfaces we
+// did not change.
+func (s *ArpSysctls) Restore() error {
+	var firstErr error
+	for iface, saved := range s.saved {
+		for name, value := range saved {
+			if err := writeSysctl(iface, name, value); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			delete(saved, name)
+		}
+		if len(saved) == 0 {
+			delete(s.saved, iface)
+		}
+	}
+	return firstErr
+}
+
+func (s *ArpSysctls) Status() string {
+	if len(s.saved) == 0 {
+		return "unchanged"
+	}
+	return "set"
+}
+
+// writeSysctl writes the value directly, or through sysctl and the command
+// prefix when we are not allowed to.
+func writeSysctl(iface, name, value string) error {
+	// sysctl uses slashes for the dots in e.g. VLAN interface names
+	setting := fmt.Sprintf("net.ipv4.conf.%s.%s=%s", strings.Replace(iface, ".", "/", -1), name, value)
+	if skipDryRun("sysctl", "-w", setting) {
+		return nil
+	}
+
+	err := ioutil.WriteFile(sysctlPath(iface, name), []byte(value), 0644)
+	if err == nil {
+		return nil
+	}
+	if !os.IsPermission(err) {
+		return err
+	}
+	if len(CommandPrefix) == 0 {
+		return fmt.Errorf("not permitted to set %s, run as root or use -command-prefix", setting)
+	}
+
+	slog.Info("Setting " + setting)
+	output, err := commandCombinedOutput(newCommand("sysctl", "-w", setting))
+	if err != nil {
+		return fmt.Errorf("sysctl -w %s: %s: %s", setting, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package ipmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+var auditLogErrors = metrics.NewCounter("vip_manager_audit_log_errors_total",
+	"Number of audit log entries that could not be written.")
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	VIP   string    `json:"vip"`
+	// Only for transitions
+	OldState     *bool   `json:"old_state,omitempty"`
+	NewState     *bool   `json:"new_state,omitempty"`
+	TriggerKey   string  `json:"trigger_key,omitempty"`
+	TriggerValue *string `json:"trigger_value,omitempty"`
+	// Only for changes of the leader key
+	PreviousValue *string `json:"previous_value,omitempty"`
+	Revision      uint64  `json:"revision,omitempty"`
+	// Seconds from the state change to the end of the operation
+	Duration float64 `json:"duration_seconds,omitempty"`
+	// Only for announcements, empty if it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// AuditLog appends a JSON line for every transition of the virtual IP and
+// every announcement of it to a file, apart from the operational log. The
+// file is rotated by size, keeping a number of old files as path.1 etc.
+type AuditLog struct {
+	path      string
+	maxSize   int64
+	retention int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewAuditLog appends to the file at path, which is rotated when it would
+// grow beyond maxSize bytes. retention rotated files are kept.
+func NewAuditLog(path string, maxSize int64, retention int) (*AuditLog, error) {
+	l := &AuditLog{path: path, maxSize: maxSize, retention: retention}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+func (l *AuditLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+	for i := l.retention - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.retention > 0 {
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+	return l.open()
+}
+
+func (l *AuditLog) write(e auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		// Rotating failed before, try again
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Record writes e. Failures are logged and counted, but never stop the
+// failover.
+func (l *AuditLog) Record(e auditEntry) {
+	e.Time = time.Now()
+	if err := l.write(e); err != nil {
+		auditLogErrors.Inc()
+		slog.Error("Cannot write audit log", "audit_log", l.path, "error", err)
+	}
+}
+
+func (m *IPManager) auditTransition(state bool, duration time.Duration) {
+	if m.AuditLog == nil {
+		return
+	}
+	oldState := !state
+	key, value, _ := checker.LastValue()
+	operation := "release"
+	if state {
+		operation = "acquire"
+	}
+	m.AuditLog.Record(auditEntry{
+		Event:        operation,
+		VIP:          m.cidrs(),
+		OldState:     &oldState,
+		NewState:     &state,
+		TriggerKey:   key,
+		TriggerValue: &value,
+		Duration:     duration.Seconds(),
+	})
+}
+
+func (m *IPManager) auditAnnouncement(a *IPConfiguration, err error) {
+	if m.AuditLog == nil {
+		return
+	}
+	e := auditEntry{Event: "announce", VIP: a.GetCIDR()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	m.AuditLog.Record(e)
+}
+
+func (m *IPManager) auditLeaderChange(key, previous, value string, revision uint64) {
+	if m.AuditLog == nil {
+		return
+	}
+	m.AuditLog.Record(auditEntry{
+		Event:         "leader_changed",
+		VIP:           m.cidrs(),
+		TriggerKey:    key,
+		TriggerValue:  &value,
+		PreviousValue: &previous,
+		Revision:      revision,
+	})
+}
@@ -0,0 +1,46 @@
+package ipmanager
+
+import (
+	"time"
+)
+
+// Backoff spaces out retries of a failing operation exponentially.
+type Backoff struct {
+	min time.Duration
+	max time.Duration
+
+	current     time.Duration
+	nextAttempt time.Time
+}
+
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{min: min, max: max}
+}
+
+// Waiting reports whether the next attempt has to be postponed still.
+func (b *Backoff) Waiting() bool {
+	return time.Now().Before(b.nextAttempt)
+}
+
+// Failed records a failed attempt and returns how long to wait before the
+// next one. retry is called once that time has passed.
+func (b *Backoff) Failed(retry func()) time.Duration {
+	b.current *= 2
+	if b.current == 0 {
+		b.current = b.min
+	} else if b.current > b.max {
+		b.current = b.max
+	}
+	b.nextAttempt = time.Now().Add(b.current)
+	time.AfterFunc(b.current, retry)
+	return b.current
+}
+
+func (b *Backoff) Current() time.Duration {
+	return b.current
+}
+
+func (b *Backoff) Reset() {
+	b.current = 0
+	b.nextAttempt = time.Time{}
+}
@@ -0,0 +1,94 @@
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Carp lets the kernel move the address with CARP, as is usual on OpenBSD.
+// Instead of adding the address, the advskew of the carp interface is
+// lowered while we are leader, so this node wins the CARP election.
+type Carp struct {
+	iface       string
+	leaderSkew  int
+	standbySkew int
+	lastState   string
+	lastSkew    int
+}
+
+// NewCarp sets the advskew of the carp interface iface to leaderSkew while
+// leader and to standbySkew otherwise.
+func NewCarp(iface string, leaderSkew, standbySkew int) (*Carp, error) {
+	for _, skew := range []int{leaderSkew, standbySkew} {
+		if skew < 0 || skew > 254 {
+			return nil, fmt.Errorf("invalid advskew %d, has to be between 0 and 254", skew)
+		}
+	}
+	if leaderSkew >= standbySkew {
+		return nil, fmt.Errorf("advskew of the leader %d has to be lower than %d of the standby", leaderSkew, standbySkew)
+	}
+	return &Carp{iface: iface, leaderSkew: leaderSkew, standbySkew: standbySkew, lastSkew: -1}, nil
+}
+
+// parseCarpStatus extracts the state and advskew from the ifconfig output
+// of a carp interface, e.g. "carp: MASTER carpdev em0 vhid 1 advbase 1 advskew 0".
+func parseCarpStatus(output string) (string, int, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "carp:" {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i++ {
+			if fields[i] == "advskew" {
+				skew, err := strconv.Atoi(fields[i+1])
+				return fields[1], skew, err
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("no carp status found")
+}
+
+// Leading reports whether our advskew is the one of the leader. Whether
+// CARP already made us MASTER is only informational, the election is up to
+// the kernel.
+func (c *Carp) Leading() bool {
+	output, err := commandOutput(newCommand("ifconfig", c.iface))
+	if err != nil {
+		slog.Error("Cannot query carp interface", "iface", c.iface, "error", err)
+		return false
+	}
+	c.lastState, c.lastSkew, err = parseCarpStatus(string(output))
+	if err != nil {
+		slog.Error("Cannot parse carp status", "iface", c.iface, "error", err)
+		return false
+	}
+	return c.lastSkew == c.leaderSkew
+}
+
+func (c *Carp) Status() string {
+	if c.lastState == "" {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s with advskew %d", c.lastState, c.lastSkew)
+}
+
+func (c *Carp) Set(ctx context.Context, leader bool) bool {
+	skew := c.standbySkew
+	if leader {
+		skew = c.leaderSkew
+	}
+	args := []string{c.iface, "advskew", strconv.Itoa(skew)}
+	if skipDryRun("ifconfig", args...) {
+		return true
+	}
+	slog.Info("Setting advskew", "iface", c.iface, "advskew", skew)
+	output, err := commandCombinedOutput(newCommandContext(ctx, "ifconfig", args...))
+	if err != nil {
+		slog.Error("Error running ifconfig "+strings.Join(args, " "), "error", err, "output", strings.TrimSpace(string(output)))
+		return false
+	}
+	return true
+}
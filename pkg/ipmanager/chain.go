@@ -0,0 +1,151 @@
+package ipmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ChainLink is a manager in a Chain, with the name its health is reported
+// under.
+type ChainLink struct {
+	Name    string
+	Manager Manager
+}
+
+// ManagerHealth is the health of one manager of a Chain in Status.
+type ManagerHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Chain drives several managers with one stream of desired states, e.g. the
+// local address, a DNS record and a cloud API. Each manager follows the
+// states on its own, with its own retries and its own check of the actual
+// state, so one that is slow or failing does not hold up the others. On
+// exit they are released one after the other, in reverse order.
+type Chain struct {
+	links []ChainLink
+	ready chan struct{}
+}
+
+var _ Manager = (*Chain)(nil)
+
+// NewChain drives links in this order.
+func NewChain(links ...ChainLink) *Chain {
+	c := &Chain{links: links, ready: make(chan struct{})}
+	go func() {
+		for _, l := range c.links {
+			<-l.Manager.Ready()
+		}
+		close(c.ready)
+	}()
+	return c
+}
+
+// SyncStates passes every state received on states to all managers until
+// ctx is done, then stops them in reverse order.
+func (c *Chain) SyncStates(ctx context.Context, states <-chan bool) {
+	type running struct {
+		states chan bool
+		stop   context.CancelFunc
+		done   chan struct{}
+	}
+	links := make([]running, len(c.links))
+	for i, l := range c.links {
+		// Not derived from ctx, each manager is only stopped once the ones
+		// after it are released
+		linkCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+		links[i] = running{states: make(chan bool, 1), stop: stop, done: make(chan struct{})}
+		go func(l ChainLink, r running) {
+			defer close(r.done)
+			defer handlePanic()
+			l.Manager.SyncStates(linkCtx, r.states)
+		}(l, links[i])
+	}
+
+	for {
+		select {
+		case state := <-states:
+			for _, r := range links {
+				// A manager that is busy only gets the latest state
+				select {
+				case r.states <- state:
+				default:
+					select {
+					case <-r.states:
+					default:
+					}
+					r.states <- state
+				}
+			}
+		case <-ctx.Done():
+			for i := len(links) - 1; i >= 0; i-- {
+				started := time.Now()
+				links[i].stop()
+				<-links[i].done
+				slog.Debug("Manager stopped", "manager", c.links[i].Name, "duration", time.Since(started))
+			}
+			return
+		}
+	}
+}
+
+// Ready is closed once all managers received the first state.
+func (c *Chain) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Health reports the problems of all managers, or nil.
+func (c *Chain) Health(dcsThreshold time.Duration) error {
+	var errs []error
+	for _, l := range c.links {
+		if err := l.Manager.Health(dcsThreshold); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", l.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status is the status of the first manager, with the actual state and
+// health of all of them.
+func (c *Chain) Status(dcsThreshold time.Duration) Status {
+	var s Status
+	for i, l := range c.links {
+		ls := l.Manager.Status(dcsThreshold)
+		if i == 0 {
+			s = ls
+			s.Managers = nil
+		} else {
+			s.Healthy = s.Healthy && ls.Healthy
+			s.ActualState = s.ActualState && ls.ActualState
+			s.Addresses = append(s.Addresses, ls.Addresses...)
+		}
+		h := ManagerHealth{Name: l.Name, Healthy: ls.Healthy}
+		if err := l.Manager.Health(dcsThreshold); err != nil {
+			h.Error = err.Error()
+		}
+		s.Managers = append(s.Managers, h)
+	}
+	return s
+}
+
+// SetMaintenance enters or leaves maintenance mode in all managers.
+func (c *Chain) SetMaintenance(active bool) {
+	for _, l := range c.links {
+		l.Manager.SetMaintenance(active)
+	}
+}
+
+// Maintenance reports whether any manager is in maintenance mode.
+func (c *Chain) Maintenance() bool {
+	for _, l := range c.links {
+		if l.Manager.Maintenance() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package ipmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+)
+
+// CommandPrefix is prepended to every command that needs network
+// privileges, e.g. "sudo -n" when not running as root.
+var CommandPrefix []string
+
+// DryRun only logs the commands that would change the system.
+var DryRun bool
+
+func newCommand(name string, args ...string) *exec.Cmd {
+	return newCommandContext(context.Background(), name, args...)
+}
+
+// newCommandContext is newCommand with a command that is killed once ctx is
+// done.
+func newCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	argv := make([]string, 0, len(CommandPrefix)+len(args)+1)
+	argv = append(argv, CommandPrefix...)
+	argv = append(argv, name)
+	argv = append(argv, args...)
+
+	slog.Debug("Running " + strings.Join(argv, " "))
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// traceCommand logs cmd with its results at trace level.
+func traceCommand(cmd *exec.Cmd, stdout, stderr []byte, err error) {
+	if !tracelog.Enabled() {
+		return
+	}
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	if exit, ok := err.(*exec.ExitError); ok && stderr == nil {
+		stderr = exit.Stderr
+	}
+	tracelog.Log("Command finished", "argv", cmd.Args, "exit_code", exitCode, "error", err,
+		"stdout", string(stdout), "stderr", string(stderr))
+}
+
+// commandOutput is cmd.Output, traced.
+func commandOutput(cmd *exec.Cmd) ([]byte, error) {
+	output, err := cmd.Output()
+	traceCommand(cmd, output, nil, err)
+	return output, err
+}
+
+// commandCombinedOutput is cmd.CombinedOutput, traced. The output is logged
+// as stdout.
+func commandCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	output, err := cmd.CombinedOutput()
+	traceCommand(cmd, output, nil, err)
+	return output, err
+}
+
+// Commander runs the commands that query and change the addresses, so that
+// they can be replaced, e.g. in tests. exitCode is -1 if the command did not
+// run to completion, err is nil only if it exited with 0.
+type Commander interface {
+	Run(ctx context.Context, name string, args ...string) (stdout, stderr []byte, exitCode int, err error)
+}
+
+// ExecCommander runs commands with os/exec, prefixed with CommandPrefix.
+// The command is killed once ctx is done.
+type ExecCommander struct{}
+
+func (ExecCommander) Run(ctx context.Context, name string, args ...string) (stdout, stderr []byte, exitCode int, err error) {
+	var out, errOut bytes.Buffer
+	cmd := newCommandContext(ctx, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	traceCommand(cmd, out.Bytes(), errOut.Bytes(), err)
+	exitCode = -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	return out.Bytes(), errOut.Bytes(), exitCode, err
+}
+
+// skipDryRun logs a command that would change the system and reports whether
+// it has to be skipped because we are running in dry-run mode.
+func skipDryRun(name string, args ...string) bool {
+	if !DryRun {
+		return false
+	}
+	slog.Info("Dry run, not running " + name + " " + strings.Join(args, " "))
+	return true
+}
+
+// CheckCommand lists the addresses of iface, so that a missing command or a
+// misconfigured prefix is noticed at startup and not only when the address
+// has to be configured. The error includes the output of the command.
+func CheckCommand(iface string) error {
+	name, args := showAddressCommand(iface)
+	_, stderr, exitCode, err := ExecCommander{}.Run(context.Background(), name, args...)
+	if err != nil {
+		class := commandFailed(name, err, exitCode, stderr)
+		return &CommandError{Err: err, Output: strings.TrimSpace(string(stderr)), Class: class}
+	}
+	return nil
+}
+
+// CommandError is a failed command with its output.
+type CommandError struct {
+	Err    error
+	Output string
+	// See ClassifyCommandFailure
+	Class string
+}
+
+func (e *CommandError) Error() string {
+	if e.Output == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Output)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// withGracePeriod returns a context that is cancelled grace after parent is
+// done, so running operations can still finish on shutdown.
+func withGracePeriod(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
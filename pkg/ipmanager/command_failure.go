@@ -0,0 +1,84 @@
+package ipmanager
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// Classes of failed commands, the same for every address backend so that
+// alerts do not depend on it
+const (
+	FailureMissing    = "missing"
+	FailurePermission = "permission"
+	FailureDevice     = "device"
+	FailureTimeout    = "timeout"
+	FailureUnknown    = "unknown"
+)
+
+var (
+	commandFailures = metrics.NewCounterVec("vip_manager_command_failures_total",
+		"Number of failed commands that query or change the virtual IP, by class: missing, permission, device, timeout or unknown.",
+		"command", "class")
+	lastCommandFailure = metrics.NewGaugeVec("vip_manager_command_last_failure_timestamp_seconds",
+		"Unix time of the last failed command that queries or changes the virtual IP.", "command")
+)
+
+// Parts of the messages of ip, ipadm and sudo for each class, in lower case
+var failureMessages = []struct {
+	class string
+	parts []string
+}{
+	{FailureMissing, []string{"command not found", "no such file or directory"}},
+	{FailurePermission, []string{"operation not permitted", "permission denied", "a password is required", "insufficient privileges", "not owner"}},
+	{FailureDevice, []string{"cannot find device", "does not exist", "no such device", "interface not found", "unknown interface"}},
+}
+
+// ClassifyCommandFailure tells why a command failed, from the error, exit
+// code and error output that a Commander returns. Commanders that do not run
+// commands, e.g. one that uses netlink directly, classify their errors with
+// it as well.
+func ClassifyCommandFailure(err error, exitCode int, stderr []byte) string {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		return FailureMissing
+	case errors.Is(err, fs.ErrPermission):
+		return FailurePermission
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return FailureTimeout
+	case errors.As(err, &exitErr) && exitCode < 0:
+		// Killed, which only happens once its context is done
+		return FailureTimeout
+	case exitCode == 126:
+		return FailurePermission
+	case exitCode == 127:
+		return FailureMissing
+	}
+	output := strings.ToLower(string(stderr))
+	if err != nil {
+		output += " " + strings.ToLower(err.Error())
+	}
+	for _, m := range failureMessages {
+		for _, part := range m.parts {
+			if strings.Contains(output, part) {
+				return m.class
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// commandFailed counts a failure of command and returns its class for the
+// log.
+func commandFailed(command string, err error, exitCode int, stderr []byte) string {
+	class := ClassifyCommandFailure(err, exitCode, stderr)
+	commandFailures.With(command, class).Inc()
+	lastCommandFailure.With(command).Set(float64(time.Now().UnixNano()) / 1e9)
+	return class
+}
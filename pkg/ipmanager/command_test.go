@@ -0,0 +1,46 @@
+package ipmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecCommanderCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, stderr, exitCode, err := ExecCommander{}.Run(ctx, "sleep", "10")
+	if took := time.Since(started); took > 5*time.Second {
+		t.Fatalf("the command ran for %s after the context was done", took)
+	}
+	if err == nil || exitCode != -1 {
+		t.Fatalf("Run() = exit code %d, error %v, want a killed command", exitCode, err)
+	}
+	if class := ClassifyCommandFailure(err, exitCode, stderr); class != FailureTimeout {
+		t.Errorf("the killed command is classified as %s, want %s", class, FailureTimeout)
+	}
+}
+
+func TestWithGracePeriod(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := withGracePeriod(parent, grace)
+	defer cancel()
+
+	result := make(chan time.Time, 1)
+	go func() {
+		ExecCommander{}.Run(ctx, "sleep", "10")
+		result <- time.Now()
+	}()
+	cancelled := time.Now()
+	cancelParent()
+	select {
+	case finished := <-result:
+		if finished.Sub(cancelled) < grace {
+			t.Errorf("the command was killed %s after the parent was done, before the grace period of %s", finished.Sub(cancelled), grace)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not killed after the grace period")
+	}
+}
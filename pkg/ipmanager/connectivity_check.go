@@ -0,0 +1,145 @@
+package ipmanager
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ConnectivityCheck verifies that the virtual IP is actually reachable after
+// it was configured, by pinging a target or connecting to it over TCP with
+// the virtual IP as source address.
+type ConnectivityCheck struct {
+	// host for an ICMP echo, host:port for a TCP connect
+	target   string
+	timeout  time.Duration
+	interval time.Duration
+
+	result  string
+	lastRun time.Time
+}
+
+// NewConnectivityCheck pings target, or connects to it if it is host:port,
+// every interval while we hold the virtual IP.
+func NewConnectivityCheck(target string, timeout, interval time.Duration) *ConnectivityCheck {
+	return &ConnectivityCheck{
+		target:   target,
+		timeout:  timeout,
+		interval: interval,
+		result:   "not run",
+	}
+}
+
+// Due reports whether the check should be run again.
+func (c *ConnectivityCheck) Due() bool {
+	return time.Since(c.lastRun) >= c.interval
+}
+
+// Invalidate makes the check run on the next opportunity, e.g. because the
+// address was just configured.
+func (c *ConnectivityCheck) Invalidate() {
+	c.lastRun = time.Time{}
+}
+
+func (c *ConnectivityCheck) Run(source net.IP) error {
+	c.lastRun = time.Now()
+
+	var err error
+	if _, _, splitErr := net.SplitHostPort(c.target); splitErr == nil {
+		err = c.connect(source)
+	} else {
+		err = c.ping(source)
+	}
+
+	if err != nil {
+		c.result = fmt.Sprintf("failed (%s)", err)
+	} else {
+		c.result = "passed"
+	}
+	return err
+}
+
+// IPv6Target reports whether the target is an IPv6 address, so the check is
+// run from the IPv6 virtual IP. Host names are checked from the first address.
+func (c *ConnectivityCheck) IPv6Target() bool {
+	host := c.target
+	if h, _, err := net.SplitHostPort(c.target); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+func (c *ConnectivityCheck) Status() string {
+	return c.result
+}
+
+func (c *ConnectivityCheck) connect(source net.IP) error {
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: source},
+		Timeout:   c.timeout,
+	}
+	conn, err := dialer.Dial("tcp", c.target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *ConnectivityCheck) ping(source net.IP) error {
+	network, proto := "ip4:icmp", 1
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	var replyType icmp.Type = ipv4.ICMPTypeEchoReply
+	if source.To4() == nil {
+		network, proto = "ip6:ipv6-icmp", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	dst, err := net.ResolveIPAddr(network[:3], c.target)
+	if err != nil {
+		return err
+	}
+
+	conn, err := icmp.ListenPacket(network, source.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id := os.Getpid() & 0xffff
+	msg := icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("vip-manager")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(c.timeout))
+	reply := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(reply)
+		if err != nil {
+			return err
+		}
+		if peer.String() != dst.String() {
+			continue
+		}
+		parsed, err := icmp.ParseMessage(proto, reply[:n])
+		if err != nil || parsed.Type != replyType {
+			continue
+		}
+		if echo, ok := parsed.Body.(*icmp.Echo); ok && echo.ID == id {
+			return nil
+		}
+	}
+}
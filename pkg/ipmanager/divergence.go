@@ -0,0 +1,83 @@
+package ipmanager
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// The desired state the apply loop works towards only changes once the
+// delays before acquiring and releasing and the grace period are over, so
+// these planned waits never count as divergence. Neither do maintenance
+// mode and the startup hold-off, where the state is left alone on purpose.
+
+var divergenceAlerts = metrics.NewCounter("vip_manager_state_divergence_alerts_total",
+	"Number of times the virtual IP was not in the desired state for longer than -divergence-threshold.")
+
+// Unix time in nanoseconds since when the actual state differs from the
+// desired state, 0 while they agree
+var divergedSince int64
+
+func init() {
+	metrics.NewGaugeFunc("vip_manager_state_divergence_seconds",
+		"Seconds the virtual IP has not been in the desired state, 0 while it is. Intentional delays do not count.",
+		func() float64 {
+			since := atomic.LoadInt64(&divergedSince)
+			if since == 0 {
+				return 0
+			}
+			return time.Since(time.Unix(0, since)).Seconds()
+		})
+}
+
+// trackDivergence follows how long the actual state differs from the
+// desired state and warns once when that exceeds DivergenceThreshold, and
+// once more when it is over. Only called by the apply loop.
+func (m *IPManager) trackDivergence(actualStates []AddressState, desiredState, intentional bool) {
+	diverged := !m.allInSync(actualStates, desiredState)
+	if !diverged || intentional {
+		if !m.divergedSince.IsZero() {
+			m.endDivergence(intentional)
+		}
+		return
+	}
+
+	if m.divergedSince.IsZero() {
+		m.divergedSince = time.Now()
+		atomic.StoreInt64(&divergedSince, m.divergedSince.UnixNano())
+		if m.DivergenceThreshold > 0 {
+			// The loop may be waiting for the backoff or a recheck then
+			m.divergenceTimer = time.AfterFunc(m.DivergenceThreshold, m.recheck.Broadcast)
+		}
+		return
+	}
+	duration := time.Since(m.divergedSince)
+	if m.DivergenceThreshold > 0 && duration >= m.DivergenceThreshold && !m.divergenceAlerted {
+		m.divergenceAlerted = true
+		divergenceAlerts.Inc()
+		slog.Warn("Virtual IP is not in the desired state for too long", "vip", m.cidrs(), "desired", desiredState,
+			"actual", allPresent(actualStates), "duration", duration.Round(time.Millisecond), "threshold", m.DivergenceThreshold)
+	}
+}
+
+// endDivergence stops following a divergence, because the states agree
+// again or the difference became intentional.
+func (m *IPManager) endDivergence(intentional bool) {
+	if m.divergenceAlerted {
+		reason := "in sync"
+		if intentional {
+			reason = "maintenance or startup hold-off"
+		}
+		slog.Warn("Virtual IP is no longer diverging from the desired state", "vip", m.cidrs(),
+			"duration", time.Since(m.divergedSince).Round(time.Millisecond), "reason", reason)
+	}
+	if m.divergenceTimer != nil {
+		m.divergenceTimer.Stop()
+		m.divergenceTimer = nil
+	}
+	m.divergedSince = time.Time{}
+	m.divergenceAlerted = false
+	atomic.StoreInt64(&divergedSince, 0)
+}
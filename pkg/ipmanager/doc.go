@@ -0,0 +1,9 @@
+// Package ipmanager adds and removes virtual IPs following the desired
+// state reported by a leader checker, e.g. to move a virtual IP along with
+// the Patroni leader.
+//
+// A program creates an IPConfiguration for every virtual IP, passes them
+// with the optional parts in ManagerOptions to NewIPManager and runs
+// SyncStates with the states of a checker.LeaderChecker. CommandPrefix and
+// DryRun apply to all managers.
+package ipmanager
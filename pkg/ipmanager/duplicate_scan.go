@@ -0,0 +1,105 @@
+package ipmanager
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// How long the reaction to a duplicate address may run
+const duplicateReactionTimeout = 30 * time.Second
+
+var duplicateDetections = metrics.NewCounter("vip_manager_duplicate_address_detections_total",
+	"Number of times another host answered ARP for the virtual IP while this node held it.")
+
+// DuplicateScan probes for other hosts answering for the virtual IP while
+// we hold it, e.g. an old primary that was partitioned and added it again.
+// Only IPv4 addresses are probed, and none in CARP or proxy ARP mode or on
+// a loopback interface, e.g. for BGP, where nobody else answers anyway. Not
+// supported where we cannot send ARP either.
+type DuplicateScan struct {
+	interval time.Duration
+	timeout  time.Duration
+	// Run with VIP_ADDRESS, VIP_IFACE and VIP_MAC when a duplicate is found
+	reaction string
+
+	lastRun time.Time
+}
+
+// NewDuplicateScan probes every interval, waiting timeout for answers.
+// reaction may be empty.
+func NewDuplicateScan(interval, timeout time.Duration, reaction string) *DuplicateScan {
+	return &DuplicateScan{interval: interval, timeout: timeout, reaction: reaction}
+}
+
+// Due reports whether the scan should be run again.
+func (s *DuplicateScan) Due() bool {
+	return time.Since(s.lastRun) >= s.interval
+}
+
+// scanDuplicates probes the addresses we hold.
+func (m *IPManager) scanDuplicates() {
+	s := m.DuplicateScan
+	s.lastRun = time.Now()
+	time.AfterFunc(s.interval, m.recheck.Broadcast)
+	if !canAnnounce || m.Carp != nil || m.ProxyArp != nil {
+		return
+	}
+
+	for _, a := range m.addresses {
+		if a.vip.To4() == nil || a.iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		// A macvlan is created on demand, look up its current index
+		iface, err := net.InterfaceByName(a.iface.Name)
+		if err != nil {
+			slog.Error("Cannot scan for duplicates of the virtual IP", "vip", a.vip, "iface", a.iface.Name, "error", err)
+			continue
+		}
+		own := []net.HardwareAddr{iface.HardwareAddr, a.iface.HardwareAddr}
+		if m.Macvlan != nil {
+			if parent, err := net.InterfaceByName(m.Macvlan.parent); err == nil {
+				own = append(own, parent.HardwareAddr)
+			}
+		}
+
+		mac, err := probeARP(iface, a.vip, s.timeout, own...)
+		if err != nil {
+			slog.Error("Cannot scan for duplicates of the virtual IP", "vip", a.vip, "iface", iface.Name, "error", err)
+			continue
+		}
+		if mac != nil {
+			duplicateDetections.Inc()
+			slog.Error("Duplicate VIP detected, another host answers for the virtual IP held by this node",
+				"vip", a.vip, "mac", mac, "iface", iface.Name)
+			if s.reaction != "" {
+				go s.react(a.vip, iface.Name, mac)
+			}
+		}
+	}
+}
+
+// react runs the reaction command in the background, so it cannot hold up
+// the apply loop.
+func (s *DuplicateScan) react(vip net.IP, iface string, mac net.HardwareAddr) {
+	defer handlePanic()
+	ctx, cancel := context.WithTimeout(context.Background(), duplicateReactionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.reaction)
+	cmd.Env = append(os.Environ(),
+		"VIP_ADDRESS="+vip.String(),
+		"VIP_IFACE="+iface,
+		"VIP_MAC="+mac.String(),
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Error("Reaction to the duplicate address failed", "command", s.reaction, "vip", vip, "error", err, "output", string(output))
+		return
+	}
+	slog.Info("Ran the reaction to the duplicate address", "command", s.reaction, "vip", vip)
+}
@@ -0,0 +1,60 @@
+package ipmanager_test
+
+import (
+	"context"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// This example moves 10.1.2.3/24 on eth0 along with the Patroni leader of
+// the cluster pgcluster in etcd, until the program receives SIGTERM. It
+// needs root or CAP_NET_ADMIN.
+func Example() {
+	iface, err := net.InterfaceByName("eth0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	a, err := ipmanager.NewIPConfiguration(net.ParseIP("10.1.2.3"), net.CIDRMask(24, 32), *iface, "", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	transport, err := checker.NewTransport("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	lc, err := checker.NewLeaderChecker("etcd", "http://localhost:2379", "/service/pgcluster/leader", "node1", transport)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	states := make(chan bool)
+	m, err := ipmanager.NewIPManager([]*ipmanager.IPConfiguration{a}, states, ipmanager.ManagerOptions{
+		ShutdownGracePeriod: 10 * time.Second,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	// The checker must not wait while the manager is busy changing the
+	// address, Coalesce hands on only the latest state
+	updates := make(chan bool)
+	go checker.Coalesce(ctx, updates, states)
+	go func() {
+		if err := lc.GetChangeNotificationStream(ctx, updates); err != nil && ctx.Err() == nil {
+			log.Print(err)
+			stop()
+		}
+	}()
+
+	// Returns once ctx is done and the address is released
+	m.SyncStates(ctx, states)
+}
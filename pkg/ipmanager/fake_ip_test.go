@@ -0,0 +1,195 @@
+//go:build !solaris
+// +build !solaris
+
+package ipmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIP is a Commander that plays ip from iproute2 against addresses it
+// keeps in memory, so the address operations and the apply loop run without
+// root or a real interface.
+type fakeIP struct {
+	lock sync.Mutex
+	// The CIDRs on each interface with their labels
+	addresses map[string]map[string]string
+	calls     [][]string
+	// Called before every command, its result is returned instead of
+	// running the command unless it is nil
+	hook func(ctx context.Context, argv []string) *fakeResult
+}
+
+// fakeResult is what a command returns, see Commander.
+type fakeResult struct {
+	stdout, stderr []byte
+	exitCode       int
+	err            error
+}
+
+func newFakeIP(interfaces ...string) *fakeIP {
+	f := &fakeIP{addresses: make(map[string]map[string]string)}
+	for _, name := range interfaces {
+		f.addresses[name] = make(map[string]string)
+	}
+	return f
+}
+
+// exit is the result of a command that exited with code and printed stderr.
+func exit(code int, stderr string) *fakeResult {
+	return &fakeResult{stderr: []byte(stderr + "\n"), exitCode: code, err: fmt.Errorf("exit status %d", code)}
+}
+
+func (f *fakeIP) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, int, error) {
+	argv := append([]string{name}, args...)
+	f.lock.Lock()
+	f.calls = append(f.calls, argv)
+	hook := f.hook
+	f.lock.Unlock()
+	if hook != nil {
+		if r := hook(ctx, argv); r != nil {
+			return r.stdout, r.stderr, r.exitCode, r.err
+		}
+	}
+	r := f.run(argv)
+	return r.stdout, r.stderr, r.exitCode, r.err
+}
+
+func (f *fakeIP) run(argv []string) *fakeResult {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case len(argv) == 6 && strings.Join(argv[:5], " ") == "ip -j addr show dev":
+		return f.show(argv[5])
+	case len(argv) >= 6 && argv[0] == "ip" && argv[1] == "addr" && argv[4] == "dev":
+		addresses, ok := f.addresses[argv[5]]
+		if !ok {
+			return exit(1, "Cannot find device \""+argv[5]+"\"")
+		}
+		cidr := argv[3]
+		_, exists := addresses[cidr]
+		switch argv[2] {
+		case "add":
+			if exists {
+				return exit(2, "RTNETLINK answers: File exists")
+			}
+			label := ""
+			if len(argv) == 8 && argv[6] == "label" {
+				label = argv[7]
+			}
+			addresses[cidr] = label
+			return &fakeResult{}
+		case "delete":
+			if !exists {
+				return exit(2, "RTNETLINK answers: Cannot assign requested address")
+			}
+			delete(addresses, cidr)
+			return &fakeResult{}
+		}
+	}
+	return exit(1, "fakeIP: unexpected command "+strings.Join(argv, " "))
+}
+
+// show prints the addresses of iface like ip -j addr show.
+func (f *fakeIP) show(iface string) *fakeResult {
+	addresses, ok := f.addresses[iface]
+	if !ok {
+		return exit(1, "Device \""+iface+"\" does not exist.")
+	}
+	type addrInfo struct {
+		Family    string `json:"family"`
+		Local     string `json:"local"`
+		PrefixLen int    `json:"prefixlen"`
+		Label     string `json:"label,omitempty"`
+	}
+	link := struct {
+		Ifname   string     `json:"ifname"`
+		AddrInfo []addrInfo `json:"addr_info"`
+	}{Ifname: iface, AddrInfo: []addrInfo{}}
+	for cidr, label := range addresses {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		family := "inet"
+		if ip.To4() == nil {
+			family = "inet6"
+		}
+		link.AddrInfo = append(link.AddrInfo, addrInfo{Family: family, Local: ip.String(), PrefixLen: prefix, Label: label})
+	}
+	output, err := json.Marshal([]interface{}{link})
+	if err != nil {
+		panic(err)
+	}
+	return &fakeResult{stdout: output}
+}
+
+// has reports whether cidr is configured on iface.
+func (f *fakeIP) has(iface, cidr string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.addresses[iface][cidr]
+	return ok
+}
+
+// add configures cidr on iface behind the back of the manager.
+func (f *fakeIP) add(iface, cidr, label string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.addresses[iface][cidr] = label
+}
+
+// commands returns the commands run so far, each as one string.
+func (f *fakeIP) commands() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var commands []string
+	for _, argv := range f.calls {
+		commands = append(commands, strings.Join(argv, " "))
+	}
+	return commands
+}
+
+// loopback returns the loopback interface, which exists on every host, so
+// that the checks of the link pass.
+func loopback(t testing.TB) net.Interface {
+	t.Helper()
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface
+		}
+	}
+	t.Skip("no loopback interface")
+	return net.Interface{}
+}
+
+// newFakeManager returns a manager for the IPv6 address vip on the loopback
+// interface whose commands go to a fakeIP. IPv6 needs no ARP socket, and
+// the address is not announced.
+func newFakeManager(t testing.TB, vip string, states <-chan bool, options ManagerOptions) (*IPManager, *fakeIP, *IPConfiguration) {
+	t.Helper()
+	iface := loopback(t)
+	ip := newFakeIP(iface.Name)
+	a, err := NewIPConfiguration(net.ParseIP(vip), net.CIDRMask(64, 128), iface, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Commander = ip
+	options.IgnoreCarrier = true
+	m, err := NewIPManager([]*IPConfiguration{a}, states, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, ip, a
+}
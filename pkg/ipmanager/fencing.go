@@ -0,0 +1,76 @@
+package ipmanager
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// How often the age of the last confirmation is checked, at most
+const fenceCheckInterval = time.Second
+
+var selfFencings = metrics.NewCounter("vip_manager_self_fencing_total",
+	"Number of times the virtual IP was removed because the leadership was not confirmed by the DCS within the maximum confirmation age.")
+
+// confirmationStale reports whether the leadership was last confirmed
+// longer than MaxConfirmAge ago. A persisted state counts as confirmed when
+// it was loaded, giving the leader checker time to confirm it.
+func (m *IPManager) confirmationStale() bool {
+	confirmed := checker.LastConfirmed()
+	if m.provisionalAt.After(confirmed) {
+		confirmed = m.provisionalAt
+	}
+	return time.Since(confirmed) > m.MaxConfirmAge
+}
+
+// fenceLoop removes the virtual IP once the leadership was not confirmed
+// for MaxConfirmAge, whatever the reason: an unreachable DCS, rejected
+// credentials, a deleted key or a hung leader checker.
+func (m *IPManager) fenceLoop(ctx context.Context) {
+	interval := m.MaxConfirmAge / 10
+	if interval > fenceCheckInterval {
+		interval = fenceCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.stateLock.Lock()
+			acquiring := m.pending != nil && m.pending.state
+			if (m.currentState || acquiring) && m.confirmationStale() {
+				m.fence()
+			}
+			m.stateLock.Unlock()
+		}
+	}
+}
+
+// fence drops the desired state right away, skipping any delay. Has to be
+// called with stateLock held.
+func (m *IPManager) fence() {
+	selfFencings.Inc()
+	confirmed := checker.LastConfirmed()
+	age := "never"
+	if !confirmed.IsZero() {
+		age = time.Since(confirmed).Round(time.Second).String()
+	}
+	slog.Error("Self-fencing, the leadership was not confirmed in time, removing the virtual IP",
+		"vip", m.cidrs(), "max_confirm_age", m.MaxConfirmAge, "last_confirmed", age)
+	if m.pending != nil {
+		m.pending.timer.Stop()
+		m.pending.step.SetAttributes("cancelled", true)
+		m.pending.step.End(nil)
+		m.pending = nil
+	}
+	m.fenced = true
+	m.history.add(TransitionRecord{Time: time.Now(), Kind: "fenced", State: false})
+	if m.currentState {
+		m.changeState(false, time.Now())
+	}
+}
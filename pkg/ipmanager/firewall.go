@@ -0,0 +1,225 @@
+package ipmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"text/template"
+)
+
+// All rules live in a table of their own, so dropping the table removes
+// everything we ever added, including leftovers of a crashed process. The
+// name of the instance is appended, so instances on one host, e.g. of a
+// config directory, leave the rules of the others alone.
+const nftTablePrefix = "vip_manager"
+
+// nftTableName returns the table of the instance called instance.
+func nftTableName(instance string) string {
+	name := []byte(instance)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return nftTablePrefix
+	}
+	return nftTablePrefix + "_" + string(name)
+}
+
+// Rejects connections to PostgreSQL on the virtual IP while another node
+// holds it, so clients with a stale ARP entry for us fail right away instead
+// of waiting for a timeout. The fib match keeps it from ever rejecting
+// traffic to an address we hold, whatever the order of the changes.
+const defaultStandbyRules = "{{.Family}} daddr {{.VIP}} fib daddr type != local tcp dport 5432 reject with tcp reset"
+
+// FirewallState is which of the rules are in place.
+type FirewallState int
+
+const (
+	// No table, e.g. before the first change or after exit
+	FirewallAbsent FirewallState = iota
+	// Only the rules for while we do not hold the addresses
+	FirewallStandby
+	// The rules for while we hold the addresses
+	FirewallHolding
+)
+
+func (s FirewallState) String() string {
+	switch s {
+	case FirewallStandby:
+		return "standby"
+	case FirewallHolding:
+		return "holding"
+	}
+	return "absent"
+}
+
+// firewallStateFor returns the rules that belong to desiredState.
+func firewallStateFor(desiredState bool) FirewallState {
+	if desiredState {
+		return FirewallHolding
+	}
+	return FirewallStandby
+}
+
+// NftFirewall switches between two sets of nftables rules, one while we
+// hold the virtual IP in an input chain and one while we do not in a
+// prerouting chain, which also sees the traffic for addresses that are not
+// local (anymore).
+type NftFirewall struct {
+	table        string
+	rules        string
+	standbyRules string
+	// Runs nft, the Commander of the IPManager once it has one
+	commander Commander
+}
+
+type firewallTemplateData struct {
+	VIP    string
+	Mask   int
+	CIDR   string
+	Iface  string
+	Family string
+}
+
+// NewNftFirewall renders the rules of the instance called instance once for
+// every managed address. The rules while holding the addresses are read from
+// rulesFile, there are none if it is empty. The rules while not holding them
+// are read from standbyRulesFile, or reject PostgreSQL if it is empty.
+func NewNftFirewall(instance, rulesFile, standbyRulesFile string, addresses []*IPConfiguration) (*NftFirewall, error) {
+	rules, err := renderFirewallRules(rulesFile, "", addresses)
+	if err != nil {
+		return nil, err
+	}
+	standbyRules, err := renderFirewallRules(standbyRulesFile, defaultStandbyRules, addresses)
+	if err != nil {
+		return nil, err
+	}
+	return &NftFirewall{table: nftTableName(instance), rules: rules, standbyRules: standbyRules, commander: ExecCommander{}}, nil
+}
+
+// renderFirewallRules renders the rules in file, or text if it is empty, for
+// every address.
+func renderFirewallRules(file, text string, addresses []*IPConfiguration) (string, error) {
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		text = string(content)
+	}
+
+	tmpl, err := template.New("firewall").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse firewall rules %s: %s", file, err)
+	}
+
+	var rules bytes.Buffer
+	for _, a := range addresses {
+		family := "ip"
+		if a.vip.To4() == nil {
+			family = "ip6"
+		}
+
+		err = tmpl.Execute(&rules, firewallTemplateData{
+			VIP:    a.vip.String(),
+			Mask:   NetmaskSize(a.netmask),
+			CIDR:   a.GetCIDR(),
+			Iface:  a.iface.Name,
+			Family: family,
+		})
+		if err != nil {
+			return "", fmt.Errorf("cannot render firewall rules %s: %s", file, err)
+		}
+		rules.WriteString("\n")
+	}
+	return rules.String(), nil
+}
+
+// The chain that tells which rules are in place
+const (
+	holdingChain = "holding"
+	standbyChain = "standby"
+)
+
+// ruleset replaces the whole table in one transaction. Declaring the table
+// before deleting it keeps the delete from failing when it does not exist.
+func (f *NftFirewall) ruleset(state FirewallState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s {}\n", f.table)
+	fmt.Fprintf(&b, "delete table inet %s\n", f.table)
+	fmt.Fprintf(&b, "table inet %s {\n", f.table)
+	if state == FirewallHolding {
+		writeChain(&b, holdingChain, "input", f.rules)
+	} else {
+		writeChain(&b, standbyChain, "prerouting", f.standbyRules)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeChain(b *strings.Builder, name, hook, rules string) {
+	fmt.Fprintf(b, "\tchain %s {\n", name)
+	fmt.Fprintf(b, "\t\ttype filter hook %s priority 0; policy accept;\n", hook)
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fmt.Fprintf(b, "\t\t%s\n", line)
+	}
+	b.WriteString("\t}\n")
+}
+
+// Apply puts the rules for state in place, FirewallAbsent removes them. nft
+// is killed once ctx is done.
+func (f *NftFirewall) Apply(ctx context.Context, state FirewallState) error {
+	if state == FirewallAbsent {
+		return f.Remove(ctx)
+	}
+	// nft reads the ruleset from its arguments as from a file, the whole
+	// ruleset still is one transaction
+	ruleset := f.ruleset(state)
+	if skipDryRun("nft", ruleset) {
+		return nil
+	}
+	return f.run(ctx, "applying", ruleset)
+}
+
+// Remove drops the table with all rules. nft is killed once ctx is done.
+func (f *NftFirewall) Remove(ctx context.Context) error {
+	ruleset := fmt.Sprintf("table inet %s {}\ndelete table inet %s\n", f.table, f.table)
+	if skipDryRun("nft", ruleset) {
+		return nil
+	}
+	return f.run(ctx, "removing", ruleset)
+}
+
+// run runs nft with ruleset, action is logged if it fails.
+func (f *NftFirewall) run(ctx context.Context, action, ruleset string) error {
+	_, stderr, exitCode, err := f.commander.Run(ctx, "nft", ruleset)
+	if err != nil {
+		slog.Error("Error "+action+" firewall rules", "error", err, "output", strings.TrimSpace(string(stderr)),
+			"failure_class", commandFailed("nft", err, exitCode, stderr))
+		return err
+	}
+	return nil
+}
+
+// Query returns which rules are in place. nft is killed once ctx is done.
+func (f *NftFirewall) Query(ctx context.Context) FirewallState {
+	output, _, _, err := f.commander.Run(ctx, "nft", "list", "table", "inet", f.table)
+	switch {
+	case err != nil:
+		return FirewallAbsent
+	case strings.Contains(string(output), "chain "+holdingChain+" {"):
+		return FirewallHolding
+	case strings.Contains(string(output), "chain "+standbyChain+" {"):
+		return FirewallStandby
+	}
+	return FirewallAbsent
+}
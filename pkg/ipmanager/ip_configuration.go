@@ -0,0 +1,43 @@
+package ipmanager
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPConfiguration is one virtual IP on an interface.
+type IPConfiguration struct {
+	vip     net.IP
+	netmask net.IPMask
+	iface   net.Interface
+	// Overrides the default label if set
+	label string
+	// Do not announce the address when it is configured
+	noAnnounce bool
+}
+
+// NewIPConfiguration describes vip with netmask on iface. label overrides
+// the default label of the address if not empty, announce enables the
+// gratuitous ARP or unsolicited neighbor advertisement once it is
+// configured.
+func NewIPConfiguration(vip net.IP, netmask net.IPMask, iface net.Interface, label string, announce bool) (*IPConfiguration, error) {
+	if _, bits := netmask.Size(); bits == 0 {
+		return nil, fmt.Errorf("invalid netmask %s", netmask)
+	}
+	c := &IPConfiguration{vip: vip, netmask: netmask, iface: iface, label: label, noAnnounce: !announce}
+	if err := c.checkLabel(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCIDR returns the address with its prefix length, e.g. 10.1.2.3/24.
+func (c *IPConfiguration) GetCIDR() string {
+	return fmt.Sprintf("%s/%d", c.vip.String(), NetmaskSize(c.netmask))
+}
+
+// NetmaskSize returns the prefix length of mask.
+func NetmaskSize(mask net.IPMask) int {
+	ones, _ := mask.Size()
+	return ones
+}
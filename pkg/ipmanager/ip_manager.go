@@ -0,0 +1,1174 @@
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/steadylog"
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+	arp "github.com/mdlayher/arp"
+)
+
+const (
+	arpReplyOp = 2
+)
+
+const (
+	configureMinBackoff = 1 * time.Second
+	configureMaxBackoff = 1 * time.Minute
+
+	dadRecheckInterval = 1 * time.Second
+	// How often to look at the link again while waiting for carrier. There
+	// is no link event subscription, so we have to poll.
+	linkRecheckInterval = 2 * time.Second
+)
+
+var (
+	ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+	addressFailures = metrics.NewCounterVec("vip_manager_address_failures_total",
+		"Number of failed attempts to add or remove the virtual IP.", "operation")
+	dadFailures = metrics.NewCounter("vip_manager_dad_failures_total",
+		"Number of times IPv6 duplicate address detection failed for the virtual IP.")
+	failoverDuration = metrics.NewHistogramVec("vip_manager_failover_duration_seconds",
+		"Time from a state change reported by the leader checker until the virtual IP was configured or removed.",
+		durationBuckets, "direction")
+	acquireStepDuration = metrics.NewHistogramVec("vip_manager_acquire_step_duration_seconds",
+		"Duration of the steps of configuring the virtual IP.", durationBuckets, "step")
+	connectivityFailures = metrics.NewCounter("vip_manager_connectivity_check_failures_total",
+		"Number of failed connectivity checks from the virtual IP.")
+	desiredStateGauge = metrics.NewGauge("vip_manager_desired_state",
+		"1 if this node should hold the virtual IP according to the DCS, else 0.")
+	actualStateGauge = metrics.NewGauge("vip_manager_actual_state",
+		"1 if all virtual IPs are configured on this node, else 0.")
+	addressConsecutiveFailures = metrics.NewGauge("vip_manager_address_consecutive_failures",
+		"Number of failed attempts to reach the desired state since the last success.")
+)
+
+// ForeignPolicy decides how to treat a copy of the virtual IP that is not
+// labeled as ours, e.g. because an operator added it manually.
+type ForeignPolicy string
+
+const (
+	// Treat it as if we had added it
+	ForeignAdopt ForeignPolicy = "adopt"
+	// Never remove it, but do not add our own copy either
+	ForeignIgnore ForeignPolicy = "ignore"
+	// Replace it with our own copy, or remove it when not leader
+	ForeignRemove ForeignPolicy = "remove"
+)
+
+// ManagerOptions holds the optional parts of address management.
+type ManagerOptions struct {
+	Firewall     *NftFirewall
+	PrimaryCheck *PrimaryCheck
+	Macvlan      *Macvlan
+	// What to do with copies of the address that we did not add
+	ForeignPolicy ForeignPolicy
+	// Remove the address on exit even if it was not added by vip-manager,
+	// i.e. is not labeled as ours
+	ForceReleaseOnExit bool
+	// Keep the address on exit while leader, for a hitless restart
+	RetainOnExit bool
+	// Verifies the address is reachable while we hold it
+	ConnectivityCheck *ConnectivityCheck
+	// ARP sysctls to set while we hold the address
+	ArpSysctls *ArpSysctls
+	// Configure the address even if the link has no carrier
+	IgnoreCarrier bool
+	// How long address operations may take once we are asked to exit
+	ShutdownGracePeriod time.Duration
+	// Set the advskew of a CARP interface instead of adding the address
+	Carp *Carp
+	// Publish a proxy neighbor entry instead of adding the address
+	ProxyArp *ProxyArp
+	// Keep the address for a while after losing leadership
+	ReleaseGracePeriod time.Duration
+	// Skip the grace period when the leadership was not handed over in a
+	// switchover
+	ReleaseGracePlannedOnly bool
+	// Hold back changes of the desired state in either direction
+	DelayBeforeAcquire time.Duration
+	DelayBeforeRelease time.Duration
+	// Upper bound of a random delay before the first acquisition
+	StartupJitter time.Duration
+	// Keep addresses present at startup for this long while waiting for
+	// the first state from the leader checker, 0 removes them right away
+	StartupHoldOff time.Duration
+	// Make sure nobody else answers for the address before taking it over
+	ArpProbe *ArpProbe
+	// Records every transition and announcement
+	AuditLog *AuditLog
+	// Runs the commands that query and change the addresses, ExecCommander
+	// if nil
+	Commander Commander
+	// Told about every completed transition
+	Notifiers []Notifier
+	// Publishes the addresses while we hold them
+	ServiceRegistry ServiceRegistry
+	// Probes for other hosts answering for the addresses while we hold them
+	DuplicateScan *DuplicateScan
+	// Remove the addresses when the DCS did not confirm the leadership for
+	// this long, 0 keeps them
+	MaxConfirmAge time.Duration
+	// Announce the addresses again this often while we hold them, 0 only
+	// announces them when they were added
+	ReassertInterval time.Duration
+	// Warn when the addresses are not in the desired state for this long,
+	// 0 only measures it
+	DivergenceThreshold time.Duration
+	// Keeps the desired state across restarts
+	StateFile *StateFile
+}
+
+// Manager is what a program embedding vip-manager uses to drive the virtual
+// IPs, implemented by IPManager.
+type Manager interface {
+	// SyncStates follows the desired states reported by a leader checker
+	// on states until ctx is done, then releases the virtual IPs
+	SyncStates(ctx context.Context, states <-chan bool)
+	// Ready is closed once the first desired state was received
+	Ready() <-chan struct{}
+	// Health reports why the manager is not healthy, or nil
+	Health(dcsThreshold time.Duration) error
+	Status(dcsThreshold time.Duration) Status
+	// While in maintenance, desired states are recorded but not applied
+	SetMaintenance(active bool)
+	Maintenance() bool
+}
+
+var _ Manager = (*IPManager)(nil)
+
+// IPManager adds and removes a set of virtual IPs together, following the
+// desired state reported by a leader checker.
+type IPManager struct {
+	ManagerOptions
+
+	// Addresses are added and removed together, e.g. an IPv4 and IPv6 pair
+	addresses []*IPConfiguration
+
+	states       <-chan bool
+	currentState bool
+	pending      *pendingTransition
+	// When the change to currentState was reported by the leader checker
+	stateChangedAt time.Time
+	// Whether the leader checker has reported a state yet
+	stateReceived bool
+	// When currentState was taken from the StateFile, zero if it was not
+	provisionalAt time.Time
+	// What the apply loop saw last and when it last completed a change,
+	// for the status
+	observedStates []AddressState
+	lastTransition time.Time
+	// Desired states are recorded, but not acted on
+	maintenance bool
+	// Removed because the leadership was not confirmed in time, until the
+	// next fresh confirmation
+	fenced bool
+	// Remove the addresses once, despite maintenance, see ForceRelease
+	releaseRequested bool
+	// Retry right away, despite the backoff, see Reconcile
+	reconcileRequested bool
+	// Closed when the leader checker reports the first state
+	ready      chan struct{}
+	stateLock  sync.Mutex
+	recheck    *sync.Cond
+	arpClients map[string]*arp.Client
+	// The index of the interface each arp client was opened on, a client
+	// is useless once its interface was created again
+	arpIndexes map[string]int
+	// No raw socket for ARP, e.g. without CAP_NET_RAW, gratuitous ARP is
+	// sent with arping through CommandPrefix
+	arping    bool
+	announcer *announcer
+	// Unix time in nanoseconds of the last apply loop iteration
+	lastApply int64
+	// *loopSnapshot of the last apply loop iteration, for DumpState
+	snapshot atomic.Value
+	history  transitionHistory
+	leader   leaderObservation
+	// The steps of the transition in progress, for its summary
+	steps transitionSteps
+	// The span of the transition in progress, its tracing.SpanContext is
+	// also in transitionTrace for the steps, which run without stateLock
+	transitionSpan  *tracing.Span
+	transitionTrace atomic.Value
+
+	// Only touched by the apply loop
+	lastDesiredState bool
+	failures         int
+	backoff          *Backoff
+	// Which addresses were added by this process
+	added []bool
+	// The last state change whose completion was recorded
+	measuredChange time.Time
+	statusLog      steadylog.Logger
+	// When we started waiting for IPv6 duplicate address detection
+	dadStarted time.Time
+	// When the addresses were last announced again, see reassert
+	lastReassert time.Time
+	// See holdingOff
+	holdOff        holdOffPhase
+	holdOffStarted time.Time
+	holdOffTimer   *time.Timer
+	// See trackDivergence
+	divergedSince     time.Time
+	divergenceAlerted bool
+	divergenceTimer   *time.Timer
+}
+
+// NewIPManager manages addresses together, e.g. an IPv4 and an IPv6 address.
+// The sockets for announcing them are opened right away.
+func NewIPManager(addresses []*IPConfiguration, states <-chan bool, options ManagerOptions) (*IPManager, error) {
+	m := &IPManager{
+		ManagerOptions: options,
+		addresses:      addresses,
+		states:         states,
+		currentState:   false,
+		ready:          make(chan struct{}),
+		arpClients:     make(map[string]*arp.Client),
+		arpIndexes:     make(map[string]int),
+		backoff:        NewBackoff(configureMinBackoff, configureMaxBackoff),
+		added:          make([]bool, len(addresses)),
+	}
+
+	if m.Commander == nil {
+		m.Commander = ExecCommander{}
+	}
+	if m.Firewall != nil {
+		m.Firewall.commander = m.Commander
+	}
+	m.recheck = sync.NewCond(&m.stateLock)
+	m.announcer = newAnnouncer(m.Announce, m.auditAnnouncement, func(name string) *step {
+		return m.startStep(name, "announce")
+	})
+	if !canAnnounce {
+		slog.Warn("Announcing the virtual IP is not supported on this platform, neighbours notice the move once their caches expire")
+		return m, nil
+	}
+	// The macvlan only exists while we hold the address, its arp client
+	// is created when needed. CARP does its own announcements.
+	if DryRun || m.Macvlan != nil || m.Carp != nil {
+		return m, nil
+	}
+	for _, a := range addresses {
+		if a.vip.To4() == nil || m.arpClients[a.iface.Name] != nil {
+			continue
+		}
+		arpClient, err := arp.Dial(&a.iface)
+		if err != nil && len(CommandPrefix) > 0 {
+			slog.Warn("Cannot open the arp client, sending gratuitous ARP with arping through the command prefix",
+				"iface", a.iface.Name, "prefix", strings.Join(CommandPrefix, " "), "error", err)
+			m.arping = true
+			break
+		}
+		if err != nil {
+			slog.Error("Problems with producing the arp client", "iface", a.iface.Name, "error", err)
+			return nil, err
+		}
+		m.arpClients[a.iface.Name] = arpClient
+		m.arpIndexes[a.iface.Name] = a.iface.Index
+	}
+
+	return m, nil
+}
+
+func (m *IPManager) applyLoop(ctx context.Context) {
+	// Operations in flight when we are asked to exit get some time to
+	// finish, as does the cleanup on exit.
+	opCtx, cancel := withGracePeriod(ctx, m.ShutdownGracePeriod)
+	defer cancel()
+
+	// Whether the last iteration changed something, so the query checks
+	// the result
+	changed := false
+	// Every path back to the top of the loop ends up here once ctx is
+	// done, so the cleanup runs exactly once, even if we were asked to exit
+	// in the middle of a change
+	for ctx.Err() == nil {
+		atomic.StoreInt64(&m.lastApply, time.Now().UnixNano())
+		var verify *step
+		if changed {
+			verify = m.startStep("verify", "")
+		}
+		changed = false
+		actualStates, err := m.QueryAddresses()
+		if verify != nil {
+			verify.End(err)
+		}
+		if err != nil {
+			slog.Error("Cannot query the virtual IP", "vip", m.cidrs(), "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(linkRecheckInterval):
+			}
+			continue
+		}
+		rulesState := m.QueryFirewall(opCtx)
+		macvlanState := m.Macvlan != nil && m.Macvlan.Exists()
+		m.stateLock.Lock()
+		desiredState := m.currentState
+		changedAt := m.stateChangedAt
+		stateReceived := m.stateReceived
+		pendingStatus := m.pendingStatus()
+		maintenance := m.maintenance
+		releaseRequested := m.releaseRequested
+		reconcileRequested := m.reconcileRequested
+		m.reconcileRequested = false
+		m.observedStates = actualStates
+		m.stateLock.Unlock()
+		m.snapshot.Store(&loopSnapshot{
+			at:          time.Now(),
+			desired:     desiredState,
+			actual:      actualStates,
+			maintenance: maintenance,
+			pending:     pendingStatus,
+			failures:    m.failures,
+			backoff:     m.backoff.Current(),
+			backoffWait: m.backoff.Waiting(),
+		})
+		desiredStateGauge.Set(boolValue(desiredState))
+		actualStateGauge.Set(boolValue(allPresent(actualStates)))
+
+		if desiredState != m.lastDesiredState || reconcileRequested {
+			m.lastDesiredState = desiredState
+			m.resetFailures()
+			// Acquiring announces them anyway
+			m.lastReassert = time.Now()
+		}
+		if tracelog.Enabled() {
+			tracelog.Log("Reconciling", "vip", m.cidrs(), "desired", desiredState, "actual", fmt.Sprintf("%+v", actualStates),
+				"maintenance", maintenance, "firewall", rulesState, "macvlan", macvlanState, "failures", m.failures, "pending", pendingStatus)
+		}
+
+		var status []string
+		if maintenance {
+			status = append(status, "MAINTENANCE, not changing anything")
+		}
+		for i, a := range m.addresses {
+			status = append(status, fmt.Sprintf("IP address %s on %s state is %t", a.GetCIDR(), a.iface.Name, actualStates[i].Present))
+			if actualStates[i].DADFailed {
+				status = append(status, "duplicate address detected")
+			} else if actualStates[i].Tentative {
+				status = append(status, "duplicate address detection in progress")
+			}
+			for _, prefix := range actualStates[i].StalePrefixes {
+				status = append(status, fmt.Sprintf("also present as /%d", prefix))
+			}
+		}
+		status = append(status, fmt.Sprintf("desired %t", desiredState))
+		if m.holdOff == holdOffActive {
+			status = append(status, "keeping it until the leader checker reports a state")
+		}
+		if pendingStatus != "" {
+			status = append(status, pendingStatus)
+		}
+		if m.Firewall != nil {
+			status = append(status, fmt.Sprintf("firewall rules %s", rulesState))
+		}
+		if m.Carp != nil {
+			status = append(status, fmt.Sprintf("%s %s", m.Carp.iface, m.Carp.Status()))
+		}
+		if m.Macvlan != nil {
+			status = append(status, fmt.Sprintf("macvlan %s %t", m.Macvlan.name, macvlanState))
+		}
+		if m.ArpSysctls != nil {
+			status = append(status, fmt.Sprintf("ARP sysctls %s", m.ArpSysctls.Status()))
+		}
+		if m.PrimaryCheck != nil {
+			status = append(status, fmt.Sprintf("primary check %s", m.PrimaryCheck.Status()))
+		}
+		if m.ConnectivityCheck != nil && desiredState {
+			status = append(status, fmt.Sprintf("connectivity check %s", m.ConnectivityCheck.Status()))
+		}
+		if m.failures > 0 {
+			status = append(status, fmt.Sprintf("%d failed attempts, next in %s", m.failures, m.backoff.Current()))
+		}
+		m.statusLog.Log(slog.LevelInfo, strings.Join(status, ", "))
+
+		if maintenance && releaseRequested {
+			if m.reconcile(opCtx, actualStates, rulesState, macvlanState, false) {
+				changed = true
+				continue
+			}
+			if m.allInSync(actualStates, false) {
+				slog.Warn("Released the virtual IP on request, staying in maintenance mode", "vip", m.cidrs())
+				m.stateLock.Lock()
+				m.releaseRequested = false
+				m.stateLock.Unlock()
+				releaseRequested = false
+			}
+			m.measuredChange = changedAt
+		} else if maintenance {
+			if changedAt != m.measuredChange && !m.allInSync(actualStates, desiredState) {
+				slog.Warn("Maintenance mode, not applying the desired state", "vip", m.cidrs(), "state", desiredState)
+			}
+			// Changes made after maintenance are not failovers
+			m.measuredChange = changedAt
+		} else if m.holdingOff(actualStates, stateReceived, desiredState) {
+			// Nothing to do until the first state or the end of the hold-off
+		} else {
+			if m.reconcile(opCtx, actualStates, rulesState, macvlanState, desiredState) {
+				changed = true
+				continue
+			}
+
+			if changedAt != m.measuredChange && m.allInSync(actualStates, desiredState) {
+				m.measuredChange = changedAt
+				m.transitionCompleted(desiredState, changedAt)
+			}
+		}
+		m.trackDivergence(actualStates, desiredState, maintenance || m.holdOff == holdOffActive)
+
+		m.stateLock.Lock()
+		if m.currentState != desiredState || m.maintenance != maintenance ||
+			m.releaseRequested != releaseRequested || m.reconcileRequested {
+			// Changed while we were busy, no need to wait
+			m.stateLock.Unlock()
+			continue
+		}
+		// Wait for notification, unless we were asked to exit while busy
+		// and missed it. SyncStates broadcasts with the lock held after
+		// ctx is done.
+		if ctx.Err() == nil {
+			m.recheck.Wait()
+		}
+		// Want to query actual state anyway, so unlock
+		m.stateLock.Unlock()
+	}
+
+	m.releaseOnExit(opCtx)
+}
+
+// releaseOnExit removes what we configured, unless we keep the address
+// for a hitless restart. ctx is the grace period for the cleanup.
+func (m *IPManager) releaseOnExit(ctx context.Context) {
+	if m.RetainOnExit && m.lastDesiredState {
+		slog.Info("Keeping the virtual IP configured on exit", "vip", m.cidrs())
+		return
+	}
+	m.RemoveFirewallRules(ctx)
+	states, err := m.QueryAddresses()
+	if err != nil {
+		slog.Error("Cannot remove the virtual IP on exit", "vip", m.cidrs(), "error", err)
+	}
+	for i, state := range states {
+		if !m.ownsAddress(state) {
+			continue
+		}
+		if !m.addedByUs(i, state) && !m.ForceReleaseOnExit {
+			slog.Info("Leaving address in place, it was not added by vip-manager", "vip", m.addresses[i].GetCIDR(), "iface", m.addresses[i].iface.Name)
+			continue
+		}
+		m.DeconfigureAddress(ctx, m.addresses[i])
+	}
+	m.RestoreArpSysctls()
+	m.RemoveMacvlan()
+}
+
+// reconcile takes one step towards the desired state. It returns true when
+// something was changed and the actual state should be queried again right
+// away, false when there is nothing to do until the next recheck.
+//
+// Addresses are handled one by one, so when only one of them is missing
+// the others are left alone.
+func (m *IPManager) reconcile(ctx context.Context, actualStates []AddressState, rulesState FirewallState, macvlanState, desiredState bool) bool {
+	inSync := m.allInSync(actualStates, desiredState)
+
+	if DryRun {
+		if !inSync || (m.Firewall != nil && rulesState != firewallStateFor(desiredState)) {
+			slog.Info("Dry run, not changing the state", "vip", m.cidrs(), "state", desiredState)
+		}
+		return false
+	}
+
+	if !inSync {
+		if m.backoff.Waiting() {
+			return false
+		}
+
+		ok := true
+		if desiredState {
+			if m.PrimaryCheck != nil {
+				step := m.startStep("primary check", "")
+				ready := m.PrimaryCheck.Ready(m.recheck.Broadcast)
+				step.SetAttributes("ready", ready)
+				step.End(nil)
+				if !ready {
+					return false
+				}
+			}
+			if err := m.checkLinks(); err != nil {
+				slog.Warn("Delaying takeover", "vip", m.cidrs(), "error", err)
+				time.AfterFunc(linkRecheckInterval, m.recheck.Broadcast)
+				return false
+			}
+			step := m.startStep("arp probe", "")
+			conflict := m.splitBrainDetected(actualStates)
+			step.SetAttributes("conflict", conflict)
+			step.End(nil)
+			if conflict {
+				time.AfterFunc(m.ArpProbe.retryInterval, m.recheck.Broadcast)
+				return false
+			}
+			if m.Macvlan != nil {
+				if err := m.Macvlan.Ensure(); err != nil {
+					slog.Error("Cannot set up macvlan", "macvlan", m.Macvlan.name, "error", err)
+					return m.operationFailed("add")
+				}
+			}
+			if m.ArpSysctls != nil {
+				// Not worth failing over for, the address works without
+				if err := m.ArpSysctls.Apply(); err != nil {
+					slog.Error("Cannot set ARP sysctls", "error", err)
+				}
+			}
+			pending := false
+			for i, a := range m.addresses {
+				if actualStates[i].Tentative {
+					pending = true
+				} else if !m.acquireAddress(ctx, a, actualStates[i]) {
+					ok = false
+				}
+			}
+			if !ok {
+				return m.operationFailed("add")
+			}
+			if pending {
+				// Duplicate address detection is still running
+				if m.dadStarted.IsZero() {
+					m.dadStarted = time.Now()
+				}
+				time.AfterFunc(dadRecheckInterval, m.recheck.Broadcast)
+				return false
+			}
+			if !m.dadStarted.IsZero() {
+				m.startStepAt("duplicate address detection", "dad", m.dadStarted).End(nil)
+				m.dadStarted = time.Time{}
+			}
+			if m.ConnectivityCheck != nil {
+				m.ConnectivityCheck.Invalidate()
+			}
+		} else {
+			if m.Firewall != nil {
+				m.syncFirewall(ctx, false)
+			}
+			for i, a := range m.addresses {
+				for _, prefix := range actualStates[i].StalePrefixes {
+					if !m.RemoveStalePrefix(ctx, a, prefix) {
+						ok = false
+					}
+				}
+				if m.ownsAddress(actualStates[i]) && !m.DeconfigureAddress(ctx, a) {
+					ok = false
+				}
+			}
+			m.RestoreArpSysctls()
+			m.RemoveMacvlan()
+			if !ok {
+				return m.operationFailed("delete")
+			}
+		}
+		m.resetFailures()
+		return true
+	}
+
+	if !desiredState && macvlanState {
+		// Leftover of an earlier run without any addresses on it
+		return m.RemoveMacvlan()
+	}
+
+	if !desiredState && m.PrimaryCheck != nil {
+		m.PrimaryCheck.Reset()
+	}
+
+	if m.Firewall != nil && rulesState != firewallStateFor(desiredState) {
+		return m.syncFirewall(ctx, desiredState)
+	}
+
+	if desiredState {
+		// Also covers addresses that were in place at startup
+		m.registerServices()
+	}
+
+	if desiredState && m.ConnectivityCheck != nil && m.ConnectivityCheck.Due() {
+		m.checkConnectivity()
+	}
+
+	if desiredState && m.DuplicateScan != nil && m.DuplicateScan.Due() {
+		m.scanDuplicates()
+	}
+
+	if desiredState && m.reassertDue() {
+		m.reassert()
+	}
+	return false
+}
+
+// checkConnectivity only reports problems. Removing the address because of
+// a failed check would just make it flap between the nodes.
+func (m *IPManager) checkConnectivity() {
+	source := m.addresses[0]
+	for _, a := range m.addresses {
+		if (a.vip.To4() == nil) == m.ConnectivityCheck.IPv6Target() {
+			source = a
+		}
+	}
+
+	if err := m.ConnectivityCheck.Run(source.vip); err != nil {
+		connectivityFailures.Inc()
+		slog.Warn("Virtual IP is not reachable, connectivity check failed",
+			"vip", source.vip, "target", m.ConnectivityCheck.target, "error", err)
+	}
+	time.AfterFunc(m.ConnectivityCheck.interval, m.recheck.Broadcast)
+}
+
+// acquireAddress brings a single address into the configured state.
+func (m *IPManager) acquireAddress(ctx context.Context, a *IPConfiguration, state AddressState) bool {
+	ok := true
+	for _, prefix := range state.StalePrefixes {
+		if !m.RemoveStalePrefix(ctx, a, prefix) {
+			ok = false
+		}
+	}
+
+	if state.DADFailed {
+		// Removed here and added again once the backoff has passed
+		m.duplicateAddressDetected(ctx, a)
+		return false
+	}
+
+	if state.Present {
+		if !(state.Foreign && m.ForeignPolicy == ForeignRemove) {
+			return ok
+		}
+		if !m.DeconfigureAddress(ctx, a) {
+			return false
+		}
+	}
+
+	if !m.ConfigureAddress(ctx, a) {
+		return false
+	}
+	// For now it is save to say that also working even if a
+	// gratuitous arp message could not be send but logging an
+	// errror should be enough.
+	if canAnnounce && m.Carp == nil && !a.noAnnounce {
+		m.announcer.Request(a)
+	}
+	return ok
+}
+
+// duplicateAddressDetected handles an IPv6 address the kernel marked as
+// dadfailed. Such an address is unusable and has to be removed.
+func (m *IPManager) duplicateAddressDetected(ctx context.Context, a *IPConfiguration) {
+	dadFailures.Inc()
+	owner := "unknown"
+	if mac := lookupNeighbor(a.iface.Name, a.vip); mac != "" {
+		owner = mac
+	}
+	slog.Error("Duplicate address detection failed, the address is in use by another host", "vip", a.GetCIDR(), "iface", a.iface.Name, "owner", owner)
+	m.DeconfigureAddress(ctx, a)
+}
+
+// addedByUs tells whether the address at i was added by this process, or
+// carries our label and was added by an earlier run, e.g. before a restart.
+func (m *IPManager) addedByUs(i int, s AddressState) bool {
+	if m.added[i] {
+		return true
+	}
+	// Only addresses we configure ourselves carry a label
+	return m.Carp == nil && m.ProxyArp == nil && m.addresses[i].Label() != "" && !s.Foreign
+}
+
+// ownsAddress tells whether a present address may be removed by us.
+func (m *IPManager) ownsAddress(s AddressState) bool {
+	return s.Present && !(s.Foreign && m.ForeignPolicy == ForeignIgnore)
+}
+
+// operationFailed backs off before the next attempt, so a persistent error
+// does not turn into a tight loop of ip invocations.
+func (m *IPManager) operationFailed(operation string) bool {
+	m.failures++
+	addressFailures.With(operation).Inc()
+	addressConsecutiveFailures.Set(float64(m.failures))
+	delay := m.backoff.Failed(m.recheck.Broadcast)
+	slog.Error("Failed to "+operation+" the virtual IP", "vip", m.cidrs(), "failures", m.failures, "retry_in", delay)
+	m.notifyFailure(operation)
+	return false
+}
+
+func (m *IPManager) resetFailures() {
+	m.failures = 0
+	m.backoff.Reset()
+	addressConsecutiveFailures.Set(0)
+}
+
+// checkLinks makes sure the links the addresses go to exist and have
+// carrier. With a macvlan it is the parent that matters, the macvlan itself
+// is created later.
+func (m *IPManager) checkLinks() error {
+	if m.Carp != nil {
+		return nil
+	}
+	links := []string{}
+	if m.Macvlan != nil {
+		links = append(links, m.Macvlan.parent)
+	} else {
+		for _, a := range m.addresses {
+			links = append(links, a.iface.Name)
+		}
+	}
+	for _, name := range links {
+		// It may have disappeared since startup, e.g. a hotplugged NIC
+		if _, err := net.InterfaceByName(name); err != nil {
+			return fmt.Errorf("interface %s does not exist", name)
+		}
+		if m.IgnoreCarrier {
+			continue
+		}
+		if err := checkCarrier(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *IPManager) RestoreArpSysctls() {
+	if m.ArpSysctls == nil {
+		return
+	}
+	if err := m.ArpSysctls.Restore(); err != nil {
+		slog.Error("Cannot restore ARP sysctls", "error", err)
+	}
+}
+
+func (m *IPManager) RemoveMacvlan() bool {
+	if m.Macvlan == nil {
+		return true
+	}
+	if err := m.Macvlan.Remove(); err != nil {
+		slog.Error("Cannot remove macvlan", "macvlan", m.Macvlan.name, "error", err)
+		return false
+	}
+	return true
+}
+
+// CIDRs lists the virtual IPs, e.g. for messages about them.
+func (m *IPManager) CIDRs() string {
+	return m.cidrs()
+}
+
+func (m *IPManager) cidrs() string {
+	var cidrs []string
+	for _, a := range m.addresses {
+		cidrs = append(cidrs, a.GetCIDR())
+	}
+	return strings.Join(cidrs, ", ")
+}
+
+// QueryFirewall returns which firewall rules are in place.
+func (m *IPManager) QueryFirewall(ctx context.Context) FirewallState {
+	if m.Firewall == nil {
+		return FirewallAbsent
+	}
+	return m.Firewall.Query(ctx)
+}
+
+// syncFirewall puts the rules for desiredState in place.
+func (m *IPManager) syncFirewall(ctx context.Context, desiredState bool) bool {
+	state := firewallStateFor(desiredState)
+	slog.Info("Applying firewall rules", "vip", m.cidrs(), "rules", state)
+	return m.Firewall.Apply(ctx, state) == nil
+}
+
+// RemoveFirewallRules removes all firewall rules, on exit.
+func (m *IPManager) RemoveFirewallRules(ctx context.Context) bool {
+	if m.Firewall == nil {
+		return true
+	}
+	slog.Info("Removing firewall rules", "vip", m.cidrs())
+	return m.Firewall.Remove(ctx) == nil
+}
+
+// ForceTakeover skips the ARP probe once, for when the other host that
+// answers for the virtual IP is known to be dead. It reports false if there
+// is no ARP probe.
+func (m *IPManager) ForceTakeover() bool {
+	if m.ArpProbe == nil {
+		return false
+	}
+	m.ArpProbe.Force()
+	m.recheck.Broadcast()
+	return true
+}
+
+// Ready is closed once the leader checker reported the first state.
+func (m *IPManager) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// LastApply returns when the apply loop last started to reconcile.
+func (m *IPManager) LastApply() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&m.lastApply))
+}
+
+// SyncStates follows the desired states received on states until ctx is
+// done, then releases the virtual IPs unless RetainOnExit is set.
+func (m *IPManager) SyncStates(ctx context.Context, states <-chan bool) {
+	ticker := time.NewTicker(10 * time.Second)
+
+	if m.StateFile != nil {
+		m.loadProvisionalState()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer handlePanic()
+		m.applyLoop(ctx)
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer handlePanic()
+		m.announcer.run(ctx)
+		wg.Done()
+	}()
+
+	go func() {
+		defer handlePanic()
+		m.watchLinks(ctx)
+	}()
+
+	if m.MaxConfirmAge > 0 {
+		wg.Add(1)
+		go func() {
+			defer handlePanic()
+			m.fenceLoop(ctx)
+			wg.Done()
+		}()
+	}
+
+	var persisted bool
+	for {
+		select {
+		case newState := <-states:
+			m.stateLock.Lock()
+			first := !m.stateReceived
+			provisional := m.currentState
+			m.setState(newState)
+			m.stateLock.Unlock()
+			m.observeLeader(newState)
+			if m.StateFile == nil || (!first && newState == persisted) {
+				continue
+			}
+			if first && !m.provisionalAt.IsZero() && newState != provisional {
+				slog.Warn("Leader checker reported a state other than the persisted one", "vip", m.cidrs(), "state", newState)
+			}
+			m.persistState(newState)
+			persisted = newState
+		case <-ticker.C:
+			m.recheck.Broadcast()
+		case <-ctx.Done():
+			m.stateLock.Lock()
+			m.recheck.Broadcast()
+			m.stateLock.Unlock()
+			wg.Wait()
+			for _, arpClient := range m.arpClients {
+				arpClient.Close()
+			}
+			return
+		}
+	}
+}
+
+// Announce tells the neighbours that the address moved to this host, with a
+// gratuitous ARP for IPv4 and an unsolicited neighbor advertisement for IPv6.
+func (m *IPManager) Announce(ctx context.Context, a *IPConfiguration) error {
+	// A macvlan is created on demand and other interfaces may have been
+	// created again since startup, so we need the current index
+	iface, err := net.InterfaceByName(a.iface.Name)
+	if err != nil {
+		slog.Error("Cannot announce address", "vip", a.GetCIDR(), "iface", a.iface.Name, "error", err)
+		return err
+	}
+
+	if a.vip.To4() != nil && m.arping {
+		return m.arpingGratuitous(ctx, a, iface)
+	}
+	if a.vip.To4() != nil {
+		return m.ARPSendGratuitous(a, iface)
+	}
+
+	err = sendUnsolicitedNA(ctx, iface, a.vip)
+	if err != nil {
+		slog.Error("Cannot send unsolicited neighbor advertisement", "vip", a.GetCIDR(), "iface", iface.Name, "error", err)
+	}
+	return err
+}
+
+func (m *IPManager) ARPSendGratuitous(a *IPConfiguration, iface *net.Interface) error {
+	arpClient := m.arpClients[iface.Name]
+	if arpClient == nil || m.arpIndexes[iface.Name] != iface.Index {
+		var err error
+		arpClient, err = arp.Dial(iface)
+		if err != nil {
+			slog.Error("Problems with producing the arp client", "iface", iface.Name, "error", err)
+			return err
+		}
+		defer arpClient.Close()
+	}
+
+	gratuitousPackage, err := arp.NewPacket(
+		arpReplyOp,
+		iface.HardwareAddr,
+		a.vip,
+		ethernetBroadcast,
+		net.IPv4bcast,
+	)
+	if err != nil {
+		slog.Error("Gratuitous arp package is malformed", "vip", a.GetCIDR(), "error", err)
+		return err
+	}
+
+	err = arpClient.WriteTo(gratuitousPackage, ethernetBroadcast)
+	if err != nil {
+		slog.Error("Cannot send gratuitous arp message", "vip", a.GetCIDR(), "iface", iface.Name, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// arpingGratuitous sends the gratuitous ARP with arping, for when we cannot
+// open a raw socket ourselves.
+func (m *IPManager) arpingGratuitous(ctx context.Context, a *IPConfiguration, iface *net.Interface) error {
+	_, stderr, exitCode, err := m.Commander.Run(ctx, "arping", "-A", "-c", "1", "-I", iface.Name, a.vip.String())
+	if err != nil {
+		err = &CommandError{Err: err, Output: strings.TrimSpace(string(stderr)), Class: commandFailed("arping", err, exitCode, stderr)}
+		slog.Error("Cannot send gratuitous arp message", "vip", a.GetCIDR(), "iface", iface.Name, "error", err)
+	}
+	return err
+}
+
+// AddressState is what was found on the interface for one virtual IP.
+type AddressState struct {
+	// Configured with the desired prefix length
+	Present bool
+	// The present address does not carry our label, someone else added it
+	Foreign bool
+	// IPv6 duplicate address detection is in progress or failed
+	Tentative bool
+	DADFailed bool
+	// Other prefix lengths the address is configured with, e.g. left over
+	// from an earlier configuration
+	StalePrefixes []int
+}
+
+func (m *IPManager) inSync(s AddressState, desiredState bool) bool {
+	if len(s.StalePrefixes) > 0 {
+		return false
+	}
+	if desiredState && (s.Tentative || s.DADFailed) {
+		return false
+	}
+	if s.Foreign {
+		switch m.ForeignPolicy {
+		case ForeignIgnore:
+			return true
+		case ForeignRemove:
+			return false
+		}
+	}
+	return s.Present == desiredState
+}
+
+func (m *IPManager) allInSync(states []AddressState, desiredState bool) bool {
+	for _, s := range states {
+		if !m.inSync(s, desiredState) {
+			return false
+		}
+	}
+	return true
+}
+
+// transitionCompleted records how long it took from the state change
+// reported by the leader checker until the addresses were in that state.
+// Announcements run in the background and are not included.
+func (m *IPManager) transitionCompleted(state bool, changedAt time.Time) {
+	direction := "release"
+	if state {
+		direction = "acquire"
+	}
+	duration := time.Since(changedAt)
+	m.history.add(TransitionRecord{Time: time.Now(), Kind: "completed", State: state, Duration: duration})
+	failoverDuration.With(direction).Observe(duration.Seconds())
+	hooks := m.startStep("hooks", "")
+	m.auditTransition(state, duration)
+	m.notifyTransition(state, duration)
+	hooks.End(nil)
+	m.stateLock.Lock()
+	m.lastTransition = time.Now()
+	m.endTransitionTrace("completed")
+	m.stateLock.Unlock()
+	// The same duration as in the histogram, the hooks are not included
+	slog.Info("Finished "+direction, "vip", m.cidrs(), "duration", duration.Round(time.Millisecond), "steps", m.steps.finish())
+}
+
+// QueryAddresses returns the state of all virtual IPs. It fails if the
+// addresses cannot be listed, e.g. because ip is missing or its output
+// cannot be parsed.
+func (m *IPManager) QueryAddresses() ([]AddressState, error) {
+	states := make([]AddressState, len(m.addresses))
+	for i, a := range m.addresses {
+		var err error
+		states[i], err = m.QueryAddress(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+// MarkedAddresses returns the virtual IPs that are configured with the
+// label vip-manager gives them, i.e. were added by a vip-manager.
+func (m *IPManager) MarkedAddresses() ([]string, error) {
+	if m.Carp != nil || m.ProxyArp != nil {
+		return nil, nil
+	}
+	var marked []string
+	for _, a := range m.addresses {
+		label := a.Label()
+		if label == "" {
+			continue
+		}
+		addresses, err := listAddresses(m.Commander, a.iface.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addresses {
+			if addr.ip.Equal(a.vip) && addr.label == label {
+				marked = append(marked, a.GetCIDR())
+				break
+			}
+		}
+	}
+	return marked, nil
+}
+
+// QueryAddress returns the state of a on its interface.
+func (m *IPManager) QueryAddress(a *IPConfiguration) (AddressState, error) {
+	if m.Carp != nil {
+		return AddressState{Present: m.Carp.Leading()}, nil
+	}
+	if m.ProxyArp != nil {
+		return AddressState{Present: m.ProxyArp.Published(a)}, nil
+	}
+
+	var state AddressState
+	desiredPrefix := NetmaskSize(a.netmask)
+	addresses, err := listAddresses(m.Commander, a.iface.Name)
+	if err != nil {
+		return state, err
+	}
+	for _, addr := range addresses {
+		if !addr.ip.Equal(a.vip) {
+			continue
+		}
+		if addr.prefix == desiredPrefix {
+			state.Present = true
+			state.Tentative = addr.tentative
+			state.DADFailed = addr.dadFailed
+			if label := a.Label(); label != "" && addr.label != label {
+				state.Foreign = true
+				slog.Warn("Address is not labeled as ours, it was not added by vip-manager",
+					"vip", a.GetCIDR(), "iface", a.iface.Name, "label", label, "policy", m.ForeignPolicy)
+			}
+		} else {
+			state.StalePrefixes = append(state.StalePrefixes, addr.prefix)
+		}
+	}
+	return state, nil
+}
+
+// interfaceAddress is an address as configured on an interface
+type interfaceAddress struct {
+	ip     net.IP
+	prefix int
+	label  string
+
+	tentative bool
+	dadFailed bool
+}
+
+// ConfigureAddress adds a to its interface and reports whether that worked.
+func (m *IPManager) ConfigureAddress(ctx context.Context, a *IPConfiguration) bool {
+	slog.Info("Configuring address", "vip", a.GetCIDR(), "iface", a.iface.Name)
+	step := m.startStep("configure address", "configure", "vip", a.GetCIDR(), "iface", a.iface.Name)
+	ok := m.changeAddress(ctx, a, "add")
+	step.End(stepError(ok))
+	if ok {
+		m.setAdded(a, true)
+		if m.ServiceRegistry != nil {
+			m.ServiceRegistry.Register(a.vip.String())
+		}
+	}
+	return ok
+}
+
+// DeconfigureAddress removes a from its interface and reports whether that
+// worked.
+func (m *IPManager) DeconfigureAddress(ctx context.Context, a *IPConfiguration) bool {
+	m.announcer.Cancel(a)
+	if m.ServiceRegistry != nil {
+		m.ServiceRegistry.Deregister(a.vip.String())
+	}
+	slog.Info("Removing address", "vip", a.GetCIDR(), "iface", a.iface.Name)
+	step := m.startStep("remove address", "", "vip", a.GetCIDR(), "iface", a.iface.Name)
+	ok := m.changeAddress(ctx, a, "delete")
+	step.End(stepError(ok))
+	if ok {
+		m.setAdded(a, false)
+	}
+	return ok
+}
+
+// changeAddress adds or removes the address, or in CARP mode makes this node
+// win or lose the election for it, or in proxy ARP mode publishes it.
+func (m *IPManager) changeAddress(ctx context.Context, a *IPConfiguration, action string) bool {
+	if m.Carp != nil {
+		return m.Carp.Set(ctx, action == "add")
+	}
+	if m.ProxyArp != nil {
+		return m.ProxyArp.Set(ctx, a, action == "add")
+	}
+	return m.runAddressConfiguration(ctx, a, action)
+}
+
+func (m *IPManager) setAdded(a *IPConfiguration, added bool) {
+	for i := range m.addresses {
+		if m.addresses[i] == a {
+			m.added[i] = added
+		}
+	}
+}
+
+// RemoveStalePrefix removes the virtual IP configured with a prefix length
+// other than the desired one.
+func (m *IPManager) RemoveStalePrefix(ctx context.Context, a *IPConfiguration, prefix int) bool {
+	cidr := fmt.Sprintf("%s/%d", a.vip, prefix)
+	slog.Info("Removing address, it does not match the configured prefix", "address", cidr, "iface", a.iface.Name, "vip", a.GetCIDR())
+	return m.removeAddress(ctx, cidr, a.iface.Name)
+}
@@ -0,0 +1,288 @@
+//go:build !solaris
+// +build !solaris
+
+package ipmanager
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// How long a shutdown may take in the tests before it counts as hung
+const testShutdownTimeout = 5 * time.Second
+
+// count returns how many of the commands start with prefix.
+func count(commands []string, prefix string) int {
+	n := 0
+	for _, c := range commands {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// TestShutdownDuringTransition cancels the context at random points while
+// the address is being added. Every time, the apply loop has to exit and
+// remove the address exactly once if it added it.
+func TestShutdownDuringTransition(t *testing.T) {
+	iterations := 2000
+	if testing.Short() {
+		iterations = 200
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < iterations; i++ {
+		states := make(chan bool, 1)
+		m, ip, a := newFakeManager(t, "fd00::10", states, ManagerOptions{ShutdownGracePeriod: testShutdownTimeout})
+		// A command takes up to 100µs, so the cancellation lands before,
+		// during or after adding the address
+		delay := time.Duration(rnd.Int63n(int64(100 * time.Microsecond)))
+		ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+			time.Sleep(delay)
+			return nil
+		}
+		cancelAfter := time.Duration(rnd.Int63n(int64(500 * time.Microsecond)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			m.SyncStates(ctx, states)
+			close(done)
+		}()
+		states <- true
+		time.Sleep(cancelAfter)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(testShutdownTimeout):
+			t.Fatalf("iteration %d: SyncStates did not return after the context was cancelled", i)
+		}
+
+		commands := ip.commands()
+		adds := count(commands, "ip addr add")
+		deletes := count(commands, "ip addr delete")
+		if ip.has(a.iface.Name, a.GetCIDR()) || adds > 1 || deletes != adds {
+			t.Fatalf("iteration %d: address left behind %t after %d adds and %d deletes: %q",
+				i, ip.has(a.iface.Name, a.GetCIDR()), adds, deletes, commands)
+		}
+	}
+}
+
+// TestShutdownWhileStatesChange keeps flipping the state until the shutdown,
+// which stops the checker and cancels the context like main does, from
+// several goroutines at once. The shutdown races with changes that are
+// queued or being applied, and SyncStates has to return every time, without
+// the address.
+func TestShutdownWhileStatesChange(t *testing.T) {
+	iterations := 500
+	if testing.Short() {
+		iterations = 50
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < iterations; i++ {
+		states := make(chan bool)
+		m, ip, a := newFakeManager(t, "fd00::10", states, ManagerOptions{ShutdownGracePeriod: testShutdownTimeout})
+		delay := time.Duration(rnd.Int63n(int64(200 * time.Microsecond)))
+		ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+			time.Sleep(delay)
+			return nil
+		}
+		cancelAfter := time.Duration(rnd.Int63n(int64(2 * time.Millisecond)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		checkerCtx, stopChecker := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			m.SyncStates(ctx, states)
+			close(done)
+		}()
+		// The checker, sending until it is stopped
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for state := true; ; state = !state {
+				select {
+				case states <- state:
+				case <-checkerCtx.Done():
+					return
+				}
+			}
+		}()
+		// Exit signals may arrive more than once and from anywhere
+		time.Sleep(cancelAfter)
+		for j := 0; j < 3; j++ {
+			go func() {
+				stopChecker()
+				cancel()
+			}()
+		}
+		select {
+		case <-done:
+		case <-time.After(testShutdownTimeout):
+			t.Fatalf("iteration %d: SyncStates did not return after the context was cancelled", i)
+		}
+		stopChecker()
+		cancel()
+		<-stopped
+
+		if ip.has(a.iface.Name, a.GetCIDR()) {
+			t.Fatalf("iteration %d: address left behind: %q", i, ip.commands())
+		}
+	}
+}
+
+// TestShutdownCancelsSlowCommand shuts down while adding the address hangs.
+// The command has to be cancelled once the grace period is over, so that
+// SyncStates returns.
+func TestShutdownCancelsSlowCommand(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	states := make(chan bool, 1)
+	m, ip, _ := newFakeManager(t, "fd00::10", states, ManagerOptions{ShutdownGracePeriod: grace})
+	started := make(chan struct{})
+	killed := make(chan error, 1)
+	ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+		if argv[1] != "addr" || argv[2] != "add" {
+			return nil
+		}
+		close(started)
+		<-ctx.Done()
+		killed <- ctx.Err()
+		return &fakeResult{exitCode: -1, err: ctx.Err()}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.SyncStates(ctx, states)
+		close(done)
+	}()
+	states <- true
+	select {
+	case <-started:
+	case <-time.After(testShutdownTimeout):
+		t.Fatal("the address was not added")
+	}
+	cancelled := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(testShutdownTimeout):
+		t.Fatal("SyncStates did not return, the slow command was not cancelled")
+	}
+	select {
+	case err := <-killed:
+		if err == nil {
+			t.Error("the slow command returned without its context being done")
+		}
+	default:
+		t.Error("the slow command was not cancelled")
+	}
+	if took := time.Since(cancelled); took < grace {
+		t.Errorf("the slow command was cancelled after %s, before the grace period of %s", took, grace)
+	}
+}
+
+// TestShutdownCancelsSlowFirewall does the same for nft, whose rules are
+// applied after the address was added.
+func TestShutdownCancelsSlowFirewall(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	states := make(chan bool, 1)
+	firewall := &NftFirewall{table: nftTableName("test")}
+	m, ip, _ := newFakeManager(t, "fd00::10", states, ManagerOptions{ShutdownGracePeriod: grace, Firewall: firewall})
+	started := make(chan struct{})
+	var once sync.Once
+	killed := make(chan error, 2)
+	ip.hook = func(ctx context.Context, argv []string) *fakeResult {
+		if argv[0] != "nft" {
+			return nil
+		}
+		if argv[1] == "list" {
+			return exit(1, "Error: No such file or directory")
+		}
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		killed <- ctx.Err()
+		return &fakeResult{exitCode: -1, err: ctx.Err()}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.SyncStates(ctx, states)
+		close(done)
+	}()
+	states <- true
+	select {
+	case <-started:
+	case <-time.After(testShutdownTimeout):
+		t.Fatal("the firewall rules were not applied")
+	}
+	cancelled := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(testShutdownTimeout):
+		t.Fatal("SyncStates did not return, nft was not cancelled")
+	}
+	select {
+	case err := <-killed:
+		if err == nil {
+			t.Error("nft returned without its context being done")
+		}
+	default:
+		t.Error("nft was not cancelled")
+	}
+	if took := time.Since(cancelled); took < grace {
+		t.Errorf("nft was cancelled after %s, before the grace period of %s", took, grace)
+	}
+}
+
+func TestReleaseOnExit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		label   string
+		added   bool
+		options ManagerOptions
+		// Whether the address is removed
+		want bool
+	}{
+		{name: "added by this process", cidr: "10.1.2.3/24", label: "eth0:vip", added: true, want: true},
+		{name: "labeled by an earlier run", cidr: "10.1.2.3/24", label: "eth0:vip", want: true},
+		{name: "not labeled", cidr: "10.1.2.3/24", label: "eth0"},
+		{name: "not labeled, forced", cidr: "10.1.2.3/24", label: "eth0", options: ManagerOptions{ForceReleaseOnExit: true}, want: true},
+		{name: "not labeled, ignored", cidr: "10.1.2.3/24", label: "eth0",
+			options: ManagerOptions{ForceReleaseOnExit: true, ForeignPolicy: ForeignIgnore}},
+		// IPv6 addresses carry no label, so one from an earlier run cannot
+		// be told apart from one added by someone else
+		{name: "IPv6 added by this process", cidr: "fd00::10/64", added: true, want: true},
+		{name: "IPv6 from an earlier run", cidr: "fd00::10/64"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := newFakeIP("eth0")
+			ip.add("eth0", test.cidr, test.label)
+			vip, ipNet, err := net.ParseCIDR(test.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := NewIPConfiguration(vip, ipNet.Mask, net.Interface{Name: "eth0"}, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.options.Commander = ip
+			m := &IPManager{ManagerOptions: test.options, addresses: []*IPConfiguration{a},
+				added: []bool{test.added}, announcer: newAnnouncer(nil, nil, nil)}
+
+			m.releaseOnExit(context.Background())
+			if removed := !ip.has("eth0", test.cidr); removed != test.want {
+				t.Errorf("removed = %t, want %t: %q", removed, test.want, ip.commands())
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package ipmanager
+
+import (
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// leaderObservation is the value of the leader key the manager saw last.
+// Only touched by SyncStates.
+type leaderObservation struct {
+	seen  bool
+	value string
+}
+
+// observeLeader records a change of the leader key in the transition
+// history and the audit log, also when the leadership moved between two
+// other nodes. The leader checker logs it.
+func (m *IPManager) observeLeader(state bool) {
+	key, value, at := checker.LastValue()
+	if at.IsZero() || (m.leader.seen && value == m.leader.value) {
+		return
+	}
+	previous := m.leader.value
+	m.leader = leaderObservation{seen: true, value: value}
+	revision := checker.LastRevision()
+	m.history.add(TransitionRecord{Time: time.Now(), Kind: "leader", State: state,
+		Leader: value, PreviousLeader: previous, Revision: revision})
+	m.auditLeaderChange(key, previous, value, revision)
+}
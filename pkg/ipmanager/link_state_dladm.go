@@ -0,0 +1,22 @@
+//go:build solaris
+// +build solaris
+
+package ipmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkCarrier returns an error describing why traffic on iface would go
+// nowhere, using the state of the datalink of the same name.
+func checkCarrier(iface string) error {
+	output, err := commandOutput(newCommand("dladm", "show-link", "-p", "-o", "state", iface))
+	if err != nil {
+		return fmt.Errorf("cannot read state of %s: %s", iface, err)
+	}
+	if state := strings.TrimSpace(string(output)); state != "up" {
+		return fmt.Errorf("link %s is %s", iface, state)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !solaris
+// +build !solaris
+
+package ipmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+func readLinkAttribute(iface, name string) (string, error) {
+	value, err := ioutil.ReadFile(filepath.Join("/sys/class/net", iface, name))
+	return strings.TrimSpace(string(value)), err
+}
+
+// checkCarrier returns an error describing why traffic on iface would go
+// nowhere. Adding an address to a link without carrier works, but it
+// blackholes all traffic and announcements.
+func checkCarrier(iface string) error {
+	carrier, err := readLinkAttribute(iface, "carrier")
+	if err == nil && carrier == "1" {
+		return nil
+	}
+	// Reading carrier fails while the link is administratively down
+	operstate, stateErr := readLinkAttribute(iface, "operstate")
+	if stateErr != nil {
+		return fmt.Errorf("cannot read state of %s: %s", iface, stateErr)
+	}
+	return fmt.Errorf("interface %s has no carrier, operstate %s", iface, operstate)
+}
@@ -0,0 +1,87 @@
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	// How often to log that we are still waiting for an interface
+	interfaceWaitLogInterval = 10 * time.Second
+	// How often to look for the interface anyway, in case a link event
+	// was missed
+	interfaceWaitPollInterval = 10 * time.Second
+)
+
+// WaitForInterface returns the interface called name once it exists, e.g.
+// a bond that comes up late during boot or a hotplugged NIC. It is woken up
+// by link events where we can subscribe to them and polls otherwise. A
+// timeout of 0 waits until ctx is done.
+func WaitForInterface(ctx context.Context, name string, timeout time.Duration) (*net.Interface, error) {
+	if iface, err := net.InterfaceByName(name); err == nil {
+		return iface, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pollInterval := interfaceWaitPollInterval
+	events, err := linkEvents(ctx)
+	if err != nil {
+		slog.Warn("Cannot subscribe to link events, polling instead", "error", err)
+	}
+	if events == nil {
+		pollInterval = linkRecheckInterval
+	}
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	progress := time.NewTicker(interfaceWaitLogInterval)
+	defer progress.Stop()
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	started := time.Now()
+	slog.Warn("Interface does not exist, waiting for it", "iface", name, "timeout", timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, fmt.Errorf("interface %s did not appear within %s", name, timeout)
+		case <-progress.C:
+			slog.Info("Still waiting for interface", "iface", name, "waited", time.Since(started).Round(time.Second))
+			continue
+		case _, ok := <-events:
+			if !ok {
+				// Subscription failed, keep polling
+				events = nil
+			}
+		case <-poll.C:
+		}
+		if iface, err := net.InterfaceByName(name); err == nil {
+			slog.Info("Interface appeared", "iface", name, "waited", time.Since(started).Round(time.Millisecond))
+			return iface, nil
+		}
+	}
+}
+
+// watchLinks rechecks the addresses on every link event, e.g. when an
+// interface disappears, appears again or gets carrier.
+func (m *IPManager) watchLinks(ctx context.Context) {
+	events, err := linkEvents(ctx)
+	if err != nil {
+		slog.Warn("Cannot subscribe to link events, changes of the interfaces are noticed within 10 seconds", "error", err)
+	}
+	if events == nil {
+		return
+	}
+	for range events {
+		m.recheck.Broadcast()
+	}
+}
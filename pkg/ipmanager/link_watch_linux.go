@@ -0,0 +1,50 @@
+package ipmanager
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// How often the netlink socket looks whether it should be closed
+const linkEventsReadTimeout = time.Second
+
+// linkEvents delivers a value whenever a network link is added, removed or
+// changed, until ctx is done. Events that arrive while one is still pending
+// are coalesced.
+func linkEvents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1 << (syscall.RTNLGRP_LINK - 1)}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	timeout := syscall.NsecToTimeval(int64(linkEventsReadTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		buf := make([]byte, 8192)
+		for ctx.Err() == nil {
+			// Every message of the group is about a link, what changed
+			// does not matter, the caller looks itself
+			if _, _, err := syscall.Recvfrom(fd, buf, 0); err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			} else if err != nil {
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
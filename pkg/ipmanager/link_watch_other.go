@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package ipmanager
+
+import "context"
+
+// linkEvents is not supported here, callers poll.
+func linkEvents(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package ipmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// Interfaces created by us carry this alias, so they can be told apart from
+// macvlans set up by someone else.
+const macvlanAlias = "vip-manager"
+
+// Macvlan is a sub-interface with a fixed MAC address that only exists while
+// we hold the virtual IP, so the MAC moves together with the address.
+type Macvlan struct {
+	parent string
+	name   string
+	mac    net.HardwareAddr
+}
+
+type linkInfo struct {
+	Name     string `json:"ifname"`
+	Alias    string `json:"ifalias"`
+	LinkInfo struct {
+		Kind string `json:"info_kind"`
+	} `json:"linkinfo"`
+}
+
+// NewMacvlan describes the macvlan name on parent with the MAC address mac.
+// It is not created until the virtual IP is configured.
+func NewMacvlan(parent, name, mac string) (*Macvlan, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid macvlan MAC address %q: %s", mac, err)
+	}
+	return &Macvlan{parent: parent, name: name, mac: hwAddr}, nil
+}
+
+// Interface describes the macvlan for address management. The index is not
+// known in advance, as the interface is created on demand.
+func (v *Macvlan) Interface() net.Interface {
+	return net.Interface{Name: v.name, HardwareAddr: v.mac}
+}
+
+func (v *Macvlan) query() (*linkInfo, error) {
+	output, err := commandOutput(newCommand("ip", "-j", "-d", "link", "show", "dev", v.name))
+	if err != nil {
+		// Not existing is the common case
+		return nil, nil
+	}
+	var links []linkInfo
+	if err := json.Unmarshal(output, &links); err != nil {
+		return nil, fmt.Errorf("cannot parse ip link output: %s", err)
+	}
+	if len(links) == 0 {
+		return nil, nil
+	}
+	return &links[0], nil
+}
+
+// CheckOwnership refuses to work with an existing interface of the same name
+// that was not created by vip-manager. Our own leftovers are fine, they are
+// adopted or cleaned up by the reconcile loop.
+func (v *Macvlan) CheckOwnership() error {
+	link, err := v.query()
+	if err != nil || link == nil {
+		return err
+	}
+	if link.LinkInfo.Kind != "macvlan" || link.Alias != macvlanAlias {
+		return fmt.Errorf("interface %s already exists and is not managed by vip-manager", v.name)
+	}
+	return nil
+}
+
+func (v *Macvlan) Exists() bool {
+	link, err := v.query()
+	if err != nil {
+		slog.Error("Cannot query macvlan", "macvlan", v.name, "error", err)
+	}
+	return link != nil
+}
+
+// Ensure creates the macvlan if needed and brings it up.
+func (v *Macvlan) Ensure() error {
+	if err := v.CheckOwnership(); err != nil {
+		return err
+	}
+	if !v.Exists() {
+		slog.Info("Creating macvlan", "macvlan", v.name, "iface", v.parent, "mac", v.mac)
+		err := v.run("link", "add", "link", v.parent, "name", v.name,
+			"address", v.mac.String(), "type", "macvlan", "mode", "bridge")
+		if err != nil {
+			return err
+		}
+		if err := v.run("link", "set", "dev", v.name, "alias", macvlanAlias); err != nil {
+			return err
+		}
+	}
+	return v.run("link", "set", "dev", v.name, "address", v.mac.String(), "up")
+}
+
+func (v *Macvlan) Remove() error {
+	if !v.Exists() {
+		return nil
+	}
+	if err := v.CheckOwnership(); err != nil {
+		return err
+	}
+	slog.Info("Removing macvlan", "macvlan", v.name)
+	return v.run("link", "delete", "dev", v.name)
+}
+
+func (v *Macvlan) run(args ...string) error {
+	if skipDryRun("ip", args...) {
+		return nil
+	}
+	output, err := commandCombinedOutput(newCommand("ip", args...))
+	if err != nil {
+		return fmt.Errorf("ip %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package ipmanager
+
+import "log/slog"
+
+// SetMaintenance freezes the addresses while active: desired states are
+// still recorded, but nothing is added or removed. Leaving maintenance
+// reconciles to the latest desired state right away. It is not persisted,
+// a restart always starts without maintenance.
+func (m *IPManager) SetMaintenance(active bool) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.maintenance == active {
+		return
+	}
+	m.maintenance = active
+	m.releaseRequested = false
+	if active {
+		slog.Warn("Entering maintenance mode, the virtual IP is neither added nor removed", "vip", m.cidrs())
+	} else {
+		slog.Warn("Leaving maintenance mode", "vip", m.cidrs(), "state", m.currentState)
+	}
+	m.recheck.Broadcast()
+}
+
+// Maintenance reports whether maintenance mode is active.
+func (m *IPManager) Maintenance() bool {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	return m.maintenance
+}
+
+// ForceRelease enters maintenance mode and removes the addresses once, e.g.
+// to move the virtual IP away by hand while the leader key still names this
+// node. They stay removed until maintenance mode is left.
+func (m *IPManager) ForceRelease() {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if !m.maintenance {
+		slog.Warn("Entering maintenance mode, the virtual IP is neither added nor removed", "vip", m.cidrs())
+	}
+	slog.Warn("Releasing the virtual IP on request", "vip", m.cidrs())
+	m.maintenance = true
+	m.releaseRequested = true
+	m.recheck.Broadcast()
+}
+
+// Reconcile makes the apply loop check the addresses right away, instead of
+// waiting for the next recheck or the end of the backoff after failures.
+func (m *IPManager) Reconcile() {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.reconcileRequested = true
+	m.recheck.Broadcast()
+}
@@ -0,0 +1,92 @@
+package ipmanager
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// Neighbor discovery messages must be sent with the maximum hop limit
+	ndpHopLimit = 255
+	// Override flag of a neighbor advertisement
+	ndpOverrideFlag = 0x20
+	// Target link-layer address option
+	ndpTargetLinkLayerOption = 2
+
+	// A freshly added IPv6 address cannot be used as source address while
+	// duplicate address detection is running.
+	ndpTentativeWait = 3 * time.Second
+)
+
+var ipv6AllNodes = net.ParseIP("ff02::1")
+
+// sendUnsolicitedNA is the IPv6 counterpart of a gratuitous ARP, it tells
+// all nodes on the link that vip is now reachable at the interface address.
+func sendUnsolicitedNA(ctx context.Context, iface *net.Interface, vip net.IP) error {
+	var c *icmp.PacketConn
+	var err error
+	deadline := time.Now().Add(ndpTentativeWait)
+	for {
+		c, err = icmp.ListenPacket("ip6:ipv6-icmp", vip.String())
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	pc := c.IPv6PacketConn()
+	if err := pc.SetMulticastHopLimit(ndpHopLimit); err != nil {
+		return err
+	}
+	if err := pc.SetMulticastInterface(iface); err != nil {
+		return err
+	}
+
+	body := make([]byte, 0, 4+net.IPv6len+8)
+	body = append(body, ndpOverrideFlag, 0, 0, 0)
+	body = append(body, vip.To16()...)
+	body = append(body, ndpTargetLinkLayerOption, 1)
+	body = append(body, iface.HardwareAddr...)
+
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborAdvertisement,
+		Body: &icmp.DefaultMessageBody{Data: body},
+	}
+	// The kernel fills in the checksum for ICMPv6 raw sockets
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.WriteTo(b, &net.IPAddr{IP: ipv6AllNodes, Zone: iface.Name})
+	return err
+}
+
+// lookupNeighbor returns the MAC address the neighbor table has for ip, or
+// an empty string if there is none.
+func lookupNeighbor(iface string, ip net.IP) string {
+	output, err := commandOutput(newCommand("ip", "-j", "neigh", "show", "to", ip.String(), "dev", iface))
+	if err != nil {
+		return ""
+	}
+	var neighbors []struct {
+		LLAddr string `json:"lladdr"`
+	}
+	if json.Unmarshal(output, &neighbors) != nil || len(neighbors) == 0 {
+		return ""
+	}
+	return neighbors[0].LLAddr
+}
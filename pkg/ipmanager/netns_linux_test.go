@@ -0,0 +1,281 @@
+//go:build integration
+// +build integration
+
+package ipmanager
+
+// The integration tests run the IPManager against the kernel, in a network
+// namespace of their own with a veth pair, and check the addresses via
+// netlink. They need root or CAP_NET_ADMIN and CAP_SYS_ADMIN, and unshare:
+//
+//	go test -tags integration ./pkg/ipmanager/
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Set in the test binary that runs inside the namespace
+const netnsEnv = "VIP_MANAGER_TEST_NETNS"
+
+const (
+	netnsIface = "vipm0"
+	netnsPeer  = "vipm1"
+	// How long the kernel state may take to follow
+	netnsTimeout = 10 * time.Second
+)
+
+// TestNetns runs itself again in a new network namespace, where the tests
+// of the namespace are free to change the interfaces.
+func TestNetns(t *testing.T) {
+	if os.Getenv(netnsEnv) != "" {
+		t.Skip("already in the namespace")
+	}
+	if !hasNetAdmin() {
+		t.Skip("needs root or CAP_NET_ADMIN and CAP_SYS_ADMIN")
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		t.Skip("needs unshare")
+	}
+	// With a mount namespace for a sysfs that shows the interfaces of the
+	// new network namespace
+	args := []string{"--net", "--mount", "sh", "-c", `mount -t sysfs sysfs /sys && exec "$0" "$@"`,
+		os.Args[0], "-test.run", "^TestInNetns", "-test.count=1"}
+	if testing.Verbose() {
+		args = append(args, "-test.v")
+	}
+	cmd := exec.Command(unshare, args...)
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	output, err := cmd.CombinedOutput()
+	t.Logf("%s", output)
+	if err != nil {
+		t.Fatalf("tests in the namespace failed: %v", err)
+	}
+}
+
+func TestInNetnsLifecycle(t *testing.T) {
+	iface := setupNetns(t)
+	vip := net.ParseIP("192.0.2.10")
+	a, err := NewIPConfiguration(vip, net.CIDRMask(24, 32), iface, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan bool, 1)
+	m, err := NewIPManager([]*IPConfiguration{a}, states, ManagerOptions{ShutdownGracePeriod: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.SyncStates(ctx, states)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	want := kernelAddress{prefix: 24, label: netnsIface + ":vip"}
+	states <- true
+	waitForAddress(t, iface, vip, &want)
+
+	// Someone else removes it, the next recheck adds it again
+	runIP(t, "addr", "del", "192.0.2.10/24", "dev", netnsIface)
+	waitForAddress(t, iface, vip, nil)
+	m.Reconcile()
+	waitForAddress(t, iface, vip, &want)
+
+	// A copy with another prefix is replaced
+	runIP(t, "addr", "del", "192.0.2.10/24", "dev", netnsIface)
+	runIP(t, "addr", "add", "192.0.2.10/16", "dev", netnsIface)
+	m.Reconcile()
+	waitForAddress(t, iface, vip, &want)
+
+	// Losing the leadership removes it
+	states <- false
+	waitForAddress(t, iface, vip, nil)
+
+	states <- true
+	waitForAddress(t, iface, vip, &want)
+
+	// So does exiting, as we added it
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(netnsTimeout):
+		t.Fatal("SyncStates did not return after the context was cancelled")
+	}
+	waitForAddress(t, iface, vip, nil)
+}
+
+func TestInNetnsForeignAddressKept(t *testing.T) {
+	iface := setupNetns(t)
+	vip := net.ParseIP("192.0.2.20")
+	// Configured statically, without our label
+	runIP(t, "addr", "add", "192.0.2.20/24", "dev", netnsIface)
+	a, err := NewIPConfiguration(vip, net.CIDRMask(24, 32), iface, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan bool, 1)
+	m, err := NewIPManager([]*IPConfiguration{a}, states, ManagerOptions{ShutdownGracePeriod: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.SyncStates(ctx, states)
+		close(done)
+	}()
+	states <- true
+	select {
+	case <-m.Ready():
+	case <-time.After(netnsTimeout):
+		t.Fatal("no state received")
+	}
+	cancel()
+	<-done
+	want := kernelAddress{prefix: 24}
+	waitForAddress(t, iface, vip, &want)
+}
+
+// setupNetns creates the veth pair, which is removed again at the end of
+// the test.
+func setupNetns(t *testing.T) net.Interface {
+	t.Helper()
+	if os.Getenv(netnsEnv) == "" {
+		t.Skip("only runs in the namespace created by TestNetns")
+	}
+	runIP(t, "link", "add", netnsIface, "type", "veth", "peer", "name", netnsPeer)
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", netnsIface).Run()
+	})
+	runIP(t, "link", "set", netnsIface, "up")
+	runIP(t, "link", "set", netnsPeer, "up")
+	iface, err := net.InterfaceByName(netnsIface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *iface
+}
+
+func runIP(t *testing.T, args ...string) {
+	t.Helper()
+	if output, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		t.Fatalf("ip %s: %v: %s", strings.Join(args, " "), err, output)
+	}
+}
+
+// kernelAddress is an address as the kernel reports it via netlink.
+type kernelAddress struct {
+	prefix int
+	label  string
+}
+
+// waitForAddress waits until vip is configured on iface exactly as want,
+// or is not configured at all if want is nil.
+func waitForAddress(t *testing.T, iface net.Interface, vip net.IP, want *kernelAddress) {
+	t.Helper()
+	deadline := time.Now().Add(netnsTimeout)
+	var got []kernelAddress
+	for {
+		var err error
+		got, err = netlinkAddresses(iface.Index, vip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (want == nil && len(got) == 0) || (want != nil && len(got) == 1 && got[0] == *want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s on %s is %+v, want %+v", vip, iface.Name, got, want)
+}
+
+// netlinkAddresses returns the copies of vip on the interface with index.
+func netlinkAddresses(index int, vip net.IP) ([]kernelAddress, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var found []kernelAddress
+	for _, msg := range messages {
+		if msg.Header.Type != syscall.RTM_NEWADDR {
+			continue
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&msg.Data[0]))
+		if int(ifa.Index) != index {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+		if err != nil {
+			return nil, err
+		}
+		var a kernelAddress
+		match := false
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				match = net.IP(attr.Value).Equal(vip)
+			case syscall.IFA_LABEL:
+				a.label = strings.TrimRight(string(attr.Value), "\x00")
+			}
+		}
+		if !match {
+			continue
+		}
+		a.prefix = int(ifa.Prefixlen)
+		// Addresses without a label of their own carry the interface name
+		if a.label == netnsIfaceName(index) {
+			a.label = ""
+		}
+		found = append(found, a)
+	}
+	return found, nil
+}
+
+func netnsIfaceName(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return ""
+	}
+	return iface.Name
+}
+
+// hasNetAdmin reports whether the effective capabilities include
+// CAP_NET_ADMIN, and CAP_SYS_ADMIN for the namespaces.
+func hasNetAdmin() bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		return err == nil && caps&(1<<12) != 0 && caps&(1<<21) != 0
+	}
+	return false
+}
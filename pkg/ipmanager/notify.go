@@ -0,0 +1,124 @@
+package ipmanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// Event types
+const (
+	EventAcquire = "acquire"
+	EventRelease = "release"
+	// The leadership was lost and the release waits for the grace period,
+	// or was cancelled because the leadership returned in the meantime
+	EventReleasePending   = "release_pending"
+	EventReleaseCancelled = "release_cancelled"
+	// The DCS could not be read for a while, and could be read again
+	EventDCSDown = "dcs_down"
+	EventDCSUp   = "dcs_up"
+	// Adding or removing the virtual IP failed, it is retried
+	EventOperationFailed = "operation_failed"
+)
+
+// Event is something a notifier is told about, e.g. a completed failover.
+type Event struct {
+	Type string
+	// All virtual IPs and their interfaces, separated by commas
+	VIP       string
+	Interface string
+	// Only for transitions
+	OldState bool
+	NewState bool
+	// The leader key and its value that caused the transition
+	TriggerKey   string
+	TriggerValue string
+	Time         time.Time
+	// From the change of the desired state to the end of the transition
+	Duration time.Duration
+	// Only for failed operations
+	Error string
+}
+
+// Notifier is told about events. Notify is called from the apply loop and
+// must not block, e.g. by queueing the event for a goroutine of its own.
+type Notifier interface {
+	Notify(e Event)
+}
+
+func (m *IPManager) notifyTransition(state bool, duration time.Duration) {
+	if len(m.Notifiers) == 0 {
+		return
+	}
+	key, value, _ := checker.LastValue()
+	e := Event{
+		Type:         EventRelease,
+		VIP:          m.cidrs(),
+		Interface:    m.interfaces(),
+		OldState:     !state,
+		NewState:     state,
+		TriggerKey:   key,
+		TriggerValue: value,
+		Time:         time.Now(),
+		Duration:     duration,
+	}
+	if state {
+		e.Type = EventAcquire
+	}
+	m.notify(e)
+}
+
+// notifyDrain tells the notifiers about the start or the end of the release
+// grace period, typ is EventReleasePending or EventReleaseCancelled.
+func (m *IPManager) notifyDrain(typ string) {
+	if len(m.Notifiers) == 0 {
+		return
+	}
+	key, value, _ := checker.LastValue()
+	m.notify(Event{
+		Type:         typ,
+		VIP:          m.cidrs(),
+		Interface:    m.interfaces(),
+		OldState:     true,
+		NewState:     typ == EventReleaseCancelled,
+		TriggerKey:   key,
+		TriggerValue: value,
+		Time:         time.Now(),
+	})
+}
+
+func (m *IPManager) notifyFailure(operation string) {
+	if len(m.Notifiers) == 0 {
+		return
+	}
+	m.notify(Event{
+		Type:      EventOperationFailed,
+		VIP:       m.cidrs(),
+		Interface: m.interfaces(),
+		NewState:  operation == "add",
+		OldState:  operation != "add",
+		Time:      time.Now(),
+		Error:     fmt.Sprintf("failed to %s the virtual IP, %d failures in a row", operation, m.failures),
+	})
+}
+
+func (m *IPManager) notify(e Event) {
+	for _, n := range m.Notifiers {
+		n.Notify(e)
+	}
+}
+
+// interfaces lists the interfaces of the virtual IPs, each once.
+func (m *IPManager) interfaces() string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, a := range m.addresses {
+		if !seen[a.iface.Name] {
+			seen[a.iface.Name] = true
+			names = append(names, a.iface.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,112 @@
+package ipmanager
+
+import (
+	"context"
+	"time"
+)
+
+// Change is what Once did with one virtual IP.
+type Change struct {
+	Address   string
+	Interface string
+	// added, removed, already present, already absent, or with dry run
+	// would add and would remove
+	Result string
+}
+
+// Once brings the virtual IPs into state and returns what was done,
+// instead of following the leader checker like SyncStates. The delays
+// before changing the state do not apply. Waiting for a backoff, the
+// primary check or duplicate address detection ends when ctx is done, the
+// changes made until then are returned with the error.
+func (m *IPManager) Once(ctx context.Context, state bool) ([]Change, error) {
+	defer func() {
+		for _, arpClient := range m.arpClients {
+			arpClient.Close()
+		}
+	}()
+
+	start := time.Now()
+	m.stateLock.Lock()
+	m.currentState = state
+	m.stateLock.Unlock()
+
+	before, err := m.QueryAddresses()
+	if err != nil {
+		return nil, err
+	}
+	after, err := m.converge(ctx, before, state)
+	if err != nil {
+		return m.changes(before, after, state), err
+	}
+
+	changed := false
+	for i, a := range m.addresses {
+		changed = changed || after[i].Present != before[i].Present
+		if after[i].Present && !before[i].Present && canAnnounce && m.Carp == nil && !a.noAnnounce {
+			m.auditAnnouncement(a, m.Announce(ctx, a))
+		}
+	}
+	if changed {
+		m.transitionCompleted(state, start)
+	}
+	return m.changes(before, after, state), nil
+}
+
+// converge reconciles until the addresses, found in before, are in state.
+// It returns the last state found, which is before if the addresses cannot
+// be queried.
+func (m *IPManager) converge(ctx context.Context, before []AddressState, state bool) ([]AddressState, error) {
+	after := before
+	for {
+		rulesState := m.QueryFirewall(ctx)
+		macvlanState := m.Macvlan != nil && m.Macvlan.Exists()
+		if m.reconcile(ctx, after, rulesState, macvlanState, state) {
+			states, err := m.QueryAddresses()
+			if err != nil {
+				return before, err
+			}
+			after = states
+			continue
+		}
+		if DryRun || (m.allInSync(after, state) && (m.Firewall == nil || m.QueryFirewall(ctx) == firewallStateFor(state))) {
+			return after, nil
+		}
+		// Waiting for a backoff, the primary check or duplicate address
+		// detection
+		select {
+		case <-ctx.Done():
+			return after, ctx.Err()
+		case <-time.After(dadRecheckInterval):
+		}
+		states, err := m.QueryAddresses()
+		if err != nil {
+			return before, err
+		}
+		after = states
+	}
+}
+
+func (m *IPManager) changes(before, after []AddressState, state bool) []Change {
+	changes := make([]Change, len(m.addresses))
+	for i, a := range m.addresses {
+		var result string
+		switch {
+		case DryRun && !m.inSync(before[i], state):
+			result = "would remove"
+			if state {
+				result = "would add"
+			}
+		case after[i].Present && !before[i].Present:
+			result = "added"
+		case !after[i].Present && before[i].Present:
+			result = "removed"
+		case after[i].Present:
+			result = "already present"
+		default:
+			result = "already absent"
+		}
+		changes[i] = Change{Address: a.GetCIDR(), Interface: a.iface.Name, Result: result}
+	}
+	return changes
+}
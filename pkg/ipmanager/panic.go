@@ -0,0 +1,41 @@
+package ipmanager
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+)
+
+// PanicHandler is called with the value and stack of a panic in one of the
+// goroutines of the manager, e.g. to release the addresses and exit. It
+// must not return. Without it a panic crashes the process as usual.
+var PanicHandler func(value any, stack []byte)
+
+// handlePanic passes a panic of the calling goroutine to PanicHandler, it
+// has to be deferred directly.
+func handlePanic() {
+	if PanicHandler == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		PanicHandler(v, debug.Stack())
+	}
+}
+
+// ReleaseAfterPanic removes the addresses unless this node was last reported
+// as leader. It does not wait for the state lock, which the panicking
+// goroutine may have held, the state counts as unknown then.
+func (m *IPManager) ReleaseAfterPanic(ctx context.Context) {
+	leader := false
+	if m.stateLock.TryLock() {
+		leader = m.stateReceived && m.currentState
+		m.stateLock.Unlock()
+	}
+	if leader {
+		slog.Warn("Keeping the virtual IP, this node is the leader", "vip", m.cidrs())
+		return
+	}
+	for _, a := range m.addresses {
+		m.DeconfigureAddress(ctx, a)
+	}
+}
@@ -0,0 +1,90 @@
+package ipmanager
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// Used when NewPrimaryCheck gets no query
+	DefaultPrimaryCheckQuery = "SELECT NOT pg_is_in_recovery()"
+
+	primaryCheckTimeout    = 5 * time.Second
+	primaryCheckMinBackoff = 1 * time.Second
+	primaryCheckMaxBackoff = 30 * time.Second
+)
+
+// PrimaryCheck verifies through psql that the local PostgreSQL is really a
+// primary before the virtual IP is configured on this host.
+type PrimaryCheck struct {
+	dsn   string
+	query string
+
+	result  string
+	backoff *Backoff
+}
+
+// NewPrimaryCheck runs query through psql with dsn, the default query
+// checks that PostgreSQL is not in recovery.
+func NewPrimaryCheck(dsn, query string) *PrimaryCheck {
+	if query == "" {
+		query = DefaultPrimaryCheckQuery
+	}
+	return &PrimaryCheck{
+		dsn:     dsn,
+		query:   query,
+		result:  "not run",
+		backoff: NewBackoff(primaryCheckMinBackoff, primaryCheckMaxBackoff),
+	}
+}
+
+func (c *PrimaryCheck) run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), primaryCheckTimeout)
+	defer cancel()
+
+	// psql needs no network privileges, so no command prefix here
+	cmd := exec.CommandContext(ctx, "psql", "-X", "-q", "-A", "-t", "-d", c.dsn, "-c", c.query)
+	output, err := commandCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
+	}
+	if value := strings.TrimSpace(string(output)); value != "t" {
+		return fmt.Errorf("query returned %q", value)
+	}
+	return nil
+}
+
+// Ready runs the check unless a previous failure is still backing off. On
+// failure retry is called once the backoff has elapsed.
+func (c *PrimaryCheck) Ready(retry func()) bool {
+	if c.backoff.Waiting() {
+		return false
+	}
+
+	err := c.run()
+	if err == nil {
+		c.result = "passed"
+		c.backoff.Reset()
+		return true
+	}
+
+	delay := c.backoff.Failed(retry)
+	c.result = fmt.Sprintf("failed (%s), retrying in %s", err, delay)
+	slog.Warn("PostgreSQL primary check failed", "error", err, "retry_in", delay)
+
+	return false
+}
+
+// Reset forgets about earlier failures, the check is only relevant while
+// acquiring the virtual IP.
+func (c *PrimaryCheck) Reset() {
+	c.backoff.Reset()
+}
+
+func (c *PrimaryCheck) Status() string {
+	return c.result
+}
@@ -0,0 +1,70 @@
+package ipmanager
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// ProxyArp publishes the virtual IP with a proxy neighbor entry instead of
+// adding it to the interface, so the leader answers ARP for it and routes
+// the traffic on internally.
+type ProxyArp struct {
+	// proxy_arp is enabled while an entry is published
+	sysctls *ArpSysctls
+}
+
+// NewProxyArp publishes addresses on their interfaces.
+func NewProxyArp(addresses []*IPConfiguration) *ProxyArp {
+	return &ProxyArp{
+		sysctls: newArpSysctls(addresses, map[string]string{"proxy_arp": "1"}),
+	}
+}
+
+func (p *ProxyArp) Published(a *IPConfiguration) bool {
+	output, err := commandOutput(newCommand("ip", "-j", "neigh", "show", "proxy", "to", a.vip.String(), "dev", a.iface.Name))
+	if err != nil {
+		slog.Error("Cannot query proxy neighbor entries", "iface", a.iface.Name, "error", err)
+		return false
+	}
+	var entries []struct {
+		Dst string `json:"dst"`
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		slog.Error("Cannot parse proxy neighbor entries", "iface", a.iface.Name, "error", err)
+		return false
+	}
+	return len(entries) > 0
+}
+
+func (p *ProxyArp) Set(ctx context.Context, a *IPConfiguration, publish bool) bool {
+	if publish {
+		if err := p.sysctls.Apply(); err != nil {
+			slog.Error("Cannot enable proxy_arp", "iface", a.iface.Name, "error", err)
+			return false
+		}
+		return p.run(ctx, "replace", a)
+	}
+
+	if !p.run(ctx, "delete", a) {
+		return false
+	}
+	if err := p.sysctls.Restore(); err != nil {
+		slog.Error("Cannot restore proxy_arp", "iface", a.iface.Name, "error", err)
+	}
+	return true
+}
+
+func (p *ProxyArp) run(ctx context.Context, action string, a *IPConfiguration) bool {
+	args := []string{"neigh", action, "proxy", a.vip.String(), "dev", a.iface.Name}
+	if skipDryRun("ip", args...) {
+		return true
+	}
+	output, err := commandCombinedOutput(newCommandContext(ctx, "ip", args...))
+	if err != nil {
+		slog.Error("Error running ip "+strings.Join(args, " "), "error", err, "output", strings.TrimSpace(string(output)))
+		return false
+	}
+	return true
+}
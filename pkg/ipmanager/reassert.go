@@ -0,0 +1,36 @@
+package ipmanager
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+var reassertions = metrics.NewCounter("vip_manager_reassertions_total",
+	"Number of times the virtual IP was announced again while held, see -reassert-interval.")
+
+// reassertDue reports whether the addresses we hold should be announced
+// again. Only called by the apply loop.
+func (m *IPManager) reassertDue() bool {
+	return m.ReassertInterval > 0 && time.Since(m.lastReassert) >= m.ReassertInterval
+}
+
+// reassert announces the addresses again, although nothing changed, for
+// neighbours and middleboxes that forget where the address is after a long
+// idle time. The apply loop verifies the addresses on every iteration and
+// adds missing ones again, reassert wakes it up for that as well.
+func (m *IPManager) reassert() {
+	m.lastReassert = time.Now()
+	time.AfterFunc(m.ReassertInterval, m.recheck.Broadcast)
+	reassertions.Inc()
+	slog.Debug("Re-asserting the virtual IP", "vip", m.cidrs())
+	if !canAnnounce || m.Carp != nil {
+		return
+	}
+	for _, a := range m.addresses {
+		if !a.noAnnounce {
+			m.announcer.Request(a)
+		}
+	}
+}
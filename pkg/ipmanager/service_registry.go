@@ -0,0 +1,20 @@
+package ipmanager
+
+// ServiceRegistry publishes the virtual IPs in a service discovery while
+// this node holds them. Register is called after an address was added, and
+// again at every recheck while it stays in place, so it must be cheap for an
+// address that is already registered. Deregister is called before an
+// address is removed. Both are called from the apply loop.
+type ServiceRegistry interface {
+	Register(vip string)
+	Deregister(vip string)
+}
+
+func (m *IPManager) registerServices() {
+	if m.ServiceRegistry == nil {
+		return
+	}
+	for _, a := range m.addresses {
+		m.ServiceRegistry.Register(a.vip.String())
+	}
+}
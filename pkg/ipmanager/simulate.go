@@ -0,0 +1,112 @@
+package ipmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How long the release at the end of a simulation may take, also when the
+// simulation was interrupted
+const simulationReleaseTimeout = 30 * time.Second
+
+// SimulationStep is the outcome of one step of Simulate.
+type SimulationStep struct {
+	Name     string
+	Duration time.Duration
+	// Skipped with dry run, after an earlier step failed or when the
+	// simulation was interrupted
+	Skipped bool
+	Err     error
+}
+
+// Simulate rehearses a failover without the leader checker: it acquires
+// the virtual IPs, verifies that they are configured, announces them and
+// releases them again. The release always runs, also when an earlier step
+// failed or ctx is done, so the system is left as it was found. It refuses
+// to start if any of the addresses is already present.
+func (m *IPManager) Simulate(ctx context.Context) ([]SimulationStep, error) {
+	defer func() {
+		for _, arpClient := range m.arpClients {
+			arpClient.Close()
+		}
+	}()
+
+	before, err := m.QueryAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range before {
+		if s.Present {
+			return nil, fmt.Errorf("%s is already configured on %s", m.addresses[i].GetCIDR(), m.addresses[i].iface.Name)
+		}
+	}
+
+	var steps []SimulationStep
+	failed := false
+	// A step is skipped once an earlier one failed or ctx is done
+	run := func(name string, skip bool, step func() error) {
+		if failed || skip || ctx.Err() != nil {
+			steps = append(steps, SimulationStep{Name: name, Skipped: true})
+			return
+		}
+		start := time.Now()
+		err := step()
+		steps = append(steps, SimulationStep{Name: name, Duration: time.Since(start), Err: err})
+		failed = err != nil
+	}
+
+	m.stateLock.Lock()
+	m.currentState = true
+	m.stateLock.Unlock()
+
+	run("acquire", false, func() error {
+		_, err := m.converge(ctx, before, true)
+		return err
+	})
+	run("verify", DryRun, func() error {
+		states, err := m.QueryAddresses()
+		if err != nil {
+			return err
+		}
+		for i, s := range states {
+			if !s.Present || s.Tentative || s.DADFailed {
+				return fmt.Errorf("%s is not usable on %s", m.addresses[i].GetCIDR(), m.addresses[i].iface.Name)
+			}
+		}
+		if m.Firewall != nil && m.QueryFirewall(ctx) != FirewallHolding {
+			return errors.New("the firewall rules are missing")
+		}
+		return nil
+	})
+	run("announce", DryRun || !canAnnounce || m.Carp != nil, func() error {
+		var errs []error
+		for _, a := range m.addresses {
+			if !a.noAnnounce {
+				errs = append(errs, m.Announce(ctx, a))
+			}
+		}
+		return errors.Join(errs...)
+	})
+
+	m.stateLock.Lock()
+	m.currentState = false
+	m.stateLock.Unlock()
+
+	// Also when ctx is done, the addresses must not stay behind
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), simulationReleaseTimeout)
+	defer cancel()
+	start := time.Now()
+	states, err := m.QueryAddresses()
+	if err == nil {
+		_, err = m.converge(releaseCtx, states, false)
+	}
+	// Left behind if the acquire failed before any address was added
+	m.RestoreArpSysctls()
+	if !m.RemoveMacvlan() && err == nil {
+		err = errors.New("cannot remove the macvlan")
+	}
+	steps = append(steps, SimulationStep{Name: "release", Duration: time.Since(start), Err: err})
+	return steps, nil
+}
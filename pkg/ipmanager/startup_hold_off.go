@@ -0,0 +1,72 @@
+package ipmanager
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Until the leader checker reports the first state, the desired state is
+// false. When we restart on the leader, the address is still there and
+// would be removed for the moment until the first read of the DCS. So an
+// address present at startup is kept until the first state arrives, or
+// StartupHoldOff expires.
+
+type holdOffPhase int
+
+const (
+	holdOffUnchecked holdOffPhase = iota
+	holdOffActive
+	holdOffDone
+)
+
+// holdingOff reports whether the apply loop has to leave the addresses
+// alone for now. Only called by the apply loop.
+func (m *IPManager) holdingOff(actualStates []AddressState, stateReceived, desiredState bool) bool {
+	switch m.holdOff {
+	case holdOffUnchecked:
+		m.holdOff = holdOffDone
+		if m.StartupHoldOff <= 0 || stateReceived || !anyPresent(actualStates) {
+			return false
+		}
+		m.holdOff = holdOffActive
+		m.holdOffStarted = time.Now()
+		m.holdOffTimer = time.AfterFunc(m.StartupHoldOff, func() {
+			m.stateLock.Lock()
+			m.recheck.Broadcast()
+			m.stateLock.Unlock()
+		})
+		slog.Info("Virtual IP is present at startup, keeping it until the leader checker reports a state",
+			"vip", m.cidrs(), "hold_off", m.StartupHoldOff)
+		return true
+
+	case holdOffActive:
+		waited := time.Since(m.holdOffStarted).Round(time.Millisecond)
+		if stateReceived {
+			m.holdOff = holdOffDone
+			m.holdOffTimer.Stop()
+			if desiredState {
+				slog.Info("Startup hold-off ended, this node is leader, keeping the virtual IP", "vip", m.cidrs(), "waited", waited)
+			} else {
+				slog.Info("Startup hold-off ended, this node is not leader, removing the virtual IP", "vip", m.cidrs(), "waited", waited)
+			}
+			return false
+		}
+		if waited >= m.StartupHoldOff {
+			m.holdOff = holdOffDone
+			slog.Warn("Startup hold-off expired before the leader checker reported a state, removing the virtual IP",
+				"vip", m.cidrs(), "hold_off", m.StartupHoldOff)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func anyPresent(states []AddressState) bool {
+	for _, s := range states {
+		if s.Present {
+			return true
+		}
+	}
+	return false
+}
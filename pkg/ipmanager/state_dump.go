@@ -0,0 +1,125 @@
+package ipmanager
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// Transitions kept for DumpState
+const transitionHistorySize = 20
+
+// TransitionRecord is an entry of the transition history.
+type TransitionRecord struct {
+	Time time.Time
+	// desired when the desired state changed, completed when the addresses
+	// reached it, fenced when it was dropped by self-fencing, leader when
+	// the value of the leader key changed
+	Kind  string
+	State bool
+	// From the change of the desired state, only for completed
+	Duration time.Duration
+	// Only for leader: the values of the leader key and the revision of
+	// the new one
+	Leader         string
+	PreviousLeader string
+	Revision       uint64
+}
+
+// transitionHistory is a ring buffer of the latest transitions. Its lock
+// is only ever held to copy a record in or out.
+type transitionHistory struct {
+	lock    sync.Mutex
+	records [transitionHistorySize]TransitionRecord
+	next    int
+	full    bool
+}
+
+func (h *transitionHistory) add(r TransitionRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records[h.next] = r
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the records, oldest first.
+func (h *transitionHistory) list() []TransitionRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.full {
+		return append([]TransitionRecord(nil), h.records[:h.next]...)
+	}
+	return append(append([]TransitionRecord(nil), h.records[h.next:]...), h.records[:h.next]...)
+}
+
+// Transitions returns the latest transitions, oldest first.
+func (m *IPManager) Transitions() []TransitionRecord {
+	return m.history.list()
+}
+
+// loopSnapshot is what the apply loop saw at the start of its last
+// iteration, published for DumpState.
+type loopSnapshot struct {
+	at          time.Time
+	desired     bool
+	actual      []AddressState
+	maintenance bool
+	pending     string
+	failures    int
+	backoff     time.Duration
+	backoffWait bool
+}
+
+// DumpState logs everything there is to know about the state of the
+// manager, for when it looks stuck. It takes none of the locks of the apply
+// loop, the state is as of the last iteration of the apply loop.
+func (m *IPManager) DumpState() {
+	since := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Millisecond).String() + " ago"
+	}
+
+	key, value, readAt := checker.LastValue()
+	args := []any{
+		"vip", m.cidrs(),
+		"goroutines", runtime.NumGoroutine(),
+		"dcs_key", key,
+		"dcs_value", value,
+		"dcs_index", checker.LastIndex(),
+		"dcs_read", since(readAt),
+		"dcs_attempt", since(checker.LastAttempt()),
+		"leader_confirmed", since(checker.LastConfirmed()),
+	}
+	if s, ok := m.snapshot.Load().(*loopSnapshot); ok {
+		args = append(args,
+			"apply_loop", since(s.at),
+			"desired", s.desired,
+			"actual", fmt.Sprintf("%+v", s.actual),
+			"maintenance", s.maintenance,
+			"pending", s.pending,
+			"failures", s.failures,
+			"backoff", s.backoff,
+			"backoff_waiting", s.backoffWait)
+	} else {
+		args = append(args, "apply_loop", "not started")
+	}
+	slog.Info("State dump", args...)
+
+	for _, r := range m.history.list() {
+		if r.Kind == "leader" {
+			slog.Info("State dump transition", "time", r.Time.Format(time.RFC3339Nano), "kind", r.Kind, "state", r.State,
+				"leader", r.Leader, "previous", r.PreviousLeader, "revision", r.Revision)
+			continue
+		}
+		slog.Info("State dump transition", "time", r.Time.Format(time.RFC3339Nano), "kind", r.Kind, "state", r.State, "duration", r.Duration)
+	}
+}
@@ -0,0 +1,129 @@
+package ipmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// PersistedState is the last desired state reported by the leader checker,
+// as kept in the state file across restarts.
+type PersistedState struct {
+	// The virtual IPs it applies to, a changed config makes it unknown
+	VIP    string    `json:"vip"`
+	Leader bool      `json:"leader"`
+	Time   time.Time `json:"time"`
+	// The leader key and its value that caused the state
+	TriggerKey   string `json:"trigger_key,omitempty"`
+	TriggerValue string `json:"trigger_value,omitempty"`
+}
+
+// StateFile keeps the last desired state, so that a restart can start from
+// it instead of from "not leader" until the leader checker reports.
+type StateFile struct {
+	path string
+	// Older states are unknown
+	maxAge time.Duration
+}
+
+// NewStateFile keeps the state in the file at path, and only uses states
+// that are at most maxAge old.
+func NewStateFile(path string, maxAge time.Duration) *StateFile {
+	return &StateFile{path: path, maxAge: maxAge}
+}
+
+// Load returns the state in the file if it is for vip and fresh enough. A
+// missing or corrupted file is an unknown state, as is one from the future.
+func (f *StateFile) Load(vip string) (PersistedState, bool) {
+	var s PersistedState
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		slog.Info("No persisted state, state is unknown", "state_file", f.path)
+		return s, false
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &s)
+	}
+	if err == nil && s.Time.IsZero() {
+		err = fmt.Errorf("no time")
+	}
+	if err != nil {
+		slog.Warn("Cannot read the persisted state, state is unknown", "state_file", f.path, "error", err)
+		return s, false
+	}
+
+	age := time.Since(s.Time)
+	switch {
+	case s.VIP != vip:
+		slog.Info("Persisted state is for other virtual IPs, state is unknown", "state_file", f.path, "vip", vip, "persisted_vip", s.VIP)
+	case age < 0:
+		slog.Warn("Persisted state is from the future, state is unknown", "state_file", f.path, "time", s.Time)
+	case age > f.maxAge:
+		slog.Info("Persisted state is too old, state is unknown", "state_file", f.path, "age", age.Round(time.Second), "max_age", f.maxAge)
+	default:
+		return s, true
+	}
+	return s, false
+}
+
+// Save replaces the file atomically, so a crash leaves either the old or
+// the new state.
+func (f *StateFile) Save(s PersistedState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// persistState saves a desired state reported by the leader checker.
+func (m *IPManager) persistState(state bool) {
+	key, value, _ := checker.LastValue()
+	err := m.StateFile.Save(PersistedState{
+		VIP:          m.cidrs(),
+		Leader:       state,
+		Time:         time.Now(),
+		TriggerKey:   key,
+		TriggerValue: value,
+	})
+	if err != nil {
+		slog.Error("Cannot persist the state", "state_file", m.StateFile.path, "error", err)
+	}
+}
+
+// loadProvisionalState starts from the persisted state, if it is known,
+// until the leader checker reports the first state. Has to be called
+// before the apply loop starts.
+func (m *IPManager) loadProvisionalState() {
+	s, ok := m.StateFile.Load(m.cidrs())
+	if !ok {
+		return
+	}
+	slog.Warn("Using the persisted state until the leader checker reports a state", "vip", m.cidrs(), "state", s.Leader,
+		"age", time.Since(s.Time).Round(time.Second), "trigger_key", s.TriggerKey, "trigger_value", s.TriggerValue)
+	m.currentState = s.Leader
+	m.provisionalAt = time.Now()
+	// The persisted state decides what happens to a present address
+	m.holdOff = holdOffDone
+}
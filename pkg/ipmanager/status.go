@@ -0,0 +1,140 @@
+package ipmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// ProgressTimeout is how long the leader checker and the apply loop may go
+// without progress before Health reports a problem. The apply loop runs at
+// least every 10 seconds, so it is considered hung then.
+const ProgressTimeout = 30 * time.Second
+
+// Status is served as JSON on /status. Fields may be added, but existing
+// ones must keep their name and meaning, as scripts and load balancers rely
+// on them.
+type Status struct {
+	Healthy     bool `json:"healthy"`
+	Maintenance bool `json:"maintenance"`
+	// Whether this node should hold the virtual IPs
+	DesiredState bool `json:"desired_state"`
+	// Whether all virtual IPs are configured
+	ActualState bool            `json:"actual_state"`
+	Addresses   []AddressStatus `json:"addresses"`
+	// The key of the leader checker and the value last read from it
+	TriggerKey   string `json:"trigger_key"`
+	TriggerValue string `json:"trigger_value"`
+	// The modify index of the leader key, and whether the value is older
+	// than the DCS threshold of the health check
+	TriggerRevision uint64 `json:"trigger_revision"`
+	TriggerStale    bool   `json:"trigger_stale"`
+	// Null until the first successful read or completed change
+	LastDCSRead    *time.Time `json:"last_dcs_read"`
+	LastTransition *time.Time `json:"last_transition"`
+	// Only for a Chain
+	Managers []ManagerHealth `json:"managers,omitempty"`
+	// The vote of every DCS endpoint, only with a quorum
+	Quorum []checker.QuorumVote `json:"quorum,omitempty"`
+}
+
+// AddressStatus is the state of one virtual IP in Status.
+type AddressStatus struct {
+	Address   string `json:"address"`
+	Interface string `json:"interface"`
+	Present   bool   `json:"present"`
+}
+
+// allPresent reports whether all addresses are configured.
+func allPresent(states []AddressState) bool {
+	for _, s := range states {
+		if !s.Present {
+			return false
+		}
+	}
+	return len(states) > 0
+}
+
+// boolValue is a bool as metric value.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Health reports a problem if the leader checker or the apply loop are
+// stuck, or the DCS was not read successfully for longer than dcsThreshold.
+func (m *IPManager) Health(dcsThreshold time.Duration) error {
+	if err := m.Liveness(); err != nil {
+		return err
+	}
+	return DCSReachable(dcsThreshold)
+}
+
+// Liveness reports a problem if the leader checker or the apply loop are
+// stuck. The systemd watchdog is fed on the same condition.
+func (m *IPManager) Liveness() error {
+	if idle := time.Since(checker.LastAttempt()); idle > ProgressTimeout {
+		return fmt.Errorf("leader checker made no progress for %s", idle.Round(time.Second))
+	}
+	if idle := time.Since(m.LastApply()); idle > ProgressTimeout {
+		return fmt.Errorf("apply loop made no progress for %s", idle.Round(time.Second))
+	}
+	return nil
+}
+
+// DCSReachable reports a problem if the DCS was not read successfully for
+// longer than threshold.
+func DCSReachable(threshold time.Duration) error {
+	if _, _, at := checker.LastValue(); time.Since(at) > threshold {
+		if at.IsZero() {
+			return fmt.Errorf("DCS was not read successfully yet")
+		}
+		return fmt.Errorf("DCS was last read successfully %s ago", time.Since(at).Round(time.Second))
+	}
+	return nil
+}
+
+// Status describes the desired and actual state of the virtual IPs.
+func (m *IPManager) Status(dcsThreshold time.Duration) Status {
+	m.stateLock.Lock()
+	desiredState := m.currentState
+	observed := m.observedStates
+	lastTransition := m.lastTransition
+	maintenance := m.maintenance
+	m.stateLock.Unlock()
+
+	key, value, readAt := checker.LastValue()
+	s := Status{
+		Healthy:         m.Health(dcsThreshold) == nil,
+		Maintenance:     maintenance,
+		DesiredState:    desiredState,
+		ActualState:     observed != nil,
+		Addresses:       make([]AddressStatus, len(m.addresses)),
+		TriggerKey:      key,
+		TriggerValue:    value,
+		TriggerRevision: checker.LastRevision(),
+		TriggerStale:    DCSReachable(dcsThreshold) != nil,
+		LastDCSRead:     optionalTime(readAt),
+		LastTransition:  optionalTime(lastTransition),
+		Quorum:          checker.QuorumVotes(),
+	}
+	for i, a := range m.addresses {
+		s.Addresses[i] = AddressStatus{Address: a.GetCIDR(), Interface: a.iface.Name}
+		if observed != nil && observed[i].Present {
+			s.Addresses[i].Present = true
+		} else {
+			s.ActualState = false
+		}
+	}
+	return s
+}
@@ -0,0 +1,109 @@
+package ipmanager
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+)
+
+// step is a timed step of a transition, e.g. adding an address. Its
+// duration is measured once and goes to the span, to the histogram of the
+// acquire steps and to the log, so they never disagree.
+type step struct {
+	m    *IPManager
+	name string
+	// Label in acquireStepDuration, empty for steps not measured there
+	metric     string
+	start      time.Time
+	span       *tracing.Span
+	attributes []any
+	once       sync.Once
+}
+
+// startStep starts a step of the current transition. metric is its label
+// in acquireStepDuration, or empty.
+func (m *IPManager) startStep(name, metric string, attributes ...any) *step {
+	return m.startStepAt(name, metric, time.Now(), attributes...)
+}
+
+// startStepAt is startStep for a step that began at start.
+func (m *IPManager) startStepAt(name, metric string, start time.Time, attributes ...any) *step {
+	return &step{m: m, name: name, metric: metric, start: start,
+		span: m.stepSpanAt(name, start, attributes...), attributes: attributes}
+}
+
+// SetAttributes adds attributes to the span and the log line. Not safe to
+// call concurrently with End.
+func (s *step) SetAttributes(attributes ...any) {
+	s.span.SetAttributes(attributes...)
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// End finishes the step, err marks it as failed. Only the first call
+// counts.
+func (s *step) End(err error) {
+	s.once.Do(func() {
+		duration := time.Since(s.start)
+		if s.metric != "" {
+			acquireStepDuration.With(s.metric).Observe(duration.Seconds())
+		}
+		s.span.End(err)
+		s.m.steps.add(s.name, duration)
+		args := append([]any{"step", s.name, "duration", duration.Round(time.Millisecond)}, s.attributes...)
+		if !hasAttribute(s.attributes, "vip") {
+			args = append([]any{"vip", s.m.cidrs()}, args...)
+		}
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		slog.Debug("Transition step finished", args...)
+	})
+}
+
+func hasAttribute(attributes []any, key string) bool {
+	for i := 0; i < len(attributes); i += 2 {
+		if attributes[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionSteps collects the steps of the transition in progress for the
+// summary once it completes.
+type transitionSteps struct {
+	lock   sync.Mutex
+	active bool
+	steps  []string
+}
+
+// start begins collecting the steps of a new transition.
+func (t *transitionSteps) start() {
+	t.lock.Lock()
+	t.active = true
+	t.steps = nil
+	t.lock.Unlock()
+}
+
+func (t *transitionSteps) add(name string, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// E.g. announcements while holding the address
+	if !t.active {
+		return
+	}
+	t.steps = append(t.steps, fmt.Sprintf("%s=%s", name, duration.Round(time.Millisecond)))
+}
+
+// finish returns the steps of the transition for the summary, in the order
+// they finished.
+func (t *transitionSteps) finish() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active = false
+	return strings.Join(t.steps, ", ")
+}
@@ -0,0 +1,61 @@
+package ipmanager
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+)
+
+// Marks a span as failed, the details are in the log
+var errStepFailed = errors.New("failed")
+
+func stepError(ok bool) error {
+	if ok {
+		return nil
+	}
+	return errStepFailed
+}
+
+// startTransitionTrace starts the span of a change of the desired state to
+// state, a child of the read of the leader key that caused it. A transition
+// that is still open was overtaken by this one. Has to be called with
+// stateLock held.
+func (m *IPManager) startTransitionTrace(state bool) {
+	if !tracing.Enabled() {
+		return
+	}
+	m.endTransitionTrace("superseded")
+	name := "release"
+	if state {
+		name = "acquire"
+	}
+	m.transitionSpan = tracing.Start(name, checker.LastObservation(), "vip", m.cidrs())
+	m.transitionTrace.Store(m.transitionSpan.Context())
+}
+
+// endTransitionTrace ends the span of the current transition with outcome,
+// e.g. completed. Has to be called with stateLock held.
+func (m *IPManager) endTransitionTrace(outcome string) {
+	if m.transitionSpan == nil {
+		return
+	}
+	m.transitionSpan.SetAttributes("outcome", outcome)
+	m.transitionSpan.End(nil)
+	m.transitionSpan = nil
+	m.transitionTrace.Store(tracing.SpanContext{})
+}
+
+// stepSpanAt starts the span of a step of the current transition that
+// began at start, nil if there is none or tracing is disabled. See step.
+func (m *IPManager) stepSpanAt(name string, start time.Time, attributes ...any) *tracing.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	parent, _ := m.transitionTrace.Load().(tracing.SpanContext)
+	if !parent.IsValid() {
+		return nil
+	}
+	return tracing.StartAt(name, parent, start, attributes...)
+}
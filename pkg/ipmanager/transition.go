@@ -0,0 +1,155 @@
+package ipmanager
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+)
+
+// pendingTransition is a change of the desired state that only takes effect
+// after a delay, e.g. the release grace period.
+type pendingTransition struct {
+	state    bool
+	received time.Time
+	deadline time.Time
+	timer    *time.Timer
+	// The wait as a step of the transition
+	step *step
+	// The release grace period included in the wait, the notifiers were
+	// told about the drain if it is not 0
+	grace time.Duration
+}
+
+// transitionDelay is how long a change to state is held back. The delay
+// before release and the grace period add up, grace is the latter.
+func (m *IPManager) transitionDelay(state bool) (delay, grace time.Duration) {
+	if state {
+		return m.DelayBeforeAcquire, 0
+	}
+	grace = m.ReleaseGracePeriod
+	if grace > 0 && m.ReleaseGracePlannedOnly && unplannedRelease() {
+		slog.Info("Leadership was lost without a switchover, releasing without the grace period", "vip", m.cidrs())
+		grace = 0
+	}
+	return m.DelayBeforeRelease + grace, grace
+}
+
+// unplannedRelease reports whether the leadership was lost without being
+// handed over: the leader key names no node, or the DCS did not confirm
+// the leadership within the staleness threshold before it named another
+// node, e.g. after an outage of the DCS.
+func unplannedRelease() bool {
+	_, value, at := checker.LastValue()
+	return value == "" || at.Sub(checker.LastConfirmed()) > checker.StaleThreshold()
+}
+
+// setState takes a state from the leader checker. Has to be called with
+// stateLock held.
+func (m *IPManager) setState(newState bool) {
+	first := !m.stateReceived
+	if tracelog.Enabled() {
+		tracelog.Log("Desired state reported", "vip", m.cidrs(), "state", newState, "current", m.currentState,
+			"pending", m.pendingStatus(), "first", first)
+	}
+	m.stateReceived = true
+	if first {
+		close(m.ready)
+	}
+
+	if m.fenced {
+		// A state from before the fencing may still be on the way
+		if newState && m.confirmationStale() {
+			return
+		}
+		m.fenced = false
+		if newState {
+			slog.Warn("Leadership confirmed again after self-fencing", "vip", m.cidrs())
+		}
+	}
+
+	if m.pending != nil {
+		if m.pending.state == newState {
+			// Already on the way
+			return
+		}
+		m.pending.timer.Stop()
+		m.pending.step.SetAttributes("cancelled", true)
+		m.pending.step.End(nil)
+		if m.pending.grace > 0 {
+			m.notifyDrain(EventReleaseCancelled)
+		}
+		m.pending = nil
+		m.endTransitionTrace("cancelled")
+		slog.Info("Desired state is back, cancelled pending change", "vip", m.cidrs(), "state", newState)
+	}
+	if m.currentState == newState {
+		return
+	}
+	m.startTransitionTrace(newState)
+	m.steps.start()
+	if _, _, readAt := checker.LastValue(); !readAt.IsZero() {
+		// From the read of the leader key until the state got here
+		m.startStepAt("notification", "", readAt).End(nil)
+	}
+
+	received := time.Now()
+	delay, grace := m.transitionDelay(newState)
+	if first && newState && m.StartupJitter > 0 {
+		// Spreads out instances that start at the same time, releasing
+		// is never delayed by it
+		jitter := time.Duration(rand.Int63n(int64(m.StartupJitter)))
+		slog.Info("Startup jitter before the first acquisition", "jitter", jitter.Round(time.Millisecond))
+		delay += jitter
+	}
+	if delay <= 0 {
+		m.changeState(newState, received)
+		return
+	}
+
+	slog.Info("Desired state changed, waiting before applying it", "vip", m.cidrs(), "state", newState, "delay", delay)
+	p := &pendingTransition{state: newState, received: received, deadline: received.Add(delay),
+		step: m.startStep("delay", "", "delay", delay), grace: grace}
+	p.timer = time.AfterFunc(delay, func() {
+		m.stateLock.Lock()
+		defer m.stateLock.Unlock()
+		// The timer may have fired while it was being cancelled
+		if m.pending != p {
+			return
+		}
+		p.step.End(nil)
+		m.pending = nil
+		m.changeState(p.state, p.received)
+	})
+	m.pending = p
+	if grace > 0 {
+		// The address stays for the grace period, connections can be
+		// drained now
+		m.notifyDrain(EventReleasePending)
+	}
+}
+
+// changeState sets the desired state, received is when the change was
+// reported by the leader checker. Has to be called with stateLock held.
+func (m *IPManager) changeState(state bool, received time.Time) {
+	m.history.add(TransitionRecord{Time: time.Now(), Kind: "desired", State: state})
+	m.currentState = state
+	m.stateChangedAt = received
+	m.recheck.Broadcast()
+}
+
+// pendingStatus describes a pending change for the status line, or returns
+// an empty string. Has to be called with stateLock held.
+func (m *IPManager) pendingStatus() string {
+	if m.pending == nil {
+		return ""
+	}
+	remaining := time.Until(m.pending.deadline).Round(time.Second)
+	if m.pending.state {
+		return fmt.Sprintf("acquiring in %s", remaining)
+	}
+	return fmt.Sprintf("release pending in %s", remaining)
+}
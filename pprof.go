@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+var pprofListen = stringOption("pprof-listen", "", "Loopback address to serve the Go profiler on under /debug/pprof/, e.g. localhost:6060, for debugging a running process. Empty disables it.")
+
+// isLoopbackListen reports whether addr only listens on a loopback
+// interface. An empty host would listen on all of them.
+func isLoopbackListen(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startPprofServer serves the profiles on a listener of its own, so they
+// are never exposed where the metrics are.
+func startPprofServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("Profiler failed", "listen", addr, "error", err)
+		}
+	}()
+	slog.Warn("Serving the Go profiler", "listen", addr)
+	return srv
+}
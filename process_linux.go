@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sameProgram reports whether the process with pid runs the same program as
+// we do. A pid from an unclean shutdown may have been reused by anything.
+func sameProgram(pid int) bool {
+	own, err := os.ReadFile("/proc/self/comm")
+	if err != nil {
+		return true
+	}
+	comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		// E.g. hidden by hidepid, better safe than sorry
+		return true
+	}
+	return bytes.Equal(comm, own)
+}
+
+// otherProcess is another process running the same program as we do.
+type otherProcess struct {
+	pid  int
+	args []string
+}
+
+// otherProcesses lists the processes that run the same program as we do,
+// except for ourselves and our parent, e.g. with -config-dir.
+func otherProcesses() []otherProcess {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var processes []otherProcess
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == os.Getpid() || pid == os.Getppid() || !sameProgram(pid) {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		processes = append(processes, otherProcess{pid: pid, args: strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")})
+	}
+	return processes
+}
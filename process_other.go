@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// sameProgram reports true, only Linux can tell which program a process
+// runs.
+func sameProgram(pid int) bool {
+	return true
+}
+
+// otherProcess is another process running the same program as we do.
+type otherProcess struct {
+	pid  int
+	args []string
+}
+
+// otherProcesses returns nil, the processes are only listed on Linux.
+func otherProcesses() []otherProcess {
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+)
+
+// parsedValue returns how values would be shown once set on a fresh
+// instance of the flag of o, so that e.g. "60s" and "1m0s" compare equal.
+// No values stands for the default.
+func parsedValue(o *optionDef, values []string) (string, error) {
+	v := reflect.New(reflect.TypeOf(o.flag.Value).Elem()).Interface().(flag.Value)
+	if values == nil && !o.repeatable {
+		values = []string{o.flag.DefValue}
+	}
+	for _, value := range values {
+		if err := v.Set(value); err != nil {
+			return "", fmt.Errorf("invalid value %q for option %s: %s", value, o.name, err)
+		}
+	}
+	return v.String(), nil
+}
+
+// reloadConfig reads the config file again and applies the changed options
+// that are reloadable, see optionDef. A new leader checker is returned if it
+// has to be replaced, nil otherwise. Options given as flag or in the
+// environment keep their values, the virtual IP is left alone.
+func reloadConfig() checker.LeaderChecker {
+	if configPath == "" {
+		slog.Info("Reload unchanged: no config file given")
+		return nil
+	}
+	fileValues, err := readConfigFile(configPath)
+	if err != nil {
+		slog.Error("Reload rejected", "config", configPath, "error", err)
+		return nil
+	}
+	logDeprecations()
+
+	previous := make(map[string]string)
+	updated := make(map[string]string)
+	var applied, rejected []string
+	for _, o := range allOptions() {
+		if givenOptions[o.name] || o.name == "config" {
+			continue
+		}
+		if _, _, ok := o.lookupEnv(); ok {
+			continue
+		}
+
+		values := fileValues[o.name]
+		var secret []string
+		var ok bool
+		if secret, ok, err = reloadedSecret(o, fileValues); err != nil {
+			break
+		} else if ok {
+			values = secret
+		}
+		var value string
+		value, err = parsedValue(o, values)
+		if err != nil {
+			break
+		}
+		if value == o.flag.Value.String() {
+			continue
+		}
+		if o.reloadable {
+			previous[o.name] = o.flag.Value.String()
+			updated[o.name] = value
+			applied = append(applied, o.name)
+		} else {
+			rejected = append(rejected, o.name)
+		}
+	}
+	if err != nil {
+		slog.Error("Reload rejected", "config", configPath, "error", err)
+		return nil
+	}
+	if len(rejected) > 0 {
+		slog.Warn("Reload rejected changes that require a restart", "options", strings.Join(rejected, ", "))
+	}
+	if len(applied) == 0 {
+		if len(rejected) == 0 {
+			slog.Info("Reload unchanged: no changes", "config", configPath)
+		}
+		return nil
+	}
+
+	previousSources := make(map[string]string)
+	for name, value := range updated {
+		flag.Set(name, value)
+		previousSources[name] = optionSources[name]
+		optionSources[name] = "file"
+		if fileValues[name] == nil {
+			optionSources[name] = "default"
+		}
+	}
+	restore := func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+			optionSources[name] = previousSources[name]
+		}
+	}
+	if err := validateConfig(); err != nil {
+		restore()
+		slog.Error("Reload rejected", "config", configPath, "error", err)
+		return nil
+	}
+
+	var lc checker.LeaderChecker
+	for _, name := range applied {
+		if optionDefs[name].checker {
+			lc, err = newLeaderChecker()
+			if err != nil {
+				restore()
+				slog.Error("Reload rejected: cannot create the leader checker", "error", err)
+				return nil
+			}
+			break
+		}
+	}
+	setLogLevel()
+	slog.Info("Reload applied changes", "options", strings.Join(applied, ", "))
+	logEffectiveConfig()
+	return lc
+}
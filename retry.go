@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// retry calls fn until it succeeds, at most 1+retries times, waiting 1s,
+// 2s, 4s and so on in between. It returns the number of attempts and the
+// last error.
+func retry(ctx context.Context, retries int, fn func() error) (int, error) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// sdNotify sends state to the service manager. Without NOTIFY_SOCKET we are
+// not running as a Type=notify service and nothing is sent.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Cannot notify the service manager", "state", state, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Cannot notify the service manager", "state", state, "error", err)
+	}
+}
+
+// watchdogTimeout returns WatchdogSec of the service, or 0 if the watchdog
+// is not enabled for this process.
+func watchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifyReady tells the service manager we are ready once the leader
+// checker reported the first state, and keeps the watchdog happy as long as
+// the leader checker and the apply loop make progress.
+func notifyReady(ctx context.Context, m *ipmanager.IPManager) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-m.Ready():
+	}
+	sdNotify("READY=1")
+
+	timeout := watchdogTimeout()
+	if timeout == 0 {
+		return
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Liveness(); err != nil {
+			slog.Error("Not feeding the watchdog, a component is stuck", "error", err)
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/tracelog"
+)
+
+// Every secret option has a variant that reads its value from a file, e.g.
+// -smtp-password-file, so that the secret does not have to be in the config
+// file. Values in the config file may also refer to environment variables
+// as ${NAME}.
+const secretFileSuffix = "-file"
+
+// defineSecretFile defines the -file variant of the secret option name. The
+// path is no secret.
+func defineSecretFile(name string) *optionDef {
+	fileOption := name + secretFileSuffix
+	flag.String(fileOption, "", fmt.Sprintf("Read -%s from this file, without a trailing newline", name))
+	return defineOption(fileOption, nil)
+}
+
+// readSecretFile reads the value of the secret option from the file at
+// path.
+func readSecretFile(option, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret %s: %w", option, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// loadSecretFiles sets the secret options whose -file variant is set. Has
+// to be called by loadConfig once all options are set.
+func loadSecretFiles() error {
+	for _, o := range allOptions() {
+		if o.secretFile == nil {
+			continue
+		}
+		path := o.secretFile.flag.Value.String()
+		if path == "" {
+			continue
+		}
+		if optionSources[o.name] != "" {
+			return fmt.Errorf("options %s and %s given both, expected one of them", o.name, o.secretFile.name)
+		}
+		value, err := readSecretFile(o.name, path)
+		if err != nil {
+			return err
+		}
+		// Not flag.Set, the secret must not count as given on the
+		// command line, e.g. for the instances of -config-dir
+		if err := o.flag.Value.Set(value); err != nil {
+			return fmt.Errorf("%s: invalid value for option %s: %s", path, o.name, err)
+		}
+		optionSources[o.name] = "secret-file"
+	}
+	redactSecrets()
+	return nil
+}
+
+// reloadedSecret returns the value of the secret option o from its file, if
+// its -file variant is set in fileValues or was set at startup.
+func reloadedSecret(o *optionDef, fileValues map[string][]string) ([]string, bool, error) {
+	fileOption := o.secretFile
+	if fileOption == nil {
+		return nil, false, nil
+	}
+	path := fileOption.flag.Value.String()
+	if _, _, inEnv := fileOption.lookupEnv(); !givenOptions[fileOption.name] && !inEnv {
+		path = ""
+		if values := fileValues[fileOption.name]; len(values) > 0 {
+			path = values[len(values)-1]
+		}
+	}
+	if path == "" {
+		return nil, false, nil
+	}
+	value, err := readSecretFile(o.name, path)
+	if err != nil {
+		return nil, true, err
+	}
+	return []string{value}, true, nil
+}
+
+// redactSecrets hides the values of all secret options in the trace log
+// and the effective configuration.
+func redactSecrets() {
+	var values []string
+	for _, o := range allOptions() {
+		if o.secret {
+			values = append(values, o.flag.Value.String())
+		}
+	}
+	tracelog.SetSecrets(values)
+}
+
+// ${NAME} refers to an environment variable, $${NAME} is a literal ${NAME}
+var envReference = regexp.MustCompile(`\$?\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// interpolate replaces references to environment variables in value. An
+// unset variable is an error.
+func interpolate(value string) (string, error) {
+	var err error
+	result := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return result, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// How long simulate may take to acquire, verify and announce the virtual IP
+const simulateTimeout = time.Minute
+
+// simulateCommand implements "vip-manager simulate [config file]". It
+// rehearses a failover on this host without the DCS: the virtual IP is
+// acquired, verified, announced and released again, and each step is timed.
+// It returns the exit code.
+func simulateCommand(args []string) int {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s simulate [options] [config file]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Acquires, verifies, announces and releases the virtual IP without reading the DCS, and prints how long each step took. Exits with 1 if a step failed. Refuses to run while an instance for the same configuration is running. With -dry-run, only logs what would be done.")
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitConfigError
+	}
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		flag.Set("config", flag.Arg(0))
+	default:
+		flag.Usage()
+		return exitConfigError
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	if err := validateConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	setupLogging()
+
+	// Our own pid file keeps the daemon from starting while we run
+	if *pidFile != "" {
+		*pidFile = pidFilePath(*pidFile)
+		if err := writePidFile(*pidFile); err != nil {
+			fmt.Fprintf(os.Stderr, "refusing to simulate: %s\n", err)
+			return exitFailure
+		}
+		defer removePidFile(*pidFile)
+	}
+	if *controlSocket != "" {
+		path := controlSocketPath(*controlSocket)
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "refusing to simulate: another vip-manager is listening on %s\n", path)
+			return exitFailure
+		}
+	}
+
+	ipmanager.CommandPrefix = strings.Fields(*prefix)
+	ipmanager.DryRun = *dryRun
+	checkCapabilities()
+	manager := newManager(nil)
+	// Nobody outside should hear about a rehearsal
+	manager.Notifiers = nil
+	manager.ServiceRegistry = nil
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, simulateTimeout)
+	defer cancel()
+
+	steps, err := manager.Simulate(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "refusing to simulate: %s\n", err)
+		return exitFailure
+	}
+	code := 0
+	for _, s := range steps {
+		switch {
+		case s.Err != nil:
+			fmt.Printf("%-9s FAIL  %8s  %s\n", s.Name, s.Duration.Round(time.Millisecond), s.Err)
+			code = exitFailure
+		case s.Skipped:
+			fmt.Printf("%-9s SKIP\n", s.Name)
+		default:
+			fmt.Printf("%-9s PASS  %8s\n", s.Name, s.Duration.Round(time.Millisecond))
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Printf("interrupted: %s\n", ctx.Err())
+		code = exitFailure
+	}
+	if code == 0 {
+		fmt.Println("simulation passed")
+	} else {
+		fmt.Println("simulation failed")
+	}
+	return code
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var smtpHost = stringOption("smtp-host", "", "Mail server to send a message to -smtp-to through whenever the virtual IP moves. Empty disables mail.")
+var smtpPort = intOption("smtp-port", 587, "Port of the mail server")
+var smtpTLS = stringOption("smtp-tls", "starttls", "How to encrypt the connection to the mail server: starttls, tls for implicit TLS, e.g. on port 465, or none")
+var smtpInsecureSkipVerify = boolOption("smtp-insecure-skip-verify", false, "Do not verify the certificate of the mail server")
+var smtpUser = stringOption("smtp-user", "", "User to authenticate as at the mail server. Empty sends without authentication.")
+var smtpPassword = stringOption("smtp-password", "", "Password of -smtp-user", secret())
+var smtpFrom = stringOption("smtp-from", "", "Sender address of the messages, e.g. vip-manager@example.com")
+var smtpTo = stringOption("smtp-to", "", "Recipients of the messages, separated by commas")
+var smtpSubject = stringOption("smtp-subject", "vip-manager on {{.Node}}: {{.Event}} {{.VIP}}", "Subject of the messages, a Go template with the fields Event, Node, Instance and VIP")
+var smtpRetries = intOption("smtp-retries", 3, "How often to retry sending a message, waiting 1s, 2s, 4s and so on in between")
+
+// How long talking to the mail server may take
+const smtpTimeout = 30 * time.Second
+
+var mailFailures = metrics.NewCounter("vip_manager_mail_failures_total",
+	"Number of mail messages that could not be sent, after all retries or because the queue was full.")
+
+// The mail notifier of -smtp-host, if any
+var mailer *Mailer
+
+// Mailer sends a short message for every transition from a goroutine of its
+// own, so a slow mail server never delays the address operations.
+type Mailer struct {
+	address string
+	host    string
+	to      []string
+	subject *template.Template
+	queue   chan ipmanager.Event
+}
+
+func NewMailer() (*Mailer, error) {
+	subject, err := template.New("subject").Parse(*smtpSubject)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mailer{
+		address: net.JoinHostPort(*smtpHost, strconv.Itoa(*smtpPort)),
+		host:    *smtpHost,
+		subject: subject,
+		queue:   make(chan ipmanager.Event, webhookQueueSize),
+	}
+	for _, to := range strings.Split(*smtpTo, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			m.to = append(m.to, to)
+		}
+	}
+	return m, nil
+}
+
+// Notify queues e, dropping it if the queue is full. Only transitions are
+// mailed.
+func (m *Mailer) Notify(e ipmanager.Event) {
+	if e.Type != ipmanager.EventAcquire && e.Type != ipmanager.EventRelease {
+		return
+	}
+	select {
+	case m.queue <- e:
+	default:
+		mailFailures.Inc()
+		slog.Warn("Mail queue is full, dropping event", "event", e.Type, "vip", e.VIP)
+	}
+}
+
+// Run sends the queued events until ctx is done.
+func (m *Mailer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-m.queue:
+			attempts, err := retry(ctx, *smtpRetries, func() error {
+				return m.Send(e)
+			})
+			if err != nil {
+				mailFailures.Inc()
+				slog.Error("Cannot send mail", "smtp_host", m.address, "event", e.Type, "attempts", attempts, "error", err)
+			}
+		}
+	}
+}
+
+// message renders e as a mail with headers.
+func (m *Mailer) message(e ipmanager.Event) ([]byte, error) {
+	node, _ := nodeName()
+	var subject bytes.Buffer
+	err := m.subject.Execute(&subject, struct{ Event, Node, Instance, VIP string }{e.Type, node, instance(), e.VIP})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", *smtpFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.ReplaceAll(subject.String(), "\n", " "))
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Event:     %s\r\n", e.Type)
+	fmt.Fprintf(&b, "Node:      %s\r\n", node)
+	fmt.Fprintf(&b, "Instance:  %s\r\n", instance())
+	fmt.Fprintf(&b, "VIP:       %s\r\n", e.VIP)
+	fmt.Fprintf(&b, "Time:      %s\r\n", e.Time.Format(time.RFC3339))
+	switch e.Type {
+	case ipmanager.EventAcquire, ipmanager.EventRelease:
+		fmt.Fprintf(&b, "State:     %t -> %t\r\n", e.OldState, e.NewState)
+		fmt.Fprintf(&b, "Trigger:   %s = %q\r\n", e.TriggerKey, e.TriggerValue)
+		fmt.Fprintf(&b, "Duration:  %s\r\n", e.Duration.Round(time.Millisecond))
+	}
+	return b.Bytes(), nil
+}
+
+// Send delivers e right away.
+func (m *Mailer) Send(e ipmanager.Event) error {
+	msg, err := m.message(e)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: m.host, InsecureSkipVerify: *smtpInsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if *smtpTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if *smtpTLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if *smtpUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", *smtpUser, *smtpPassword, m.host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+	if err := c.Mail(*smtpFrom); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
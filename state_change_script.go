@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var onStateChange = stringOption("on-state-change", "", "Script to run for every event: acquire, release, release_pending, release_cancelled, dcs_down, dcs_up and operation_failed. The event is passed in VIP_EVENT, VIP_ADDRESS, VIP_IFACE, VIP_TRIGGER_VALUE, VIP_OLD_STATE, VIP_NEW_STATE and VIP_ERROR. Its exit code is only logged. Empty disables it.")
+var onStateChangeTimeout = durationOption("on-state-change-timeout", 30*time.Second, "How long the -on-state-change script may run before it is killed")
+
+// Events waiting for the script beyond which the oldest are dropped
+const stateChangeQueueSize = 20
+
+// The runner of -on-state-change, if any
+var stateChangeScript *StateChangeScript
+
+// StateChangeScript runs a script for every event, one at a time, from a
+// goroutine of its own. Events that arrive while the script runs are
+// queued, a repeat of the last queued event replaces it.
+type StateChangeScript struct {
+	path    string
+	timeout time.Duration
+
+	lock    sync.Mutex
+	pending []ipmanager.Event
+	wakeup  chan struct{}
+}
+
+func NewStateChangeScript(path string, timeout time.Duration) *StateChangeScript {
+	return &StateChangeScript{path: path, timeout: timeout, wakeup: make(chan struct{}, 1)}
+}
+
+func (s *StateChangeScript) Notify(e ipmanager.Event) {
+	s.lock.Lock()
+	n := len(s.pending)
+	switch {
+	case n > 0 && s.pending[n-1].Type == e.Type && s.pending[n-1].NewState == e.NewState:
+		s.pending[n-1] = e
+	case n >= stateChangeQueueSize:
+		slog.Warn("Too many events queued for the state change script, dropping the oldest", "script", s.path, "event", s.pending[0].Type)
+		s.pending = append(s.pending[1:], e)
+	default:
+		s.pending = append(s.pending, e)
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the script for the queued events until ctx is done.
+func (s *StateChangeScript) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wakeup:
+		}
+		for {
+			s.lock.Lock()
+			if len(s.pending) == 0 {
+				s.lock.Unlock()
+				break
+			}
+			e := s.pending[0]
+			s.pending = s.pending[1:]
+			s.lock.Unlock()
+			s.run(ctx, e)
+		}
+	}
+}
+
+func (s *StateChangeScript) run(ctx context.Context, e ipmanager.Event) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.path)
+	cmd.Env = append(os.Environ(),
+		"VIP_EVENT="+e.Type,
+		"VIP_ADDRESS="+e.VIP,
+		"VIP_IFACE="+e.Interface,
+		"VIP_TRIGGER_VALUE="+e.TriggerValue,
+		"VIP_OLD_STATE="+strconv.FormatBool(e.OldState),
+		"VIP_NEW_STATE="+strconv.FormatBool(e.NewState),
+		"VIP_ERROR="+e.Error,
+		"VIP_INSTANCE="+instance(),
+	)
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	args := []any{"script", s.path, "event", e.Type, "duration", time.Since(started).Round(time.Millisecond),
+		"output", strings.TrimSpace(string(output))}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		slog.Error("State change script timed out", append(args, "timeout", s.timeout)...)
+	case err != nil:
+		exitCode := -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+		slog.Warn("State change script failed", append(args, "exit_code", exitCode, "error", err)...)
+	default:
+		slog.Info("State change script finished", args...)
+	}
+}
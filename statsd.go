@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+// Stay below the usual MTU, so that packets are not fragmented
+const statsdMaxPacket = 1432
+
+// StatsdSink pushes all metrics to a statsd server over UDP at every flush
+// interval. Labels and the constant tags are sent in the DogStatsD format.
+// Nothing is ever sent from the code paths updating the metrics, so a slow
+// or missing server cannot delay a failover.
+type StatsdSink struct {
+	address  string
+	prefix   string
+	tags     []string
+	interval time.Duration
+
+	conn net.Conn
+	// Counters are sent as the increase since the previous flush
+	last   map[string]float64
+	failed bool
+}
+
+// NewStatsdSink expects tags as a comma separated list of name:value, e.g.
+// cluster:main,node:db1.
+func NewStatsdSink(address, prefix, tags string, interval time.Duration) *StatsdSink {
+	s := &StatsdSink{address: address, prefix: prefix, interval: interval, last: make(map[string]float64)}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return s
+}
+
+func (s *StatsdSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if s.conn != nil {
+				s.conn.Close()
+			}
+			return
+		case <-ticker.C:
+			err := s.flush()
+			if err != nil && !s.failed {
+				slog.Warn("Cannot send metrics to statsd", "statsd", s.address, "error", err)
+			} else if err == nil && s.failed {
+				slog.Info("Sending metrics to statsd again", "statsd", s.address)
+			}
+			s.failed = err != nil
+		}
+	}
+}
+
+func (s *StatsdSink) line(name string, v float64, typ string, tags []string) string {
+	line := s.prefix + name + ":" + strconv.FormatFloat(v, 'g', -1, 64) + "|" + typ
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// lines formats one sample, updating the last values of counters.
+func (s *StatsdSink) lines(sample metrics.Sample) []string {
+	tags := append([]string(nil), s.tags...)
+	for i, name := range sample.LabelNames {
+		tags = append(tags, name+":"+sample.LabelValues[i])
+	}
+	key := sample.Name + "\x00" + strings.Join(sample.LabelValues, "\x00")
+	delta := func(suffix string, v float64) float64 {
+		d := v - s.last[key+suffix]
+		s.last[key+suffix] = v
+		return d
+	}
+
+	switch sample.Type {
+	case "counter":
+		return []string{s.line(sample.Name, delta("", sample.Value), "c", tags)}
+	case "gauge":
+		// A signed value would be taken as a change of the gauge
+		if sample.Value < 0 {
+			return []string{s.line(sample.Name, 0, "g", tags), s.line(sample.Name, sample.Value, "g", tags)}
+		}
+		return []string{s.line(sample.Name, sample.Value, "g", tags)}
+	case "histogram":
+		return []string{
+			s.line(sample.Name+"_sum", delta("_sum", sample.Sum), "c", tags),
+			s.line(sample.Name+"_count", delta("_count", sample.Count), "c", tags),
+		}
+	}
+	return nil
+}
+
+func (s *StatsdSink) flush() error {
+	if s.conn == nil {
+		// Resolved here and not at startup, so the server may come and go
+		conn, err := net.DialTimeout("udp", s.address, s.interval)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, sample := range metrics.Gather() {
+		for _, line := range s.lines(sample) {
+			if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+				packets = append(packets, append([]byte(nil), buf.Bytes()...))
+				buf.Reset()
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+
+	for _, p := range packets {
+		s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := s.conn.Write(p); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("sending %d bytes: %w", len(p), err)
+		}
+	}
+	return nil
+}
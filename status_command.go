@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Exit codes of the status command
+const (
+	statusInSync      = 0
+	statusDiverged    = 1
+	statusUnreachable = 2
+)
+
+// statusCommand implements "vip-manager status [config file]". It asks the
+// running instance for its status on -control-socket and returns the exit
+// code.
+func statusCommand(args []string) int {
+	asJSON := flag.Bool("json", false, "Print the status as JSON, as served on /status")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s status [options] [config file]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Shows the status of the running instance, read from -control-socket. Exits with 0 if the virtual IP is in the desired state, 1 if not and 2 if no instance answers.")
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		return statusInSync
+	} else if err != nil {
+		return statusUnreachable
+	}
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		flag.Set("config", flag.Arg(0))
+	default:
+		flag.Usage()
+		return statusUnreachable
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusUnreachable
+	}
+	if *controlSocket == "" {
+		fmt.Fprintln(os.Stderr, "cannot ask the running instance: -control-socket is not set")
+		return statusUnreachable
+	}
+	path := controlSocketPath(*controlSocket)
+	raw, err := controlCommand(path, controlRequest{Command: "status"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot ask the running instance on %s: %s\n", path, err)
+		return statusUnreachable
+	}
+	var s statusResponse
+	if err := json.Unmarshal(raw, &s); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid answer on %s: %s\n", path, err)
+		return statusUnreachable
+	}
+
+	if *asJSON {
+		fmt.Println(string(raw))
+	} else {
+		printStatus(s)
+	}
+	if s.DesiredState != s.ActualState {
+		return statusDiverged
+	}
+	return statusInSync
+}
+
+// controlCommand sends req to the control socket at path and returns the
+// result.
+func controlCommand(path string, req controlRequest) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		controlResponse
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// printStatus prints s for humans.
+func printStatus(s statusResponse) {
+	ago := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(*t).Round(time.Second))
+	}
+	state := func(held bool) string {
+		if held {
+			return "held"
+		}
+		return "released"
+	}
+
+	fmt.Printf("vip-manager %s, instance %s\n", s.Version, s.Instance)
+	if s.DesiredState == s.ActualState {
+		fmt.Printf("State:           %s, in sync\n", state(s.ActualState))
+	} else {
+		fmt.Printf("State:           %s, but should be %s\n", state(s.ActualState), state(s.DesiredState))
+	}
+	if s.Maintenance {
+		fmt.Println("Maintenance:     active, the virtual IP is neither added nor removed")
+	}
+	for _, a := range s.Addresses {
+		present := "missing"
+		if a.Present {
+			present = "present"
+		}
+		fmt.Printf("Address:         %s on %s, %s\n", a.Address, a.Interface, present)
+	}
+	leader := fmt.Sprintf("%s = %q, revision %d", s.TriggerKey, s.TriggerValue, s.TriggerRevision)
+	if s.TriggerStale {
+		leader += ", stale"
+	}
+	fmt.Printf("Leader key:      %s\n", leader)
+	fmt.Printf("Last DCS read:   %s\n", ago(s.LastDCSRead))
+	for _, v := range s.Quorum {
+		vote := fmt.Sprintf("not leader, read %q", v.Value)
+		if v.Leader {
+			vote = "leader"
+		}
+		switch {
+		case v.Error != "":
+			vote = "stopped: " + v.Error
+		case v.LastReport == nil:
+			vote = "no vote yet"
+		case !v.Fresh:
+			vote += ", stale"
+		}
+		fmt.Printf("Quorum member:   %s, %s, last read %s\n", v.Member, vote, ago(v.LastReport))
+	}
+	if s.Healthy {
+		fmt.Println("Health:          ok")
+	} else {
+		fmt.Println("Health:          failing, see /healthz or the log")
+	}
+	if len(s.Managers) > 1 {
+		for _, m := range s.Managers {
+			health := "ok"
+			if !m.Healthy {
+				health = "failing: " + m.Error
+			}
+			fmt.Printf("Manager:         %s, %s\n", m.Name, health)
+		}
+	}
+	fmt.Printf("Last transition: %s\n", ago(s.LastTransition))
+}
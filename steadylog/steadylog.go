@@ -0,0 +1,48 @@
+// Package steadylog logs lines that describe a steady state, e.g. the
+// state of the virtual IP at every recheck, only when they change.
+package steadylog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// In nanoseconds, see SetHeartbeat
+var heartbeat = int64(5 * time.Minute)
+
+// SetHeartbeat sets how often an unchanged line is repeated anyway, so the
+// log shows the process is still alive. 0 repeats it every time.
+func SetHeartbeat(interval time.Duration) {
+	atomic.StoreInt64(&heartbeat, int64(interval))
+}
+
+// Logger suppresses a line that is the same as the previous one, message
+// and attributes included, until the heartbeat interval has passed.
+type Logger struct {
+	lock     sync.Mutex
+	last     string
+	loggedAt time.Time
+}
+
+// Log logs msg at level unless it repeats the previous line within the
+// heartbeat interval.
+func (l *Logger) Log(level slog.Level, msg string, args ...any) {
+	line := fmt.Sprintf("%q %v", msg, args)
+
+	l.lock.Lock()
+	now := time.Now()
+	repeated := line == l.last && now.Sub(l.loggedAt) < time.Duration(atomic.LoadInt64(&heartbeat))
+	if !repeated {
+		l.last = line
+		l.loggedAt = now
+	}
+	l.lock.Unlock()
+
+	if !repeated {
+		slog.Log(context.Background(), level, msg, args...)
+	}
+}
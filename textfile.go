@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+)
+
+var textfilePath = stringOption("textfile-path", "", "Write the metrics in the Prometheus text format to this file for the textfile collector of node_exporter, e.g. /var/lib/node_exporter/textfile_collector/vip-manager.prom. Removed on exit. Empty disables it.")
+var textfileInterval = durationOption("textfile-interval", 15*time.Second, "How often -textfile-path is written")
+
+// TextfileWriter writes all metrics to a file at every interval. The file is
+// replaced by a rename, so node_exporter never reads a partial one.
+type TextfileWriter struct {
+	path     string
+	interval time.Duration
+	failed   bool
+}
+
+func NewTextfileWriter(path string, interval time.Duration) *TextfileWriter {
+	return &TextfileWriter{path: path, interval: interval}
+}
+
+// Run writes the file until ctx is done, then removes it, so no stale
+// values are left behind.
+func (t *TextfileWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		err := t.write()
+		if err != nil && !t.failed {
+			slog.Warn("Cannot write the metrics file", "textfile", t.path, "error", err)
+		} else if err == nil && t.failed {
+			slog.Info("Writing the metrics file again", "textfile", t.path)
+		}
+		t.failed = err != nil
+
+		select {
+		case <-ctx.Done():
+			if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Cannot remove the metrics file", "textfile", t.path, "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *TextfileWriter) write() error {
+	// node_exporter only reads files ending in .prom
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), "."+filepath.Base(t.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := metrics.WritePrometheus(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
@@ -0,0 +1,102 @@
+// Package tracelog logs below debug level what vip-manager runs and reads,
+// e.g. every command with its output and every response of the DCS, for
+// support cases. Secrets are redacted.
+package tracelog
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Level of trace messages, below slog.LevelDebug
+const Level = slog.LevelDebug - 4
+
+// Enabled reports whether trace messages are logged. Callers check it
+// before preparing expensive arguments.
+func Enabled() bool {
+	return slog.Default().Enabled(context.Background(), Level)
+}
+
+// Log logs msg at trace level, with secrets in string arguments redacted.
+func Log(msg string, args ...any) {
+	if !Enabled() {
+		return
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		name, _ := args[i].(string)
+		if isSecretName(name) {
+			args[i+1] = redacted
+			continue
+		}
+		switch v := args[i+1].(type) {
+		case string:
+			args[i+1] = Redact(v)
+		case []string:
+			args[i+1] = RedactAll(v)
+		}
+	}
+	slog.Log(context.Background(), Level, msg, args...)
+}
+
+const redacted = "xxxxx"
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "password") || strings.Contains(name, "token") || strings.Contains(name, "secret")
+}
+
+// Settings like password=... in a PostgreSQL connection string
+var secretSetting = regexp.MustCompile(`(?i)\b(password|passwd|token|secret)(\s*=\s*)('[^']*'|\S+)`)
+
+// Values of secret options, hidden wherever they show up
+var secrets struct {
+	lock     sync.Mutex
+	replacer *strings.Replacer
+}
+
+// SetSecrets sets the values that Redact hides wherever they show up, e.g.
+// a token in a command line. Empty values are ignored.
+func SetSecrets(values []string) {
+	var pairs []string
+	for _, v := range values {
+		if v != "" {
+			pairs = append(pairs, v, redacted)
+		}
+	}
+	secrets.lock.Lock()
+	defer secrets.lock.Unlock()
+	secrets.replacer = nil
+	if len(pairs) > 0 {
+		secrets.replacer = strings.NewReplacer(pairs...)
+	}
+}
+
+// Redact hides passwords in URLs, secret settings and the values of secret
+// options in s.
+func Redact(s string) string {
+	secrets.lock.Lock()
+	replacer := secrets.replacer
+	secrets.lock.Unlock()
+	if replacer != nil {
+		s = replacer.Replace(s)
+	}
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			s = u.Redacted()
+		}
+	}
+	return secretSetting.ReplaceAllString(s, "${1}${2}"+redacted)
+}
+
+// RedactAll redacts every element, e.g. of a command line.
+func RedactAll(values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = Redact(v)
+	}
+	return result
+}
@@ -0,0 +1,327 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Spans are sent at least this often, or when this many have ended
+const (
+	exportInterval  = 5 * time.Second
+	exportBatchSize = 512
+	// Spans beyond this are dropped while the collector is unreachable
+	exportQueueSize = 2048
+)
+
+// Exporter sends ended spans to an OTLP collector over HTTP, with the JSON
+// encoding of the protocol.
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	resource []keyValue
+	client   *http.Client
+	spans    chan *Span
+	dropped  int64
+}
+
+// Setup configures tracing from the OTEL_ environment variables and
+// returns the exporter, which has to be run, or nil if tracing is disabled.
+// resource holds defaults for the resource attributes, OTEL_SERVICE_NAME
+// and OTEL_RESOURCE_ATTRIBUTES override them.
+func Setup(resource map[string]string) (*Exporter, error) {
+	if b, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); b {
+		return nil, nil
+	}
+	if e := os.Getenv("OTEL_TRACES_EXPORTER"); e == "none" {
+		return nil, nil
+	} else if e != "" && e != "otlp" {
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER=%s is not supported, expected otlp or none", e)
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: %s", endpoint, err)
+	}
+	protocol := firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %s is not supported, expected http/json", protocol)
+	}
+
+	timeout := 10 * time.Second
+	if ms := firstEnv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"); ms != "" {
+		n, err := strconv.Atoi(ms)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid OTLP timeout %q, expected milliseconds", ms)
+		}
+		timeout = time.Duration(n) * time.Millisecond
+	}
+
+	s, err := newSampler(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]string)
+	for k, v := range resource {
+		attributes[k] = v
+	}
+	for k, v := range parsePairs(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")) {
+		attributes[k] = v
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attributes["service.name"] = name
+	}
+	var resourceAttributes []any
+	for k, v := range attributes {
+		resourceAttributes = append(resourceAttributes, k, v)
+	}
+
+	e := &Exporter{
+		endpoint: endpoint,
+		headers:  parsePairs(firstEnv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", "OTEL_EXPORTER_OTLP_HEADERS")),
+		resource: encodeAttributes(resourceAttributes),
+		client:   &http.Client{Timeout: timeout},
+		spans:    make(chan *Span, exportQueueSize),
+	}
+	exporter, sampler = e, s
+	atomic.StoreInt32(&enabled, 1)
+	return e, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parsePairs parses key=value,key=value with URL encoded values, the
+// format of OTEL_RESOURCE_ATTRIBUTES and OTEL_EXPORTER_OTLP_HEADERS.
+func parsePairs(s string) map[string]string {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			v = unescaped
+		}
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return pairs
+}
+
+// newSampler returns the sampler for new traces. Children always follow
+// their parent, so the parentbased variants are the same as the plain ones.
+func newSampler(name, arg string) (func(TraceID) bool, error) {
+	switch strings.TrimPrefix(name, "parentbased_") {
+	case "", "always_on":
+		return ratioSampler(1), nil
+	case "always_off":
+		return ratioSampler(0), nil
+	case "traceidratio":
+		ratio := 1.0
+		if arg != "" {
+			var err error
+			ratio, err = strconv.ParseFloat(arg, 64)
+			if err != nil || ratio < 0 || ratio > 1 {
+				return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q, expected a ratio from 0 to 1", arg)
+			}
+		}
+		return ratioSampler(ratio), nil
+	}
+	return nil, fmt.Errorf("OTEL_TRACES_SAMPLER=%s is not supported", name)
+}
+
+func (e *Exporter) add(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// Run sends the spans in batches until ctx is done, and then the remaining
+// ones.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			atomic.StoreInt32(&enabled, 0)
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.send(batch)
+			return
+		}
+		e.send(batch)
+		batch = nil
+	}
+}
+
+func (e *Exporter) send(batch []*Span) {
+	if dropped := atomic.SwapInt64(&e.dropped, 0); dropped > 0 {
+		slog.Warn("Dropped trace spans, the OTLP collector does not keep up", "dropped", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		slog.Error("Cannot encode trace spans", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Cannot export trace spans", "endpoint", e.endpoint, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Error("Cannot export trace spans", "endpoint", e.endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Error("Cannot export trace spans", "endpoint", e.endpoint, "spans", len(batch), "status", resp.Status)
+	}
+}
+
+// The OTLP JSON encoding, see opentelemetry-proto. Ids are hex, 64 bit
+// integers are strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Links             []link     `json:"links,omitempty"`
+		Status            status     `json:"status"`
+	}
+	link struct {
+		TraceID string `json:"traceId"`
+		SpanID  string `json:"spanId"`
+	}
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+// Span kind internal and status code error, the status of other spans is
+// left unset
+const (
+	kindInternal = 1
+	statusError  = 2
+)
+
+func (e *Exporter) encode(batch []*Span) exportRequest {
+	scope := scopeSpans{}
+	scope.Scope.Name = "vip-manager"
+	for _, s := range batch {
+		encoded := span{
+			TraceID:           s.context.TraceID.String(),
+			SpanID:            s.context.SpanID.String(),
+			Name:              s.name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attributes),
+		}
+		if s.parent != (SpanID{}) {
+			encoded.ParentSpanID = s.parent.String()
+		}
+		for _, l := range s.links {
+			encoded.Links = append(encoded.Links, link{TraceID: l.TraceID.String(), SpanID: l.SpanID.String()})
+		}
+		if s.err != nil {
+			encoded.Status = status{Code: statusError, Message: s.err.Error()}
+		}
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	rs := resourceSpans{ScopeSpans: []scopeSpans{scope}}
+	rs.Resource.Attributes = e.resource
+	return exportRequest{ResourceSpans: []resourceSpans{rs}}
+}
+
+// encodeAttributes encodes key and value pairs.
+func encodeAttributes(pairs []any) []keyValue {
+	var attributes []keyValue
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, _ := pairs[i].(string)
+		var value map[string]any
+		switch v := pairs[i+1].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint64:
+			value = map[string]any{"intValue": strconv.FormatUint(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		attributes = append(attributes, keyValue{Key: key, Value: value})
+	}
+	return attributes
+}
@@ -0,0 +1,152 @@
+// Package tracing records spans of failover operations and exports them
+// over OTLP, configured by the standard OTEL_ environment variables. Without
+// an exporter endpoint nothing is recorded: Start returns nil and all
+// methods of a nil *Span do nothing, so instrumented code costs a single
+// atomic load.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Set by Setup when spans are exported
+var enabled int32
+
+// The exporter spans are handed to and the sampler for new traces
+var (
+	exporter *Exporter
+	sampler  func(TraceID) bool
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+// SpanContext identifies a span, to start children of it or link to it.
+// The zero value is no span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether c names a span.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{}
+}
+
+// Span is an operation with its duration. Methods may be called on a nil
+// *Span, which is returned when tracing is disabled or the trace is not
+// sampled.
+type Span struct {
+	lock       sync.Mutex
+	name       string
+	context    SpanContext
+	parent     SpanID
+	start, end time.Time
+	attributes []any
+	links      []SpanContext
+	err        error
+	ended      bool
+}
+
+// Enabled reports whether spans are recorded.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Start starts a span named name, a child of parent if that is valid and
+// otherwise the root of a new trace, if the sampler picks it. attributes
+// are key and value pairs.
+func Start(name string, parent SpanContext, attributes ...any) *Span {
+	return StartAt(name, parent, time.Now(), attributes...)
+}
+
+// StartAt is Start for a span that began at start, e.g. a wait that is only
+// known to have been one once it is over.
+func StartAt(name string, parent SpanContext, start time.Time, attributes ...any) *Span {
+	if !Enabled() {
+		return nil
+	}
+	s := &Span{name: name, start: start, attributes: attributes}
+	if parent.IsValid() {
+		s.context.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.context.TraceID[:])
+		if !sampler(s.context.TraceID) {
+			return nil
+		}
+	}
+	rand.Read(s.context.SpanID[:])
+	return s
+}
+
+// Context returns what identifies s, the zero SpanContext for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttributes adds key and value pairs.
+func (s *Span) SetAttributes(attributes ...any) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.attributes = append(s.attributes, attributes...)
+	s.lock.Unlock()
+}
+
+// AddLink relates s to another span, e.g. in another trace.
+func (s *Span) AddLink(c SpanContext) {
+	if s == nil || !c.IsValid() {
+		return
+	}
+	s.lock.Lock()
+	s.links = append(s.links, c)
+	s.lock.Unlock()
+}
+
+// End finishes s and hands it to the exporter. A non-nil err marks the
+// operation as failed. Later calls do nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.err = err
+	s.lock.Unlock()
+	exporter.add(s)
+}
+
+// ratioSampler samples the given fraction of traces, decided by the trace
+// id like other OpenTelemetry implementations do.
+func ratioSampler(ratio float64) func(TraceID) bool {
+	if ratio >= 1 {
+		return func(TraceID) bool { return true }
+	}
+	bound := uint64(ratio * (1 << 63))
+	return func(id TraceID) bool {
+		return binary.BigEndian.Uint64(id[8:])>>1 < bound
+	}
+}
+
+func (id TraceID) String() string {
+	return fmt.Sprintf("%x", id[:])
+}
+
+func (id SpanID) String() string {
+	return fmt.Sprintf("%x", id[:])
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/cybertec-postgresql/vip-manager/tracing"
+)
+
+// setupTracing enables OpenTelemetry tracing of the transitions if the
+// OTEL_ environment variables name an exporter endpoint. The cluster and
+// node are best added through OTEL_RESOURCE_ATTRIBUTES. It returns the
+// exporter to run, or nil.
+func setupTracing() *tracing.Exporter {
+	resource := map[string]string{
+		"service.name":        "vip-manager",
+		"service.version":     version,
+		"service.instance.id": instance(),
+	}
+	if host, err := os.Hostname(); err == nil {
+		resource["host.name"] = host
+	}
+	exporter, err := tracing.Setup(resource)
+	if err != nil {
+		slog.Error("Tracing disabled", "error", err)
+		return nil
+	}
+	if exporter != nil {
+		slog.Info("Tracing enabled, exporting spans over OTLP")
+	}
+	return exporter
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// configProblems collects everything that is wrong with the configuration,
+// so all of it can be reported at once.
+type configProblems []string
+
+func (p *configProblems) add(option, format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf("-%s: %s", option, fmt.Sprintf(format, args...)))
+}
+
+func (p configProblems) Error() string {
+	return "invalid configuration:\n  " + strings.Join(p, "\n  ")
+}
+
+func isUnset(value string) bool {
+	return value == "" || value == "none"
+}
+
+func checkPrefixLength(p *configProblems, option string, vip net.IP, mask int) {
+	bits := 8 * net.IPv4len
+	if vip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	if mask != -1 && (mask < 1 || mask > bits) {
+		p.add(option, "prefix length %d is out of range, expected 1 to %d, or -1 for the default", mask, bits)
+	}
+}
+
+// checkVIPAddress checks an address option like -ip, which may carry its
+// own prefix length, together with its mask option.
+func checkVIPAddress(p *configProblems, option, value, maskOption string, mask int, example string) net.IP {
+	vip, length, err := parseVIP(value)
+	if err != nil {
+		p.add(option, "%q is not an IP address, expected e.g. %s or %s/24", value, example, example)
+		return nil
+	}
+	switch {
+	case length == -1:
+		length = mask
+		checkPrefixLength(p, maskOption, vip, length)
+	case mask != -1 && mask != length:
+		p.add(maskOption, "%d conflicts with the prefix length in -%s %s, give only one of them", mask, option, value)
+	default:
+		checkPrefixLength(p, option, vip, length)
+	}
+	checkHostAddress(p, option, vip, length)
+	return vip
+}
+
+func checkHostAddress(p *configProblems, option string, vip net.IP, length int) {
+	if *vipAddressWarnOnly {
+		return
+	}
+	if problem := hostAddressProblem(vip, length); problem != "" {
+		p.add(option, "%s is %s, expected a host address, or set -vip-address-warn-only", vip, problem)
+	}
+}
+
+// Whether validateConfig checks that the interfaces exist, validate-config
+// only does so when asked to
+var checkInterfaces = true
+
+func checkInterface(p *configProblems, option, name string) {
+	// A missing interface is waited for at startup then
+	if !checkInterfaces || *interfaceWaitTimeout > 0 || *interfaceWaitPolicy == "wait" {
+		return
+	}
+	if _, err := net.InterfaceByName(name); err != nil {
+		p.add(option, "interface %q does not exist, expected the name of a local interface as listed by \"ip link\"", name)
+	}
+}
+
+// validateConfig checks the options after they were loaded from flags,
+// environment and config file, before anything is started.
+func validateConfig() error {
+	var p configProblems
+	checkOptions(&p)
+
+	var vip net.IP
+	if isUnset(*ip) {
+		p.add("ip", "is mandatory, e.g. 10.1.2.3")
+	} else {
+		vip = checkVIPAddress(&p, "ip", *ip, "mask", *mask, "10.1.2.3")
+	}
+
+	if *ip6 != "" {
+		vip6 := checkVIPAddress(&p, "ip6", *ip6, "mask6", *mask6, "fd00::10")
+		if vip6 != nil && vip6.To4() != nil {
+			p.add("ip6", "%q is not an IPv6 address, expected e.g. fd00::10", *ip6)
+		}
+		if vip != nil && vip.To4() == nil {
+			p.add("ip6", "requires an IPv4 address in -ip, %s is IPv6", vip)
+		}
+	}
+
+	if isUnset(*iface) {
+		p.add("iface", "is mandatory, e.g. eth0")
+	} else {
+		checkInterface(&p, "iface", *iface)
+	}
+	if isUnset(*key) {
+		p.add("key", "is mandatory, e.g. /service/batman/leader")
+	}
+	if _, err := nodeName(); err != nil {
+		p.add("host", "is not set and the hostname cannot be read (%s), expected the name of this node as used by Patroni", err)
+	}
+
+	switch *endpointType {
+	case "etcd", "consul":
+		u, err := url.Parse(*endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			p.add("dcs-endpoint", "%q is not a valid URL for %s, expected e.g. http://10.1.2.3:%s",
+				*endpoint, *endpointType, map[string]string{"etcd": "2379", "consul": "8500"}[*endpointType])
+		}
+	}
+	if *dcsQuorum > 0 {
+		endpoints, err := quorumEndpoints()
+		if err != nil {
+			p.add("dcs-quorum-endpoints", "%s", err)
+		} else if *dcsQuorum > len(endpoints) {
+			p.add("dcs-quorum", "is %d, but there are only %d endpoints in -dcs-endpoint and -dcs-quorum-endpoints", *dcsQuorum, len(endpoints))
+		}
+		for i, e := range endpoints {
+			if i == 0 {
+				// -dcs-endpoint, checked above
+				continue
+			}
+			u, err := url.Parse(e.endpoint)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				p.add("dcs-quorum-endpoints", "%q is not a valid URL, expected e.g. %s=http://10.1.2.3:%s",
+					e.endpoint, e.endpointType, map[string]string{"etcd": "2379", "consul": "8500"}[e.endpointType])
+			}
+		}
+		if *dcsQuorumStaleAfter <= 0 {
+			p.add("dcs-quorum-stale-after", "must be positive, e.g. 30s")
+		}
+	}
+
+	seen := make(map[string]bool)
+	if vip != nil {
+		seen[vip.String()] = true
+	}
+	for _, e := range vips {
+		if seen[e.vip.String()] {
+			p.add("vip", "%s is configured more than once", e.vip)
+		}
+		seen[e.vip.String()] = true
+		checkPrefixLength(&p, "vip", e.vip, e.mask)
+		checkHostAddress(&p, "vip", e.vip, e.mask)
+		if e.iface != "" {
+			checkInterface(&p, "vip", e.iface)
+		}
+	}
+
+	if _, err := parseLogLevel(*logLevelName); err != nil {
+		p.add("log-level", "%q is not a log level, expected trace, debug, info, warn or error", *logLevelName)
+	}
+	for _, target := range logTargets() {
+		switch target {
+		case "stderr", "journald", "auto":
+		case "syslog":
+			if _, err := parseSyslogFacility(*syslogFacility); err != nil {
+				p.add("syslog-facility", "%q is not a syslog facility, expected e.g. daemon or local0", *syslogFacility)
+			}
+		default:
+			p.add("log-target", "%q is not supported, expected stderr, syslog, journald or auto", target)
+		}
+	}
+
+	if *httpListen != "" {
+		if _, _, err := net.SplitHostPort(*httpListen); err != nil {
+			p.add("http-listen", "%q is not a listen address, expected host:port, e.g. localhost:9090", *httpListen)
+		}
+	}
+	if (*httpTLSCert == "") != (*httpTLSKey == "") {
+		p.add("http-tls-cert", "and -http-tls-key have to be given together")
+	} else if *httpTLSCert != "" {
+		if _, _, err := loadCertificates(); err != nil {
+			p.add("http-tls-cert", "cannot be loaded: %s", err)
+		}
+	}
+	if *httpClientCA != "" && *httpTLSCert == "" {
+		p.add("http-client-ca", "requires -http-tls-cert and -http-tls-key")
+	}
+	if *pprofListen != "" && !isLoopbackListen(*pprofListen) {
+		p.add("pprof-listen", "%q is not a loopback address, expected e.g. localhost:6060", *pprofListen)
+	}
+	if *statsdAddress != "" {
+		if _, _, err := net.SplitHostPort(*statsdAddress); err != nil {
+			p.add("statsd-address", "%q is not an address, expected host:port, e.g. localhost:8125", *statsdAddress)
+		}
+		if *statsdInterval <= 0 {
+			p.add("statsd-interval", "must be positive")
+		}
+		for _, tag := range strings.Split(*statsdTags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !strings.Contains(tag, ":") {
+				p.add("statsd-tags", "%q is not name:value", tag)
+			}
+		}
+	}
+	if *webhookURLs != "" {
+		for _, u := range strings.Split(*webhookURLs, ",") {
+			if u = strings.TrimSpace(u); u == "" {
+				continue
+			}
+			if parsed, err := url.Parse(u); err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				p.add("webhook-url", "%q is not a URL, expected e.g. https://hooks.example.com/vip", u)
+			}
+		}
+		if *webhookTimeout <= 0 {
+			p.add("webhook-timeout", "must be positive, e.g. 5s")
+		}
+		if *webhookRetries < 0 {
+			p.add("webhook-retries", "must not be negative")
+		}
+		if *webhookBasicAuth != "" && !strings.Contains(*webhookBasicAuth, ":") {
+			p.add("webhook-basic-auth", "expected user:password")
+		}
+	}
+	if *onStateChange != "" {
+		if info, err := os.Stat(*onStateChange); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			p.add("on-state-change", "%q is not an executable file", *onStateChange)
+		}
+		if *onStateChangeTimeout <= 0 {
+			p.add("on-state-change-timeout", "must be positive, e.g. 30s")
+		}
+	}
+	if *textfilePath != "" {
+		if !strings.HasSuffix(*textfilePath, ".prom") {
+			p.add("textfile-path", "%q does not end in .prom, node_exporter would ignore it", *textfilePath)
+		}
+		if *textfileInterval <= 0 {
+			p.add("textfile-interval", "must be positive, e.g. 15s")
+		}
+	}
+	if *holderInfoPrefix != "" {
+		if *holderInfoInterval <= 0 {
+			p.add("holder-info-interval", "must be positive, e.g. 1m")
+		}
+		if *holderInfoTTL <= *holderInfoInterval {
+			p.add("holder-info-ttl", "must be longer than -holder-info-interval, e.g. 3m")
+		}
+		if *endpointType == "consul" && *holderInfoTTL < 10*time.Second {
+			p.add("holder-info-ttl", "must be at least 10s, Consul does not allow shorter sessions")
+		}
+	}
+	if *consulServiceName != "" {
+		if *consulServicePort < 1 || *consulServicePort > 65535 {
+			p.add("consul-service-port", "%d is not a port", *consulServicePort)
+		}
+		if *consulServiceTTL < 3*time.Second {
+			p.add("consul-service-ttl", "must be at least 3s, it is refreshed every third of it")
+		}
+	}
+	if *smtpHost != "" {
+		if *smtpPort < 1 || *smtpPort > 65535 {
+			p.add("smtp-port", "%d is not a port", *smtpPort)
+		}
+		switch *smtpTLS {
+		case "starttls", "tls", "none":
+		default:
+			p.add("smtp-tls", "%q is not supported, expected starttls, tls or none", *smtpTLS)
+		}
+		if *smtpFrom == "" {
+			p.add("smtp-from", "is mandatory with -smtp-host, e.g. vip-manager@example.com")
+		}
+		if strings.Trim(*smtpTo, ", ") == "" {
+			p.add("smtp-to", "is mandatory with -smtp-host, e.g. dba@example.com")
+		}
+		if _, err := NewMailer(); err != nil {
+			p.add("smtp-subject", "is not a valid template: %s", err)
+		}
+		if *smtpRetries < 0 {
+			p.add("smtp-retries", "must not be negative")
+		}
+	}
+	if *auditLogPath != "" && (*auditLogMaxSize <= 0 || *auditLogRetention < 0) {
+		p.add("audit-log-max-size", "must be positive and -audit-log-retention not negative, e.g. 10 and 5")
+	}
+	if *logFilePath != "" && (*logFileMaxSize <= 0 || *logFileMaxBackups < 0) {
+		p.add("log-file-max-size", "must be positive and -log-file-max-backups not negative, e.g. 100 and 5")
+	}
+	if *stateFile != "" && *stateFileMaxAge <= 0 {
+		p.add("state-file-max-age", "must be positive, e.g. 1m")
+	}
+	if *maxConfirmAge != 0 && *maxConfirmAge < 2*time.Second {
+		p.add("max-confirm-age", "must be at least 2s, the leader key is read about every second, or 0 to keep the virtual IP")
+	}
+	if *proxyURL != "" {
+		if u, err := url.Parse(*proxyURL); err != nil || u.Host == "" {
+			p.add("proxy-url", "%q is not a URL, expected e.g. http://proxy:3128", *proxyURL)
+		}
+	}
+
+	if *macvlanName != "" {
+		if _, err := net.ParseMAC(*macvlanMAC); err != nil {
+			p.add("macvlan-mac", "%q is not a MAC address, expected e.g. 02:00:00:00:00:01", *macvlanMAC)
+		}
+	}
+	if *arpSysctls {
+		if *arpAnnounce < 0 || *arpAnnounce > 2 {
+			p.add("arp-announce", "%d is out of range, expected 0 to 2", *arpAnnounce)
+		}
+		if *arpIgnore < 0 || *arpIgnore > 8 {
+			p.add("arp-ignore", "%d is out of range, expected 0 to 8", *arpIgnore)
+		}
+	}
+	if *connectivityTarget != "" && *connectivityInterval <= 0 {
+		p.add("connectivity-check-interval", "must be positive, e.g. 1m")
+	}
+	if *duplicateScanInterval > 0 && !*arpProbe && *arpProbeTimeout <= 0 {
+		p.add("arp-probe-timeout", "must be positive for -duplicate-scan-interval, e.g. 1s")
+	}
+	if *onDuplicateAddress != "" {
+		if info, err := os.Stat(*onDuplicateAddress); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			p.add("on-duplicate-address", "%q is not an executable file", *onDuplicateAddress)
+		}
+	}
+	if *arpProbe && (*arpProbeTimeout <= 0 || *arpProbeRetryInterval <= 0) {
+		p.add("arp-probe-timeout", "and -arp-probe-retry-interval must be positive, e.g. 1s and 5s")
+	}
+
+	// Mutually exclusive options
+	if *carp && *macvlanName != "" {
+		p.add("carp", "cannot be combined with -macvlan")
+	}
+	if *proxyArp {
+		if *carp || *macvlanName != "" {
+			p.add("proxy-arp", "cannot be combined with -carp or -macvlan")
+		}
+		if (vip != nil && vip.To4() == nil) || *ip6 != "" {
+			p.add("proxy-arp", "only works with an IPv4 address in -ip and without -ip6")
+		}
+	}
+
+	// They need the options checked above
+	if len(p) == 0 {
+		checkStartup(&p, vip)
+	}
+
+	if len(p) > 0 {
+		return p
+	}
+	return nil
+}
+
+// checkStartup runs the constructors newManager calls at startup that have
+// no side effects, so a configuration passes only if the daemon accepts it.
+func checkStartup(p *configProblems, vip net.IP) {
+	// The addresses go to the macvlan instead of -iface
+	netIface := net.Interface{Name: *iface}
+	if *macvlanName != "" {
+		netIface = net.Interface{Name: *macvlanName}
+	}
+	var addresses []*ipmanager.IPConfiguration
+	address := func(option string, vip net.IP, length int, iface net.Interface, label string, announce bool) {
+		mask, _ := prefixMask(vip, length)
+		a, err := ipmanager.NewIPConfiguration(vip, mask, iface, label, announce)
+		if err != nil {
+			p.add(option, "%s: %s", vip, err)
+			return
+		}
+		addresses = append(addresses, a)
+	}
+
+	_, length := vipAddress(*ip, *mask)
+	address("ip", vip, length, netIface, "", true)
+	if *ip6 != "" {
+		vip6, length6 := vipAddress(*ip6, *mask6)
+		address("ip6", vip6, length6, netIface, "", true)
+	}
+	for _, e := range vips {
+		vipIface := netIface
+		if e.iface != "" {
+			vipIface = net.Interface{Name: e.iface}
+		}
+		address("vip", e.vip, e.mask, vipIface, e.label, e.announce)
+	}
+
+	// The rules are rendered for all addresses
+	if *firewall == "nft" && len(*p) == 0 {
+		if _, err := ipmanager.NewNftFirewall(instance(), *firewallRules, *firewallStandbyRules, addresses); err != nil {
+			p.add("firewall-rules", "%s", err)
+		}
+	}
+	if *carp {
+		if _, err := ipmanager.NewCarp(*iface, *carpAdvskew, *carpStandbyAdvskew); err != nil {
+			p.add("carp-advskew", "%s", err)
+		}
+	}
+	if *holderInfoPrefix != "" {
+		if _, err := NewHolderInfo(*holderInfoPrefix, *holderInfoInterval, *holderInfoTTL); err != nil {
+			p.add("holder-info-prefix", "%s", err)
+		}
+	}
+	if *consulServiceName != "" {
+		if _, err := NewConsulService(consulServiceAgent(), *consulServiceName, *consulServicePort, *consulServiceTags, *consulServiceTTL); err != nil {
+			p.add("consul-service", "%s", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// How long validate-config -check-dcs waits for the leader key
+const validateDCSTimeout = 10 * time.Second
+
+// validateConfigCommand implements "vip-manager validate-config [file]". It
+// loads and validates the configuration exactly like the daemon does, but
+// never touches any address. It returns the exit code.
+func validateConfigCommand(args []string) int {
+	checkDCS := flag.Bool("check-dcs", false, "Also read the leader key from the DCS")
+	sendTestMail := flag.Bool("send-test-mail", false, "Also send a test message through -smtp-host, to verify the mail routing")
+	flag.BoolVar(&checkInterfaces, "check-interfaces", false, "Also check that the interfaces exist on this host")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s validate-config [options] [config file]\n\n", os.Args[0])
+		fmt.Fprintln(out, "Checks the configuration from the options, environment and config file, and exits with 1 if it is invalid.")
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitFailure
+	}
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		flag.Set("config", flag.Arg(0))
+	default:
+		flag.Usage()
+		return exitFailure
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	for _, w := range deprecationWarnings {
+		fmt.Printf("%s: option %s is deprecated, use %s\n", w.source, w.option, w.replacement)
+	}
+	if err := validateConfig(); err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	lc, err := newLeaderChecker()
+	if err != nil {
+		fmt.Printf("invalid configuration:\n  -dcs-type: cannot create the leader checker: %s\n", err)
+		return exitFailure
+	}
+
+	if *checkDCS {
+		ctx, cancel := context.WithTimeout(context.Background(), validateDCSTimeout)
+		defer cancel()
+		if _, err := readStateOnce(ctx, lc); err != nil {
+			fmt.Printf("cannot read %s from %s: %s\n", *key, *endpoint, err)
+			return exitFailure
+		}
+		key, value, _ := checker.LastValue()
+		fmt.Printf("%s is %q\n", key, value)
+	}
+	if *sendTestMail {
+		if *smtpHost == "" {
+			fmt.Println("cannot send a test message: -smtp-host is not set")
+			return exitFailure
+		}
+		m, _ := NewMailer()
+		if err := m.Send(ipmanager.Event{Type: "test", VIP: *ip, Time: time.Now()}); err != nil {
+			fmt.Printf("cannot send a test message through %s: %s\n", m.address, err)
+			return exitFailure
+		}
+		fmt.Printf("test message sent to %s\n", *smtpTo)
+	}
+	fmt.Println("configuration is valid")
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// TestValidateStartupChecks covers configurations that only the constructors
+// of the daemon reject. validate-config has to reject them as well.
+func TestValidateStartupChecks(t *testing.T) {
+	savedCheckInterfaces := checkInterfaces
+	checkInterfaces = false
+	t.Cleanup(func() {
+		checkInterfaces = savedCheckInterfaces
+		resetOptions(t)
+	})
+	isolateFlags(t)
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+		// Part of the problem, or "" if the configuration is valid
+		want string
+	}{
+		{name: "valid", flags: map[string]string{"vip": "10.1.2.4/24,label=eth0:vip"}},
+		{name: "invalid label", flags: map[string]string{"vip": "10.1.2.4/24,label=bogus"}, want: "-vip"},
+		{name: "missing firewall rules", flags: map[string]string{"firewall": "nft", "firewall-rules": "/nonexistent"}, want: "-firewall-rules"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetOptions(t)
+			options := map[string]string{"ip": "10.1.2.3", "mask": "24", "iface": "eth0", "key": "/service/pgcluster/leader", "host": "node1"}
+			for name, value := range test.flags {
+				options[name] = value
+			}
+			for name, value := range options {
+				if err := flag.Set(name, value); err != nil {
+					t.Fatalf("cannot set -%s: %s", name, err)
+				}
+			}
+			err := validateConfig()
+			if test.want == "" {
+				if err != nil {
+					t.Errorf("validateConfig() = %s, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateConfig() accepted %v", test.flags)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("validateConfig() = %s, want a problem with %s", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/checker"
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// Set when building, e.g. with
+// -ldflags "-X main.version=1.0 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+var buildInfo = metrics.NewGaugeVec("vip_manager_build_info",
+	"Always 1, the labels describe the running build.",
+	"version", "commit", "build_date", "go_version", "checkers", "address_backend")
+
+func versionString() string {
+	return fmt.Sprintf("vip-manager %s (commit %s, built %s, %s %s/%s, checkers %s, addresses via %s)",
+		version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		strings.Join(checker.Types, ","), ipmanager.AddressBackend)
+}
+
+func init() {
+	buildInfo.With(version, commit, buildDate, runtime.Version(),
+		strings.Join(checker.Types, ","), ipmanager.AddressBackend).Set(1)
+}
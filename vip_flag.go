@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+// vipEntry is an additional virtual IP given with -vip, e.g.
+// "10.0.1.5/24,iface=bond1,label=bond1:repl,announce=false". Settings
+// that are left out default to the global ones.
+type vipEntry struct {
+	// As given, e.g. for convert-config
+	spec     string
+	vip      net.IP
+	mask     int
+	iface    string
+	label    string
+	announce bool
+}
+
+// parseVIP parses an address with an optional prefix length or netmask,
+// e.g. 10.1.2.3/24. The prefix length is -1 if none is given.
+func parseVIP(value string) (net.IP, int, error) {
+	address, length := value, -1
+	if i := strings.Index(value, "/"); i >= 0 {
+		var err error
+		length, err = parsePrefixLength(value[i+1:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid prefix length in %q: %s", value, err)
+		}
+		address = value[:i]
+	}
+	vip := net.ParseIP(address)
+	if vip == nil {
+		return nil, 0, fmt.Errorf("invalid address %q", address)
+	}
+	return vip, length, nil
+}
+
+// vipAddress splits a validated address option like -ip. The prefix length
+// given with the address takes precedence over mask.
+func vipAddress(value string, mask int) (net.IP, int) {
+	vip, length, _ := parseVIP(value)
+	if length == -1 {
+		length = mask
+	}
+	return vip, length
+}
+
+// hostAddressProblem describes why vip is not a usable host address in a
+// prefix of length, e.g. "the network address of /24", or returns "".
+func hostAddressProblem(vip net.IP, length int) string {
+	bits := 8 * net.IPv4len
+	if vip4 := vip.To4(); vip4 != nil {
+		vip = vip4
+	} else {
+		bits = 8 * net.IPv6len
+	}
+	// Point-to-point and host prefixes have no network address
+	if length < 1 || length > bits-2 {
+		return ""
+	}
+	mask := net.CIDRMask(length, bits)
+	if vip.Equal(vip.Mask(mask)) {
+		return fmt.Sprintf("the network address of /%d", length)
+	}
+	if bits == 8*net.IPv4len {
+		broadcast := make(net.IP, net.IPv4len)
+		for i := range broadcast {
+			broadcast[i] = vip[i] | ^mask[i]
+		}
+		if vip.Equal(broadcast) {
+			return fmt.Sprintf("the broadcast address of /%d", length)
+		}
+	}
+	return ""
+}
+
+// vipList collects all -vip flags.
+type vipList []vipEntry
+
+func (l *vipList) String() string {
+	var entries []string
+	for _, e := range *l {
+		entries = append(entries, e.vip.String())
+	}
+	return strings.Join(entries, " ")
+}
+
+func (l *vipList) Set(value string) error {
+	parts := strings.Split(value, ",")
+	e := vipEntry{spec: value, announce: true}
+
+	var err error
+	e.vip, e.mask, err = parseVIP(parts[0])
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid setting %q, expected key=value", part)
+		}
+		switch kv[0] {
+		case "iface":
+			e.iface = kv[1]
+		case "label":
+			e.label = kv[1]
+		case "announce":
+			announce, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid announce setting %q", kv[1])
+			}
+			e.announce = announce
+		default:
+			return fmt.Errorf("unknown setting %q", kv[0])
+		}
+	}
+
+	// The interface may only be known later, with -iface
+	if err := ipmanager.CheckLabel(e.vip, e.iface, e.label); err != nil {
+		return err
+	}
+
+	*l = append(*l, e)
+	return nil
+}
+
+// specs returns the entries as they were given.
+func (l *vipList) specs() []string {
+	var specs []string
+	for _, e := range *l {
+		specs = append(specs, e.spec)
+	}
+	return specs
+}
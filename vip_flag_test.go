@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestVIPListLabel makes sure a label the address backend cannot use is
+// rejected with the option, not only when the daemon starts.
+func TestVIPListLabel(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "10.1.3.5/24,iface=eth1,label=eth1:repl"},
+		{value: "10.1.3.5/24,label=eth1:repl"},
+		{value: "10.1.3.5/24,iface=eth1,label=bond1/repl", wantErr: true},
+	}
+	for _, test := range tests {
+		var l vipList
+		if err := l.Set(test.value); (err != nil) != test.wantErr {
+			t.Errorf("Set(%q) = %v, want error %t", test.value, err, test.wantErr)
+		}
+		if test.wantErr && len(l) > 0 {
+			t.Errorf("Set(%q) added %v although it failed", test.value, l.specs())
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/vip-manager/metrics"
+	"github.com/cybertec-postgresql/vip-manager/pkg/ipmanager"
+)
+
+var webhookURLs = stringOption("webhook-url", "", "URLs to POST a JSON message to whenever the virtual IP moves, separated by commas, e.g. a Slack or Teams incoming webhook. Empty disables webhooks.", secret())
+var webhookTimeout = durationOption("webhook-timeout", 5*time.Second, "How long a webhook request may take")
+var webhookRetries = intOption("webhook-retries", 3, "How often to retry a failed webhook request, waiting 1s, 2s, 4s and so on in between")
+var webhookBearerToken = stringOption("webhook-bearer-token", "", "Token sent as bearer token with webhook requests", secret())
+var webhookBasicAuth = stringOption("webhook-basic-auth", "", "user:password sent with webhook requests", secret())
+var webhookDCSEvents = boolOption("webhook-dcs-events", false, "Also send a webhook when the DCS could not be read for -health-dcs-threshold, and when it can be read again")
+
+// Queued events beyond which new ones are dropped
+const webhookQueueSize = 100
+
+var webhookFailures = metrics.NewCounter("vip_manager_webhook_failures_total",
+	"Number of webhook messages that could not be delivered, after all retries or because the queue was full.")
+
+// webhookPayload is the JSON body of a webhook request. text makes it show
+// up in Slack and Teams without further configuration.
+type webhookPayload struct {
+	Text         string    `json:"text"`
+	Event        string    `json:"event"`
+	Node         string    `json:"node"`
+	Instance     string    `json:"instance"`
+	VIP          string    `json:"vip"`
+	OldState     *bool     `json:"old_state,omitempty"`
+	NewState     *bool     `json:"new_state,omitempty"`
+	TriggerKey   string    `json:"trigger_key,omitempty"`
+	TriggerValue *string   `json:"trigger_value,omitempty"`
+	Time         time.Time `json:"time"`
+	Duration     float64   `json:"duration_seconds,omitempty"`
+}
+
+// The webhook of -webhook-url, if any
+var webhook *Webhook
+
+// urlHost is all of a webhook URL that may be logged, the path of e.g. a
+// Slack webhook is a secret.
+func urlHost(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+// Webhook sends events to URLs from a goroutine of its own, so a slow or
+// failing receiver never delays the address operations.
+type Webhook struct {
+	urls    []string
+	client  *http.Client
+	retries int
+	queue   chan ipmanager.Event
+}
+
+func NewWebhook(urls string, timeout time.Duration, retries int) *Webhook {
+	w := &Webhook{
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		queue:   make(chan ipmanager.Event, webhookQueueSize),
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			w.urls = append(w.urls, u)
+		}
+	}
+	return w
+}
+
+// Notify queues e, dropping it if the queue is full. Failed operations are
+// left to the log and the metrics.
+func (w *Webhook) Notify(e ipmanager.Event) {
+	if e.Type == ipmanager.EventOperationFailed {
+		return
+	}
+	select {
+	case w.queue <- e:
+	default:
+		webhookFailures.Inc()
+		slog.Warn("Webhook queue is full, dropping event", "event", e.Type, "vip", e.VIP)
+	}
+}
+
+// Run sends the queued events until ctx is done.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.queue:
+			body, err := json.Marshal(newWebhookPayload(e))
+			if err != nil {
+				slog.Error("Cannot encode webhook", "event", e.Type, "error", err)
+				continue
+			}
+			for _, u := range w.urls {
+				w.deliver(ctx, u, body, e)
+			}
+		}
+	}
+}
+
+func newWebhookPayload(e ipmanager.Event) webhookPayload {
+	node, _ := nodeName()
+	p := webhookPayload{Event: e.Type, Node: node, Instance: instance(), VIP: e.VIP, Time: e.Time}
+	switch e.Type {
+	case ipmanager.EventAcquire, ipmanager.EventRelease, ipmanager.EventReleasePending, ipmanager.EventReleaseCancelled:
+		p.OldState, p.NewState = &e.OldState, &e.NewState
+		p.TriggerKey, p.TriggerValue = e.TriggerKey, &e.TriggerValue
+		p.Duration = e.Duration.Seconds()
+	}
+	switch e.Type {
+	case ipmanager.EventAcquire:
+		p.Text = fmt.Sprintf("%s took over %s (%s), leader key %s is %q", node, e.VIP, p.Instance, e.TriggerKey, e.TriggerValue)
+	case ipmanager.EventRelease:
+		p.Text = fmt.Sprintf("%s released %s (%s), leader key %s is %q", node, e.VIP, p.Instance, e.TriggerKey, e.TriggerValue)
+	case ipmanager.EventReleasePending:
+		p.Text = fmt.Sprintf("%s lost the leadership and releases %s (%s) after the grace period, leader key %s is %q", node, e.VIP, p.Instance, e.TriggerKey, e.TriggerValue)
+	case ipmanager.EventReleaseCancelled:
+		p.Text = fmt.Sprintf("%s keeps %s (%s), the leadership returned within the grace period", node, e.VIP, p.Instance)
+	case ipmanager.EventDCSDown:
+		p.Text = fmt.Sprintf("%s cannot read the DCS for %s (%s)", node, e.VIP, p.Instance)
+	case ipmanager.EventDCSUp:
+		p.Text = fmt.Sprintf("%s can read the DCS again for %s (%s)", node, e.VIP, p.Instance)
+	}
+	return p
+}
+
+// deliver posts body to u, retrying with a backoff.
+func (w *Webhook) deliver(ctx context.Context, u string, body []byte, e ipmanager.Event) {
+	attempts, err := retry(ctx, w.retries, func() error {
+		return w.post(ctx, u, body)
+	})
+	if err != nil {
+		webhookFailures.Inc()
+		slog.Error("Cannot send webhook", "host", urlHost(u), "event", e.Type, "attempts", attempts, "error", err)
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vip-manager/"+version)
+	if *webhookBearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*webhookBearerToken)
+	} else if user, password, ok := strings.Cut(*webhookBasicAuth, ":"); ok {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := w.client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		// Without the URL
+		return urlErr.Err
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}